@@ -0,0 +1,175 @@
+package domaintree
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// AtomicDomainTree is a thread safe domain tree for read-heavy workloads.
+//
+// Readers load an immutable snapshot of the tree without any locking. Writers
+// are serialized, build a copy of the snapshot which shares every unchanged
+// node with it and publish the copy atomically, so a reader never observes a
+// partially applied update and never waits for a writer.
+type AtomicDomainTree[V any] struct {
+	mu sync.Mutex // serializes the writers
+	dt atomic.Pointer[DomainTreeOf[V]]
+}
+
+// NewAtomicDomainTree returns a new AtomicDomainTree which holds interface{} values.
+func NewAtomicDomainTree(opts ...Option) *AtomicDomainTree[interface{}] {
+	return NewAtomicDomainTreeOf[interface{}](opts...)
+}
+
+// NewAtomicDomainTreeOf returns a new AtomicDomainTree which holds values of type V.
+func NewAtomicDomainTreeOf[V any](opts ...Option) *AtomicDomainTree[V] {
+	adt := &AtomicDomainTree[V]{}
+	adt.dt.Store(NewDomainTreeOf[V](opts...))
+	return adt
+}
+
+// Lookup lookups the key in the current snapshot (lock-free).
+func (adt *AtomicDomainTree[V]) Lookup(key string) (*DomainNodeOf[V], bool) {
+	return adt.dt.Load().Lookup(key)
+}
+
+// Walk walks the current snapshot (lock-free).
+func (adt *AtomicDomainTree[V]) Walk(fn func(key string, value V)) {
+	adt.dt.Load().Walk(fn)
+}
+
+// WalkUnicode walks the current snapshot reporting the U-labels (lock-free).
+func (adt *AtomicDomainTree[V]) WalkUnicode(fn func(key string, value V)) {
+	adt.dt.Load().WalkUnicode(fn)
+}
+
+// Add adds a domain and publishes the new snapshot.
+func (adt *AtomicDomainTree[V]) Add(key string, value V) error {
+	adt.mu.Lock()
+	defer adt.mu.Unlock()
+
+	dt := adt.dt.Load().copyPath(key)
+	if err := dt.Add(key, value); err != nil {
+		return err
+	}
+	adt.dt.Store(dt)
+	return nil
+}
+
+// Put adds or replaces a domain, publishes the new snapshot and returns the
+// replaced value.
+func (adt *AtomicDomainTree[V]) Put(key string, value V) (V, bool, error) {
+	adt.mu.Lock()
+	defer adt.mu.Unlock()
+
+	dt := adt.dt.Load().copyPath(key)
+	prev, replaced, err := dt.Put(key, value)
+	if err != nil {
+		return prev, false, err
+	}
+	adt.dt.Store(dt)
+	return prev, replaced, nil
+}
+
+// PutRegex adds or replaces a regular expression, publishes the new snapshot
+// and returns the replaced value.
+func (adt *AtomicDomainTree[V]) PutRegex(key string, value V) (V, bool, error) {
+	adt.mu.Lock()
+	defer adt.mu.Unlock()
+
+	dt := adt.dt.Load().copyRegex()
+	prev, replaced, err := dt.PutRegex(key, value)
+	if err != nil {
+		return prev, false, err
+	}
+	adt.dt.Store(dt)
+	return prev, replaced, nil
+}
+
+// AddRegex adds a regular expression and publishes the new snapshot.
+func (adt *AtomicDomainTree[V]) AddRegex(key string, value V) error {
+	adt.mu.Lock()
+	defer adt.mu.Unlock()
+
+	dt := adt.dt.Load().copyRegex()
+	if err := dt.AddRegex(key, value); err != nil {
+		return err
+	}
+	adt.dt.Store(dt)
+	return nil
+}
+
+// Del deletes the key and publishes the new snapshot.
+func (adt *AtomicDomainTree[V]) Del(key string) bool {
+	_, ok := adt.Remove(key)
+	return ok
+}
+
+// Remove deletes the key, publishes the new snapshot and returns the value of
+// the key.
+func (adt *AtomicDomainTree[V]) Remove(key string) (V, bool) {
+	adt.mu.Lock()
+	dt := adt.dt.Load().copyPath(key)
+	value, ok := dt.Remove(key)
+	if ok {
+		adt.dt.Store(dt)
+	}
+	adt.mu.Unlock()
+	return value, ok
+}
+
+// DelRegex deletes the regex and publishes the new snapshot.
+func (adt *AtomicDomainTree[V]) DelRegex(key string) bool {
+	_, ok := adt.RemoveRegex(key)
+	return ok
+}
+
+// RemoveRegex deletes the regex, publishes the new snapshot and returns the
+// value of the regex.
+func (adt *AtomicDomainTree[V]) RemoveRegex(key string) (V, bool) {
+	adt.mu.Lock()
+	dt := adt.dt.Load().copyRegex()
+	value, ok := dt.RemoveRegex(key)
+	if ok {
+		adt.dt.Store(dt)
+	}
+	adt.mu.Unlock()
+	return value, ok
+}
+
+// Update calls fn with a copy of the current snapshot and publishes the copy
+// if fn returns nil, discarding it otherwise. The writes of fn, like the
+// reload of a large list, become visible at once, and every node on their
+// paths is copied once per Update instead of once per write. fn must not use
+// dt after returning.
+func (adt *AtomicDomainTree[V]) Update(fn func(dt *DomainTreeOf[V]) error) error {
+	adt.mu.Lock()
+	defer adt.mu.Unlock()
+
+	dt := *adt.dt.Load()
+	dt.batch = make(cowSet)
+	if err := fn(&dt); err != nil {
+		return err
+	}
+	dt.batch = nil
+	adt.dt.Store(&dt)
+	return nil
+}
+
+// cowSet holds the nodes copied by the writes of an Update, which the later
+// writes of the Update modify in place. A nil cowSet copies every node.
+type cowSet map[any]bool
+
+func (s cowSet) add(node any) {
+	if s != nil {
+		s[node] = true
+	}
+}
+
+// Store replaces the whole tree with dt, e.g. after a reload has been built
+// aside. dt must not be modified after it has been stored.
+func (adt *AtomicDomainTree[V]) Store(dt *DomainTreeOf[V]) {
+	adt.mu.Lock()
+	adt.dt.Store(dt)
+	adt.mu.Unlock()
+}
@@ -0,0 +1,55 @@
+package domaintree
+
+// The types of the API before the type parameters, which hold interface{}
+// values. DomainTree and LockedDomainTree wrap the trees of interface{} values
+// to keep the Walk passing the *DomainNode of every key as its value, and the
+// other types are the trees of interface{} values.
+type (
+	DomainNode     = DomainNodeOf[interface{}]
+	HashValue      = HashValueOf[interface{}]
+	WildcardHash   = WildcardHashOf[interface{}]
+	PrefixWildcard = PrefixWildcardOf[interface{}]
+	SuffixWildcard = SuffixWildcardOf[interface{}]
+	RegexTree      = RegexTreeOf[interface{}]
+)
+
+// DomainTree is a domain tree which holds interface{} values. The methods of
+// the DomainTreeOf it wraps are promoted, except Walk.
+type DomainTree struct {
+	*DomainTreeOf[interface{}]
+}
+
+// NewDomainTree creates a new domain tree which holds interface{} values.
+func NewDomainTree(opts ...Option) *DomainTree {
+	return &DomainTree{NewDomainTreeOf[interface{}](opts...)}
+}
+
+// Walk walks the domain tree in HierarchicalOrder and passes the *DomainNode
+// of every key as its value.
+func (dt *DomainTree) Walk(fn func(key string, value interface{})) {
+	dt.walkNodes(func(key string, dn *DomainNode) {
+		fn(key, dn)
+	})
+}
+
+// LockedDomainTree is a thread safe domain tree which holds interface{}
+// values. The methods of the LockedDomainTreeOf it wraps are promoted, except
+// Walk.
+type LockedDomainTree struct {
+	*LockedDomainTreeOf[interface{}]
+}
+
+// NewLockedDomainTree returns a new LockedDomainTree which holds interface{}
+// values.
+func NewLockedDomainTree(opts ...Option) *LockedDomainTree {
+	return &LockedDomainTree{NewLockedDomainTreeOf[interface{}](opts...)}
+}
+
+// Walk walks the domain tree like DomainTree.Walk (thread-safe).
+func (dt *LockedDomainTree) Walk(fn func(key string, value interface{})) {
+	dt.RLock()
+	defer dt.RUnlock()
+	dt.dt.walkNodes(func(key string, dn *DomainNode) {
+		fn(key, dn)
+	})
+}
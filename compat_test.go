@@ -0,0 +1,61 @@
+package domaintree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompat(t *testing.T) {
+	// the code written before the type parameters
+	var dt *DomainTree = NewDomainTree()
+	dt.Add("*.example.com", 1)
+	dt.Add("example.org", "a")
+	require.NoError(t, dt.AddRegex(`^www\d+\.`, 2))
+
+	var dn *DomainNode
+	dn, ok := dt.Lookup("a.example.com")
+	require.True(t, ok)
+	require.Equal(t, "*.example.com", dn.GetKey())
+	require.Equal(t, 1, dn.GetValue().(int))
+
+	walked := map[string]interface{}{}
+	dt.Walk(func(key string, value interface{}) {
+		walked[key] = value.(*DomainNode).GetValue()
+	})
+	require.Equal(t, map[string]interface{}{"*.example.com": 1, "example.org": "a", `^www\d+\.`: 2}, walked)
+
+	require.True(t, dt.Del("example.org"))
+	require.True(t, dt.DelRegex(`^www\d+\.`))
+
+	var ldt *LockedDomainTree = NewLockedDomainTree()
+	ldt.Add("example.com", 3)
+	dn, ok = ldt.Lookup("example.com")
+	require.True(t, ok)
+	require.Equal(t, 3, dn.GetValue())
+	ldt.Walk(func(key string, value interface{}) {
+		require.Equal(t, "example.com", key)
+		require.Equal(t, 3, value.(*DomainNode).GetValue())
+	})
+
+	var pwc *PrefixWildcard = NewPrefixWildcard()
+	pwc.AddWildcard("*.example.com", "a")
+	value, ok := pwc.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, "a", value)
+
+	var swc *SuffixWildcard = NewSuffixWildcard()
+	swc.AddWildcard("www.example.*", "b")
+	var wh *WildcardHash = NewWildcardHash(SuffixIndexer)
+	wh.add("example.com", "c", FullHashValueType)
+	var hv *HashValue
+	hv, typ := wh.Lookup("example.com")
+	require.Equal(t, FullHashValueType, typ)
+	require.Equal(t, "c", hv.GetValue())
+
+	var rt *RegexTree = NewRegexTree()
+	require.NoError(t, rt.Add(`^api\.`, "d"))
+	rv, ok := rt.Lookup("api.example.com")
+	require.True(t, ok)
+	require.Equal(t, "d", rv.value)
+}
@@ -0,0 +1,291 @@
+// Package config loads the routes of a declarative file into a domain tree.
+//
+// A route file holds a list of routes, each with a pattern, its kind, a
+// value, a priority and tags. In YAML:
+//
+//	routes:
+//	  - pattern: "*.example.com"
+//	    value: backend-a
+//	    priority: 10
+//	    tags: [public]
+//	  - pattern: '^api\d+\.example\.org$'
+//	    kind: regex
+//	    value: backend-b
+//
+// The same routes in JSON are {"routes": [{"pattern": "*.example.com", ...}]}
+// and in TOML a [[routes]] table per route. The value is decoded into the
+// type of the values of the tree by the decoder of the format.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	domaintree "github.com/detailyang/domaintree-go"
+)
+
+// Kind is the kind of the pattern of a route.
+type Kind string
+
+const (
+	// Domain is a pattern added by Add like example.com, *.example.com or
+	// example.*, the default.
+	Domain Kind = "domain"
+	// Regex is a regular expression added by AddRegex.
+	Regex Kind = "regex"
+)
+
+// Route is a route of a file.
+type Route[V any] struct {
+	Pattern  string   `json:"pattern" yaml:"pattern" toml:"pattern"`
+	Kind     Kind     `json:"kind" yaml:"kind" toml:"kind"`
+	Value    V        `json:"value" yaml:"value" toml:"value"`
+	Priority int      `json:"priority" yaml:"priority" toml:"priority"`
+	Tags     []string `json:"tags" yaml:"tags" toml:"tags"`
+	// Line is the line of the route in the file, 0 if unknown.
+	Line int `json:"-" yaml:"-" toml:"-"`
+}
+
+// HasTag reports whether the route has one of the tags.
+func (r *Route[V]) HasTag(tags ...string) bool {
+	for _, tag := range tags {
+		for _, t := range r.Tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// File is a parsed route file.
+type File[V any] struct {
+	// Name is the name of the file in the errors.
+	Name   string
+	Routes []Route[V]
+}
+
+// Format is the format of a route file.
+type Format uint8
+
+const (
+	YAML Format = iota
+	JSON
+	TOML
+)
+
+func (f Format) String() string {
+	switch f {
+	case YAML:
+		return "yaml"
+	case JSON:
+		return "json"
+	case TOML:
+		return "toml"
+	}
+	return "unknown"
+}
+
+// FormatOf returns the format of the file from its extension: .yaml, .yml,
+// .json or .toml.
+func FormatOf(path string) (Format, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAML, true
+	case ".json":
+		return JSON, true
+	case ".toml":
+		return TOML, true
+	}
+	return 0, false
+}
+
+// Error is an error of a route file, at the line of a route if known.
+type Error struct {
+	File    string
+	Line    int
+	Pattern string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File)
+		b.WriteString(":")
+	}
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "%d:", e.Line)
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+	if e.Pattern != "" {
+		fmt.Fprintf(&b, "%q: ", e.Pattern)
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList is the list of the errors of a route file, in the order of the
+// lines.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Unwrap returns the errors of the list, which errors.Is and errors.As
+// search since Go 1.20.
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, e := range l {
+		errs[i] = e
+	}
+	return errs
+}
+
+// Err returns the list as an error, nil if it is empty.
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// The errors of the routes reported by Parse.
+var (
+	ErrEmptyPattern = errors.New("empty pattern")
+	ErrUnknownKind  = errors.New("unknown kind")
+)
+
+// ParseFile parses the route file of the format given by FormatOf.
+func ParseFile[V any](path string) (*File[V], error) {
+	format, ok := FormatOf(path)
+	if !ok {
+		return nil, fmt.Errorf("config: unknown format of %s", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse[V](f, path, format)
+}
+
+// Parse parses the route file of the format read from r, name being its name
+// in the errors. Every route is checked for an empty pattern, an unknown
+// kind and a duplicate, the errors being returned in an ErrorList.
+func Parse[V any](r io.Reader, name string, format Format) (*File[V], error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []Route[V]
+	switch format {
+	case YAML:
+		routes, err = parseYAML[V](data)
+	case JSON:
+		routes, err = parseJSON[V](data)
+	case TOML:
+		routes, err = parseTOML[V](data)
+	default:
+		return nil, fmt.Errorf("config: unknown format %d", format)
+	}
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) {
+			e.File = name
+			return nil, e
+		}
+		return nil, &Error{File: name, Err: err}
+	}
+
+	f := &File[V]{Name: name, Routes: routes}
+	if err := f.check(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// routeKey identifies a route, the same pattern being allowed as a domain and
+// as a regular expression.
+type routeKey struct {
+	kind    Kind
+	pattern string
+}
+
+// kind returns the kind of the route, Domain if unset.
+func (r *Route[V]) kind() Kind {
+	if r.Kind == "" {
+		return Domain
+	}
+	return r.Kind
+}
+
+func (r *Route[V]) key() routeKey {
+	return routeKey{r.kind(), r.Pattern}
+}
+
+// check checks the routes and defaults their kind to Domain.
+func (f *File[V]) check() error {
+	var errs ErrorList
+	lines := make(map[routeKey]int, len(f.Routes))
+	for i := range f.Routes {
+		r := &f.Routes[i]
+		r.Kind = r.kind()
+
+		var err error
+		switch {
+		case r.Pattern == "":
+			err = ErrEmptyPattern
+		case r.Kind != Domain && r.Kind != Regex:
+			err = fmt.Errorf("%w %q", ErrUnknownKind, r.Kind)
+		default:
+			k := r.key()
+			if line, ok := lines[k]; ok {
+				err = domaintree.ErrDuplicateKey
+				if line > 0 {
+					err = fmt.Errorf("%w, first at line %d", err, line)
+				}
+			} else {
+				lines[k] = r.Line
+			}
+		}
+		if err != nil {
+			errs = append(errs, f.routeError(r, err))
+		}
+	}
+	return errs.Err()
+}
+
+func (f *File[V]) routeError(r *Route[V], err error) *Error {
+	return &Error{File: f.Name, Line: r.Line, Pattern: r.Pattern, Err: err}
+}
+
+// Tagged returns the file holding the routes with one of the tags.
+func (f *File[V]) Tagged(tags ...string) *File[V] {
+	tagged := &File[V]{Name: f.Name}
+	for _, r := range f.Routes {
+		if r.HasTag(tags...) {
+			tagged.Routes = append(tagged.Routes, r)
+		}
+	}
+	return tagged
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	domaintree "github.com/detailyang/domaintree-go"
+	"github.com/stretchr/testify/require"
+)
+
+type backend struct {
+	Name   string `json:"name" yaml:"name" toml:"name"`
+	Weight int    `json:"weight" yaml:"weight" toml:"weight"`
+}
+
+const yamlRoutes = `# routes
+routes:
+  - pattern: "*.example.com"
+    value: {name: a, weight: 1}
+    priority: 10
+    tags: [public]
+
+  - pattern: '^api\d+\.example\.org$'
+    kind: regex
+    value:
+      name: b
+      weight: 2
+`
+
+const jsonRoutes = `{
+  "version": 1,
+  "routes": [
+    {"pattern": "*.example.com", "value": {"name": "a", "weight": 1}, "priority": 10, "tags": ["public"]},
+
+    {
+      "pattern": "^api\\d+\\.example\\.org$",
+      "kind": "regex",
+      "value": {"name": "b", "weight": 2}
+    }
+  ]
+}
+`
+
+const tomlRoutes = `# routes
+[[routes]]
+pattern = "*.example.com"
+value = {name = "a", weight = 1}
+priority = 10
+tags = ["public"]
+
+[[routes]]
+pattern = '^api\d+\.example\.org$'
+kind = "regex"
+[routes.value]
+name = "b"
+weight = 2
+`
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		format Format
+		data   string
+		lines  []int
+	}{
+		{YAML, yamlRoutes, []int{3, 8}},
+		{JSON, jsonRoutes, []int{4, 6}},
+		{TOML, tomlRoutes, []int{2, 8}},
+		{TOML, "routes = [\n  {pattern = \"*.example.com\", value = {name = \"a\", weight = 1}, priority = 10, tags = [\"public\"]},\n" +
+			"  {pattern = '^api\\d+\\.example\\.org$', kind = \"regex\", value = {name = \"b\", weight = 2}},\n]\n", []int{2, 3}},
+	} {
+		f, err := Parse[backend](strings.NewReader(tt.data), "routes", tt.format)
+		require.NoError(t, err, tt.format)
+		require.Equal(t, []Route[backend]{
+			{Pattern: "*.example.com", Kind: Domain, Value: backend{"a", 1}, Priority: 10, Tags: []string{"public"}, Line: tt.lines[0]},
+			{Pattern: `^api\d+\.example\.org$`, Kind: Regex, Value: backend{"b", 2}, Line: tt.lines[1]},
+		}, f.Routes, tt.format)
+
+		require.Len(t, f.Tagged("public").Routes, 1)
+		require.Len(t, f.Tagged("private").Routes, 0)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		format Format
+		data   string
+		expect string
+	}{
+		{YAML, "routes:\n  - pattern: a.com\n  - pattern: ''\n  - pattern: b.com\n    kind: glob\n  - pattern: a.com\n",
+			`routes.yaml:3: empty pattern (and 2 more errors)`},
+		{YAML, "routes:\n  - pattern: [a\n", `routes.yaml: yaml: line 1: did not find expected ',' or ']'`},
+		{YAML, "routes:\n  - pattern: a.com\n    priority: high\n", `routes.yaml:2: yaml: unmarshal errors:`},
+		{JSON, "{\"routes\": [\n{\"pattern\": \"a.com\"},\n{\"pattern\": 1}]}", `routes.json:3: json: cannot unmarshal number`},
+		{JSON, "{\"routes\": [\n{\"pattern\": \"a.com\"}\n{}]}", `routes.json:3: invalid character '{'`},
+		{JSON, "{\"routes\": []} {}", `routes.json:1: data after the top-level object`},
+		{TOML, "[[routes]]\npattern = \"a.com\"\npriority = \"high\"\n", `routes.toml:3: toml: cannot decode TOML string into struct field`},
+		{TOML, "[[routes]]\npattern = \"a.com\"\n\n[[routes]]\npattern = \"a.com\"\n", `routes.toml:4: "a.com": duplicated key, first at line 1`},
+	} {
+		_, err := Parse[backend](strings.NewReader(tt.data), "routes."+tt.format.String(), tt.format)
+		require.Error(t, err)
+		require.True(t, strings.HasPrefix(err.Error(), tt.expect), err.Error())
+	}
+
+	_, err := Parse[backend](strings.NewReader("routes:\n  - pattern: a.com\n  - pattern: a.com\n"), "", YAML)
+	var errs ErrorList
+	require.True(t, errors.As(err, &errs))
+	require.Len(t, errs, 1)
+	require.Equal(t, 3, errs[0].Line)
+	require.True(t, errors.Is(err, domaintree.ErrDuplicateKey))
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"routes.yaml", "routes.json", "routes.toml"} {
+		format, ok := FormatOf(name)
+		require.True(t, ok)
+		data := map[Format]string{YAML: yamlRoutes, JSON: jsonRoutes, TOML: tomlRoutes}[format]
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+
+		dt, err := Load[backend](path)
+		require.NoError(t, err, name)
+		dn, ok := dt.Lookup("api1.example.org")
+		require.True(t, ok)
+		require.Equal(t, backend{"b", 2}, dn.GetValue())
+		dn, ok = dt.Lookup("www.example.com")
+		require.True(t, ok)
+		require.Equal(t, backend{"a", 1}, dn.GetValue())
+		require.Equal(t, 10, dn.GetPriority())
+	}
+
+	_, err := Load[backend](filepath.Join(dir, "routes.ini"))
+	require.Error(t, err)
+
+	path := filepath.Join(dir, "bad.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("routes:\n  - pattern: a.*.com.*\n  - pattern: ok.com\n  - pattern: '(a'\n    kind: regex\n"), 0o644))
+	_, err = Load[string](path, domaintree.Strict())
+	var errs ErrorList
+	require.True(t, errors.As(err, &errs))
+	require.Len(t, errs, 2)
+	require.Equal(t, 2, errs[0].Line)
+	require.True(t, errors.Is(errs[0], domaintree.ErrInvalidWildcard))
+	require.Equal(t, 4, errs[1].Line)
+	require.True(t, strings.HasPrefix(errs[1].Error(), path+`:4: "(a": error parsing regexp`), errs[1].Error())
+}
+
+func TestDiff(t *testing.T) {
+	parse := func(data string) *File[string] {
+		f, err := Parse[string](strings.NewReader(data), "routes.yaml", YAML)
+		require.NoError(t, err)
+		return f
+	}
+
+	old := parse(`routes:
+  - {pattern: "*.example.com", value: a}
+  - {pattern: "www.example.com", value: b}
+  - {pattern: "example.*", value: c, priority: 1}
+  - {pattern: '^api\.', kind: regex, value: d}
+  - {pattern: "gone.example.org", value: e}
+`)
+	dt := domaintree.NewDomainTreeOf[string]()
+	require.NoError(t, old.AddTo(dt))
+
+	require.Equal(t, 0, Diff[string](dt, old).Len())
+
+	f := parse(`routes:
+  - {pattern: "*.example.com", value: a}
+  - {pattern: "www.example.com", value: b2}
+  - {pattern: "example.*", value: c, priority: 2}
+  - {pattern: '^api\.', kind: regex, value: d}
+  - {pattern: '^api\.', value: f}
+  - {pattern: "new.example.org", value: g}
+`)
+	c := Diff[string](dt, f)
+	var del, add []string
+	for _, e := range c.Del {
+		del = append(del, e.Key)
+	}
+	for _, r := range c.Add {
+		add = append(add, r.Pattern)
+	}
+	require.Equal(t, []string{"www.example.com", "gone.example.org", "example.*"}, del)
+	require.Equal(t, []string{"www.example.com", "example.*", `^api\.`, "new.example.org"}, add)
+
+	require.NoError(t, c.Apply(dt))
+	require.Equal(t, 0, Diff[string](dt, f).Len())
+	_, ok := dt.Lookup("gone.example.org")
+	require.False(t, ok)
+	dn, ok := dt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, "b2", dn.GetValue())
+
+	// the regular expressions of NginxMode are domains
+	adt := domaintree.NewAtomicDomainTreeOf[string](domaintree.NginxMode())
+	nginx := parse("routes:\n  - {pattern: '~^www\\.', value: a}\n  - {pattern: .example.com, value: b}\n")
+	require.NoError(t, nginx.AddTo(adt))
+	require.Equal(t, 0, Diff[string](adt, nginx).Len())
+	c = Diff[string](adt, parse("routes:\n  - {pattern: .example.com, value: b}\n"))
+	require.Len(t, c.Del, 1)
+	require.NoError(t, c.Apply(adt))
+	_, ok = adt.Lookup("www.example.org")
+	require.False(t, ok)
+
+	// the changes are applied all or nothing
+	c = Diff[string](dt, parse(`routes:
+  - {pattern: "*.example.com", value: a}
+  - {pattern: "www.example.com", value: b3}
+  - {pattern: '^api\.', kind: regex, value: d2, priority: 1}
+  - {pattern: '(', kind: regex, value: h}
+`))
+	err := c.Apply(dt)
+	var errs ErrorList
+	require.True(t, errors.As(err, &errs))
+	require.Len(t, errs, 1)
+	require.Equal(t, "routes.yaml", errs[0].File)
+	require.Equal(t, 5, errs[0].Line)
+	require.Equal(t, 0, Diff[string](dt, f).Len())
+
+	ldt := domaintree.NewLockedDomainTreeOf[string]()
+	require.NoError(t, f.AddTo(ldt))
+	c = Diff[string](ldt, old)
+	require.NoError(t, c.Apply(ldt))
+	require.Equal(t, 0, Diff[string](ldt, old).Len())
+	dn, ok = ldt.Lookup("api.example.net")
+	require.True(t, ok)
+	require.Equal(t, "d", dn.GetValue())
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parseJSON parses the routes of a JSON file, reading the list of the routes
+// a route at a time to know their lines.
+func parseJSON[V any](data []byte) ([]Route[V], error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	lines := lineCounter{data: data}
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, jsonError(&lines, dec, err)
+	}
+
+	var routes []Route[V]
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, jsonError(&lines, dec, err)
+		}
+		if tok != "routes" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, jsonError(&lines, dec, err)
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return nil, jsonError(&lines, dec, err)
+		}
+		for dec.More() {
+			start := lines.skip(dec.InputOffset())
+			var r Route[V]
+			if err := dec.Decode(&r); err != nil {
+				// the offset of a type error is the one in the route
+				var typ *json.UnmarshalTypeError
+				if errors.As(err, &typ) {
+					return nil, &Error{Line: lines.at(start + typ.Offset), Err: err}
+				}
+				return nil, jsonError(&lines, dec, err)
+			}
+			r.Line = lines.at(start)
+			routes = append(routes, r)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return nil, jsonError(&lines, dec, err)
+		}
+	}
+
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, jsonError(&lines, dec, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, jsonError(&lines, dec, errors.New("data after the top-level object"))
+	}
+	return routes, nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %v instead of %v", delim, tok)
+	}
+	return nil
+}
+
+// jsonError returns the error at its line, or at the current one of the
+// decoder.
+func jsonError(lines *lineCounter, dec *json.Decoder, err error) error {
+	offset := dec.InputOffset()
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		offset = syntax.Offset
+	}
+	return &Error{Line: lines.at(offset), Err: err}
+}
+
+// lineCounter counts the lines of the data up to increasing offsets.
+type lineCounter struct {
+	data   []byte
+	offset int
+	line   int
+}
+
+// skip returns the offset of the next value after the offset, skipping the
+// spaces and the separators.
+func (lc *lineCounter) skip(offset int64) int64 {
+	for offset < int64(len(lc.data)) && strings.IndexByte(" \t\r\n,:", lc.data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// at returns the line of the offset.
+func (lc *lineCounter) at(offset int64) int {
+	i := int(offset)
+	if i > len(lc.data) {
+		i = len(lc.data)
+	}
+	if i < lc.offset {
+		lc.offset, lc.line = 0, 0
+	}
+	lc.line += bytes.Count(lc.data[lc.offset:i], []byte("\n"))
+	lc.offset = i
+	return lc.line + 1
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+)
+
+// parseTOML parses the routes of a TOML file, either [[routes]] tables or an
+// array of inline tables.
+func parseTOML[V any](data []byte) ([]Route[V], error) {
+	var doc struct {
+		Routes []Route[V] `toml:"routes"`
+	}
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		var derr *toml.DecodeError
+		if errors.As(err, &derr) {
+			line, _ := derr.Position()
+			return nil, &Error{Line: line, Err: err}
+		}
+		return nil, err
+	}
+
+	lines := tomlLines(data)
+	if len(lines) == len(doc.Routes) {
+		for i := range doc.Routes {
+			doc.Routes[i].Line = lines[i]
+		}
+	}
+	return doc.Routes, nil
+}
+
+// tomlLines returns the lines of the routes: the ones of the [[routes]]
+// headers or of the first keys of the inline tables.
+func tomlLines(data []byte) []int {
+	var lines []int
+	p := unstable.Parser{}
+	p.Reset(data)
+	for p.NextExpression() {
+		e := p.Expression()
+		switch e.Kind {
+		case unstable.ArrayTable:
+			if key := e.Key(); isRoutesKey(&key) {
+				key = e.Key()
+				key.Next()
+				lines = append(lines, p.Shape(key.Node().Raw).Start.Line)
+			}
+		case unstable.KeyValue:
+			key := e.Key()
+			if !isRoutesKey(&key) || e.Value().Kind != unstable.Array {
+				continue
+			}
+			tables := e.Value().Children()
+			for tables.Next() {
+				table := tables.Node()
+				kvs := table.Children()
+				if table.Kind != unstable.InlineTable || !kvs.Next() {
+					return nil
+				}
+				key := kvs.Node().Key()
+				key.Next()
+				lines = append(lines, p.Shape(key.Node().Raw).Start.Line)
+			}
+		}
+	}
+	return lines
+}
+
+// isRoutesKey reports whether the key is the top-level key routes.
+func isRoutesKey(key *unstable.Iterator) bool {
+	return key.Next() && string(key.Node().Data) == "routes" && !key.Next()
+}
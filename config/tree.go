@@ -0,0 +1,165 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+
+	domaintree "github.com/detailyang/domaintree-go"
+)
+
+// Tree is the part of the API of DomainTree, LockedDomainTree and
+// AtomicDomainTree used to load and diff the routes.
+type Tree[V any] interface {
+	AddWithOptions(key string, value V, opts ...domaintree.AddOption) error
+	AddRegexWithOptions(key string, value V, opts ...domaintree.AddOption) error
+	Del(key string) bool
+	DelRegex(key string) bool
+	WalkEntries(order domaintree.WalkOrder, fn func(e domaintree.Entry[V]) error) error
+}
+
+// Updater is the part of the API of DomainTree, LockedDomainTree and
+// AtomicDomainTree used to apply the changes all at once.
+type Updater[V any] interface {
+	Update(fn func(dt *domaintree.DomainTreeOf[V]) error) error
+}
+
+// Load parses the route file like ParseFile and returns a new tree with the
+// options holding its routes.
+func Load[V any](path string, opts ...domaintree.Option) (*domaintree.DomainTreeOf[V], error) {
+	f, err := ParseFile[V](path)
+	if err != nil {
+		return nil, err
+	}
+
+	dt := domaintree.NewDomainTreeOf[V](opts...)
+	if err := f.AddTo(dt); err != nil {
+		return nil, err
+	}
+	return dt, nil
+}
+
+// AddTo adds the routes to the tree. The routes which fail to be added, e.g.
+// an invalid wildcard or a regular expression which does not compile, are
+// reported in an ErrorList and the other ones are added.
+func (f *File[V]) AddTo(t Tree[V]) error {
+	var errs ErrorList
+	for i := range f.Routes {
+		if err := add(t, &f.Routes[i]); err != nil {
+			errs = append(errs, f.routeError(&f.Routes[i], err))
+		}
+	}
+	return errs.Err()
+}
+
+func add[V any](t Tree[V], r *Route[V]) error {
+	if r.kind() == Regex {
+		return t.AddRegexWithOptions(r.Pattern, r.Value, domaintree.Priority(r.Priority))
+	}
+	return t.AddWithOptions(r.Pattern, r.Value, domaintree.Priority(r.Priority))
+}
+
+// Changes is the set of changes which turns the entries of a tree into the
+// routes of a file.
+type Changes[V any] struct {
+	// File is the name of the file in the errors.
+	File string
+	// Del holds the entries to delete, in the order of WalkEntries.
+	Del []domaintree.Entry[V]
+	// Add holds the routes to add, in the order of the file.
+	Add []Route[V]
+}
+
+// Len returns the number of changes.
+func (c *Changes[V]) Len() int {
+	return len(c.Del) + len(c.Add)
+}
+
+// Diff returns the minimal changes which turn the entries of the tree into
+// the routes of the file: the entries without a route of the same pattern,
+// kind, value and priority are deleted and the routes without such an entry
+// are added, the entry of a modified route being in both and replaced by
+// Apply.
+//
+// The patterns are compared as they were added, the keys of a tree in IDNA
+// mode being in the ASCII form: the tree should have been loaded from the
+// previous version of the file for the changes to be minimal.
+func Diff[V any](t Tree[V], f *File[V]) *Changes[V] {
+	routes := make(map[routeKey]*Route[V], len(f.Routes))
+	for i := range f.Routes {
+		r := &f.Routes[i]
+		routes[r.key()] = r
+	}
+
+	c := &Changes[V]{File: f.Name}
+	kept := make(map[*Route[V]]bool)
+	t.WalkEntries(domaintree.HierarchicalOrder, func(e domaintree.Entry[V]) error {
+		r, ok := routes[routeKey{kindOf(&e), e.Key}]
+		if ok && r.Priority == e.Priority && reflect.DeepEqual(r.Value, e.Value) {
+			kept[r] = true
+			return nil
+		}
+		c.Del = append(c.Del, e)
+		return nil
+	})
+
+	for i := range f.Routes {
+		if !kept[&f.Routes[i]] {
+			c.Add = append(c.Add, f.Routes[i])
+		}
+	}
+	return c
+}
+
+// kindOf returns the kind of the route of the entry: the regular expressions
+// of NginxMode, which start with ~, are added by Add.
+func kindOf[V any](e *domaintree.Entry[V]) Kind {
+	if e.Kind == domaintree.RegexMatchKind && !strings.HasPrefix(e.Key, "~") {
+		return Regex
+	}
+	return Domain
+}
+
+// Apply applies the changes to the tree at once: the entries are deleted,
+// except the ones of the modified routes, which are replaced by Put, and the
+// new routes are added. If a route fails to be added, none of the changes is
+// applied and the routes which failed are reported in an ErrorList.
+func (c *Changes[V]) Apply(t Updater[V]) error {
+	return t.Update(func(dt *domaintree.DomainTreeOf[V]) error {
+		modified := make(map[routeKey]bool, len(c.Add))
+		for i := range c.Add {
+			modified[c.Add[i].key()] = true
+		}
+
+		for i := range c.Del {
+			e := &c.Del[i]
+			switch kind := kindOf(e); {
+			case modified[routeKey{kind, e.Key}]:
+				// replaced by the Put of its route
+			case kind == Regex:
+				dt.DelRegex(e.Key)
+			default:
+				dt.Del(e.Key)
+			}
+		}
+
+		var errs ErrorList
+		for i := range c.Add {
+			r := &c.Add[i]
+			if err := put(dt, r); err != nil {
+				errs = append(errs, &Error{File: c.File, Line: r.Line, Pattern: r.Pattern, Err: err})
+			}
+		}
+		return errs.Err()
+	})
+}
+
+// put adds or replaces the entry of the route.
+func put[V any](dt *domaintree.DomainTreeOf[V], r *Route[V]) error {
+	var err error
+	if r.kind() == Regex {
+		_, _, err = dt.PutRegexWithOptions(r.Pattern, r.Value, domaintree.Priority(r.Priority))
+	} else {
+		_, _, err = dt.PutWithOptions(r.Pattern, r.Value, domaintree.Priority(r.Priority))
+	}
+	return err
+}
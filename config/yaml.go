@@ -0,0 +1,44 @@
+package config
+
+import (
+	"errors"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseYAML parses the routes of a YAML file.
+func parseYAML[V any](data []byte) ([]Route[V], error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, &Error{Line: root.Line, Err: errors.New("routes file is not a mapping")}
+	}
+	var list *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "routes" {
+			list = root.Content[i+1]
+		}
+	}
+	if list == nil || list.Kind == yaml.ScalarNode && list.Tag == "!!null" {
+		return nil, nil
+	}
+	if list.Kind != yaml.SequenceNode {
+		return nil, &Error{Line: list.Line, Err: errors.New("routes is not a list")}
+	}
+
+	routes := make([]Route[V], len(list.Content))
+	for i, node := range list.Content {
+		if err := node.Decode(&routes[i]); err != nil {
+			return nil, &Error{Line: node.Line, Err: err}
+		}
+		routes[i].Line = node.Line
+	}
+	return routes, nil
+}
@@ -2,46 +2,54 @@
 package domaintree
 
 import (
+	"regexp"
 	"strings"
 	"sync"
 )
 
-// DomainNode holds the original domain and value.
-type DomainNode struct {
-	key   string
-	value interface{}
+// DomainNodeOf holds the original domain and a value of type V.
+type DomainNodeOf[V any] struct {
+	key      string
+	value    V
+	priority int
 }
 
 // NewDomainNode creates a new domain node.
-func NewDomainNode(key string, value interface{}) *DomainNode {
-	return &DomainNode{key: key, value: value}
+func NewDomainNode[V any](key string, value V) *DomainNodeOf[V] {
+	return &DomainNodeOf[V]{key: key, value: value}
 }
 
 // GetKey gets the key.
-func (n *DomainNode) GetKey() string {
+func (n *DomainNodeOf[V]) GetKey() string {
 	return n.key
 }
 
 // GetValue gets the value.
-func (n *DomainNode) GetValue() interface{} {
+func (n *DomainNodeOf[V]) GetValue() V {
 	return n.value
 }
 
-// LockedDomainTree is a thread safe domain tree.
-type LockedDomainTree struct {
+// GetPriority gets the priority set by AddWithOptions.
+func (n *DomainNodeOf[V]) GetPriority() int {
+	return n.priority
+}
+
+// LockedDomainTreeOf is a thread safe domain tree which holds values of type
+// V.
+type LockedDomainTreeOf[V any] struct {
 	sync.RWMutex
-	dt *DomainTree
+	dt *DomainTreeOf[V]
 }
 
-// NewLockedDomainTree returns a new LockedDomainTree.
-func NewLockedDomainTree() *LockedDomainTree {
-	return &LockedDomainTree{
-		dt: NewDomainTree(),
+// NewLockedDomainTreeOf returns a new LockedDomainTree which holds values of type V.
+func NewLockedDomainTreeOf[V any](opts ...Option) *LockedDomainTreeOf[V] {
+	return &LockedDomainTreeOf[V]{
+		dt: NewDomainTreeOf[V](opts...),
 	}
 }
 
 // Lookup lookups the key (thread-safe).
-func (dt *LockedDomainTree) Lookup(key string) (*DomainNode, bool) {
+func (dt *LockedDomainTreeOf[V]) Lookup(key string) (*DomainNodeOf[V], bool) {
 	dt.RLock()
 	dn, ok := dt.dt.Lookup(key)
 	dt.RUnlock()
@@ -49,7 +57,7 @@ func (dt *LockedDomainTree) Lookup(key string) (*DomainNode, bool) {
 }
 
 // AddRegex adds a regular expression (thread-safe).
-func (dt *LockedDomainTree) AddRegex(key string, value interface{}) error {
+func (dt *LockedDomainTreeOf[V]) AddRegex(key string, value V) error {
 	dt.Lock()
 	err := dt.dt.AddRegex(key, value)
 	dt.Unlock()
@@ -57,128 +65,468 @@ func (dt *LockedDomainTree) AddRegex(key string, value interface{}) error {
 }
 
 // Add adds a domain to the tree (thread-safe).
-func (dt *LockedDomainTree) Add(key string, value interface{}) {
+func (dt *LockedDomainTreeOf[V]) Add(key string, value V) error {
+	dt.Lock()
+	err := dt.dt.Add(key, value)
+	dt.Unlock()
+	return err
+}
+
+// Put adds or replaces a domain and returns the replaced value (thread-safe).
+func (dt *LockedDomainTreeOf[V]) Put(key string, value V) (V, bool, error) {
 	dt.Lock()
-	dt.dt.Add(key, value)
+	prev, replaced, err := dt.dt.Put(key, value)
 	dt.Unlock()
+	return prev, replaced, err
+}
+
+// PutRegex adds or replaces a regular expression and returns the replaced
+// value (thread-safe).
+func (dt *LockedDomainTreeOf[V]) PutRegex(key string, value V) (V, bool, error) {
+	dt.Lock()
+	prev, replaced, err := dt.dt.PutRegex(key, value)
+	dt.Unlock()
+	return prev, replaced, err
 }
 
 // Del deletes the key from the tree (thread-safe).
-func (dt *LockedDomainTree) Del(key string) bool {
+func (dt *LockedDomainTreeOf[V]) Del(key string) bool {
 	dt.Lock()
 	ok := dt.dt.Del(key)
 	dt.Unlock()
 	return ok
 }
 
+// Remove deletes the key from the tree and returns its value (thread-safe).
+func (dt *LockedDomainTreeOf[V]) Remove(key string) (V, bool) {
+	dt.Lock()
+	value, ok := dt.dt.Remove(key)
+	dt.Unlock()
+	return value, ok
+}
+
 // DelRegex deletes the regex in the tree (thread-safe).
-func (dt *LockedDomainTree) DelRegex(key string) bool {
+func (dt *LockedDomainTreeOf[V]) DelRegex(key string) bool {
 	dt.Lock()
 	ok := dt.dt.DelRegex(key)
 	dt.Unlock()
 	return ok
 }
 
+// RemoveRegex deletes the regex in the tree and returns its value
+// (thread-safe).
+func (dt *LockedDomainTreeOf[V]) RemoveRegex(key string) (V, bool) {
+	dt.Lock()
+	value, ok := dt.dt.RemoveRegex(key)
+	dt.Unlock()
+	return value, ok
+}
+
 // Walk walks the domain tree (thread-safe).
-func (dt *LockedDomainTree) Walk(fn func(key string, value interface{})) {
+func (dt *LockedDomainTreeOf[V]) Walk(fn func(key string, value V)) {
 	dt.RLock()
 	dt.dt.Walk(fn)
 	dt.RUnlock()
 }
 
-// DomainTree holds a domain tree which is like nginx domain search.
+// WalkUnicode walks the domain tree reporting the U-labels (thread-safe).
+func (dt *LockedDomainTreeOf[V]) WalkUnicode(fn func(key string, value V)) {
+	dt.RLock()
+	dt.dt.WalkUnicode(fn)
+	dt.RUnlock()
+}
+
+// DomainTreeOf holds a domain tree which is like nginx domain search, whose
+// values are of type V.
 //
+// **.example.com
 // *.example.com
+// api-*.example.com
 // abcd.com.*
 // [1-9]\.abcd\.com
-type DomainTree struct {
-	prefix *PrefixWildcard
-	suffix *SuffixWildcard
-	regex  *RegexTree
+type DomainTreeOf[V any] struct {
+	prefix *PrefixWildcardOf[*DomainNodeOf[V]]
+	suffix *SuffixWildcardOf[*DomainNodeOf[V]]
+	regex  *RegexTreeOf[*DomainNodeOf[V]]
+	opts   options
+	// prioritized counts the entries of a non-zero priority, which Lookup
+	// compares as long as there is one.
+	prioritized int
+	// batch holds the nodes copied by the Update of an AtomicDomainTree
+	// running on the tree, nil outside of an Update.
+	batch cowSet
 }
 
-// NewDomainTree creates a new domain tree.
-func NewDomainTree() *DomainTree {
-	return &DomainTree{
-		prefix: NewPrefixWildcard(),
-		suffix: NewSuffixWildcard(),
-		regex:  NewRegexTree(),
+// NewDomainTreeOf creates a new domain tree which holds values of type V.
+func NewDomainTreeOf[V any](opts ...Option) *DomainTreeOf[V] {
+	return &DomainTreeOf[V]{
+		prefix: NewPrefixWildcardOf[*DomainNodeOf[V]](),
+		suffix: NewSuffixWildcardOf[*DomainNodeOf[V]](),
+		regex:  NewRegexTreeOf[*DomainNodeOf[V]](),
+		opts:   newOptions(opts),
 	}
 }
 
-// Del deletes the domain but does not includes regex.
-func (dt *DomainTree) Del(key string) bool {
-	ok := dt.prefix.Del(key)
+// Del deletes the domain but does not includes regex, except the regular
+// expressions of NginxMode. It reports whether the key was in the tree.
+func (dt *DomainTreeOf[V]) Del(key string) bool {
+	_, ok := dt.Remove(key)
+	return ok
+}
+
+// Remove deletes exactly the entry of the key, e.g. *.example.com leaves
+// example.com and *.www.example.com alone, and returns its value. ok is false
+// if the key was not in the tree.
+func (dt *DomainTreeOf[V]) Remove(key string) (value V, ok bool) {
+	dt.write(key)
+
+	var sl slot[V]
+	if dt.opts.nginx {
+		kind, name, err := dt.parseNginxName(key)
+		if err != nil {
+			return value, false
+		}
+		if kind == nginxRegexName {
+			return dt.RemoveRegex(key)
+		}
+		sl = dt.locateNginx(kind, name)
+	} else {
+		ckey, err := dt.canonicalKey(key)
+		if err != nil {
+			return value, false
+		}
+		sl = dt.locate(ckey)
+	}
+
+	node, ok := dt.remove(sl)
 	if ok {
-		return true
+		value = node.value
+		dt.count(node, -1)
+	}
+	return value, ok
+}
+
+// multiLabelType returns the type of the ** wildcards.
+func (dt *DomainTreeOf[V]) multiLabelType() HashValueType {
+	if dt.opts.singleLabel {
+		return WildcardHashValueType
+	}
+	return WildcardHashValueType | ApexHashValueType
+}
+
+// copyPath returns a copy of the tree which shares every node with dt except
+// the ones on the path of key, so that key can be added to or deleted from the
+// copy without affecting the readers of dt.
+func (dt *DomainTreeOf[V]) copyPath(key string) *DomainTreeOf[V] {
+	ndt := *dt
+	ndt.unshare(key, nil)
+	return &ndt
+}
+
+// unshare replaces the nodes on the path of key, and the regex list for a
+// regular expression of NginxMode, with copies unless they are in owned.
+func (dt *DomainTreeOf[V]) unshare(key string, owned cowSet) {
+	if dt.opts.nginx {
+		// the path of the slot, e.g. the empty key of ""
+		kind, name, err := dt.parseNginxName(key)
+		switch {
+		case err != nil:
+		case kind == nginxRegexName:
+			dt.unshareRegex(owned)
+			return
+		default:
+			key = dt.locateNginx(kind, name).key
+		}
+	} else if k, err := dt.canonicalKey(key); err == nil {
+		key = k
+	}
+
+	dt.prefix = dt.prefix.copyPath(key, owned)
+	dt.suffix = dt.suffix.copyPath(key, owned)
+}
+
+// unshareRegex replaces the regex list with a copy unless it is in owned.
+func (dt *DomainTreeOf[V]) unshareRegex(owned cowSet) {
+	if !owned[dt.regex] {
+		dt.regex = dt.regex.clone()
+		owned.add(dt.regex)
 	}
+}
 
-	return dt.prefix.Del(key)
+// copyRegex returns a copy of the tree whose regex list can be modified
+// without affecting the readers of dt.
+func (dt *DomainTreeOf[V]) copyRegex() *DomainTreeOf[V] {
+	ndt := *dt
+	ndt.unshareRegex(nil)
+	return &ndt
+}
+
+// write prepares the tree for a write of the key in the batch of an Update.
+func (dt *DomainTreeOf[V]) write(key string) {
+	if dt.batch != nil {
+		dt.unshare(key, dt.batch)
+	}
+}
+
+// writeRegex prepares the tree for a write of a regular expression in the
+// batch of an Update.
+func (dt *DomainTreeOf[V]) writeRegex() {
+	if dt.batch != nil {
+		dt.unshareRegex(dt.batch)
+	}
 }
 
 // DelRegex deletes the regex domain.
-func (dt *DomainTree) DelRegex(key string) bool {
-	return dt.regex.Del(key)
+func (dt *DomainTreeOf[V]) DelRegex(key string) bool {
+	_, ok := dt.RemoveRegex(key)
+	return ok
+}
+
+// RemoveRegex deletes the regular expression and returns its value.
+func (dt *DomainTreeOf[V]) RemoveRegex(key string) (value V, ok bool) {
+	dt.writeRegex()
+	node, ok := dt.regex.remove(key)
+	if ok {
+		value = node.value
+		dt.count(node, -1)
+	}
+	return value, ok
 }
 
 // Lookup lookups the key.
-func (dt *DomainTree) Lookup(key string) (*DomainNode, bool) {
+func (dt *DomainTreeOf[V]) Lookup(key string) (*DomainNodeOf[V], bool) {
 	// lookup order
 	// 1. prefix
 	// 2. suffix
 	// 3. regex
+	// unless the order is changed by the priorities of the entries,
+	// Precedence or MostSpecific
+
+	key, ok := dt.normalizeHost(key)
+	if !ok {
+		return nil, false
+	}
 
-	hv, ok := dt.prefix.Lookup(key)
+	if dt.ranked() {
+		m, ok := dt.best(key)
+		return m.Node, ok
+	}
+
+	dn, ok := dt.prefix.Lookup(key)
 	if ok {
-		return hv.(*DomainNode), ok
+		return dn, ok
 	}
 
-	hv, ok = dt.suffix.Lookup(key)
+	dn, ok = dt.suffix.Lookup(key)
 	if ok {
-		return hv.(*DomainNode), ok
+		return dn, ok
 	}
 
 	rv, ok := dt.regex.Lookup(key)
 	if ok {
-		return rv.value.(*DomainNode), true
+		return rv.value, true
 	}
 
 	return nil, false
 }
 
-// AddRegex adds a regular expression.
-func (dt *DomainTree) AddRegex(key string, value interface{}) error {
-	node := NewDomainNode(key, value)
-	return dt.regex.Add(key, node)
+// AddRegex adds a regular expression. ErrDuplicateKey is returned if it is
+// already in the tree.
+func (dt *DomainTreeOf[V]) AddRegex(key string, value V) error {
+	return dt.addRegex(NewDomainNode(key, value))
+}
+
+func (dt *DomainTreeOf[V]) addRegex(node *DomainNodeOf[V]) error {
+	rex, err := dt.compileRegex(node.key)
+	if err != nil {
+		return err
+	}
+	dt.writeRegex()
+	if err := dt.regex.insert(node.key, rex, node); err != nil {
+		return &KeyError{Key: node.key, Err: err}
+	}
+	dt.count(node, 1)
+	return nil
+}
+
+// PutRegex adds a regular expression like AddRegex, but replaces the value of
+// the expression if it is already in the tree, keeping its position and
+// priority, and returns the replaced value.
+func (dt *DomainTreeOf[V]) PutRegex(key string, value V) (prev V, replaced bool, err error) {
+	return dt.putRegexNode(NewDomainNode(key, value), true)
+}
+
+// putRegexNode adds or replaces the regular expression of the node, keeping
+// the priority of the replaced entry if keep is true.
+func (dt *DomainTreeOf[V]) putRegexNode(node *DomainNodeOf[V], keep bool) (prev V, replaced bool, err error) {
+	rex, err := dt.compileRegex(node.key)
+	if err != nil {
+		return prev, false, err
+	}
+
+	dt.writeRegex()
+	old, replaced := dt.regex.put(node.key, rex, node)
+	if replaced {
+		prev = old.value
+		if keep {
+			node.priority = old.priority
+		}
+		dt.count(old, -1)
+	}
+	dt.count(node, 1)
+	return prev, replaced, nil
 }
 
-func (dt *DomainTree) Walk(fn func(key string, value interface{})) {
-	dt.prefix.Walk(fn)
-	dt.suffix.Walk(fn)
-	dt.regex.Walk(fn)
+// Walk walks the domain tree in HierarchicalOrder and reports the keys as
+// they were added.
+func (dt *DomainTreeOf[V]) Walk(fn func(key string, value V)) {
+	dt.entries(func(e Entry[V]) bool {
+		fn(e.Key, e.Value)
+		return true
+	})
 }
 
 // Add adds a domain to the tree.
-func (dt *DomainTree) Add(key string, value interface{}) {
-	node := NewDomainNode(key, value)
+//
+// The error is nil unless the key is invalid in the mode of the tree or in
+// Strict mode, or is already in the tree, which is reported as a KeyError
+// wrapping ErrDuplicateKey.
+func (dt *DomainTreeOf[V]) Add(key string, value V) error {
+	return dt.add(NewDomainNode(key, value))
+}
+
+func (dt *DomainTreeOf[V]) add(node *DomainNodeOf[V]) error {
+	dt.write(node.key)
+	sl, rex, err := dt.resolve(node.key)
+	if err != nil {
+		return err
+	}
+
+	if rex != nil {
+		err = dt.regex.insert(node.key, rex, node)
+	} else {
+		err = dt.insert(sl, node)
+	}
+	if err != nil {
+		return &KeyError{Key: node.key, Err: err}
+	}
+	dt.count(node, 1)
+	return nil
+}
+
+// Put adds a domain to the tree like Add, but replaces the value of the key
+// if it is already in the tree, keeping its priority, and returns the
+// replaced value.
+func (dt *DomainTreeOf[V]) Put(key string, value V) (prev V, replaced bool, err error) {
+	return dt.putNode(NewDomainNode(key, value), true)
+}
+
+// putNode adds or replaces the entry of the node, keeping the priority of the
+// replaced entry if keep is true.
+func (dt *DomainTreeOf[V]) putNode(node *DomainNodeOf[V], keep bool) (prev V, replaced bool, err error) {
+	dt.write(node.key)
+	sl, rex, err := dt.resolve(node.key)
+	if err != nil {
+		return prev, false, err
+	}
+
+	var old *DomainNodeOf[V]
+	if rex != nil {
+		old, replaced = dt.regex.put(node.key, rex, node)
+	} else {
+		old, replaced = dt.put(sl, node)
+	}
+	if replaced {
+		prev = old.value
+		if keep {
+			node.priority = old.priority
+		}
+		dt.count(old, -1)
+	}
+	dt.count(node, 1)
+	return prev, replaced, nil
+}
+
+// resolve parses the key in the mode of the tree and returns its slot, or the
+// compiled expression of a regular expression in NginxMode.
+func (dt *DomainTreeOf[V]) resolve(key string) (slot[V], *regexp.Regexp, error) {
+	if dt.opts.nginx {
+		return dt.resolveNginx(key)
+	}
+
+	ckey, err := dt.canonicalKey(key)
+	if err != nil {
+		return slot[V]{}, nil, err
+	}
+
+	if dt.opts.strict {
+		if err := validateKey(ckey); err != nil {
+			return slot[V]{}, nil, &KeyError{Key: key, Err: err}
+		}
+	}
 
-	if key == "*" {
-		dt.prefix.AddGlob(node)
-		return
+	// the patterns within the labels like api-*.example.com fall back to the
+	// full match of prefix
+	return dt.locate(ckey), nil, nil
+}
+
+// slot is where a key is stored in the tree.
+type slot[V any] struct {
+	wh  *WildcardHashOf[*DomainNodeOf[V]] // nil for the glob * of prefix
+	key string
+	typ HashValueType
+}
+
+// locate returns the slot of the canonical key outside of NginxMode.
+func (dt *DomainTreeOf[V]) locate(key string) slot[V] {
+	switch {
+	case key == "*":
+		return slot[V]{}
+
+	case strings.HasPrefix(key, "**."): // **.domain
+		return slot[V]{dt.prefix.wh, key[3:], dt.multiLabelType()}
+
+	case strings.HasPrefix(key, "*."): // *.domain
+		if dt.opts.singleLabel {
+			return slot[V]{dt.prefix.wh, key[2:], SingleWildcardHashValueType}
+		}
+		return slot[V]{dt.prefix.wh, key[2:], WildcardHashValueType | ApexHashValueType}
+
+	case strings.HasSuffix(key, ".**"): // domain.**
+		return slot[V]{dt.suffix.wh, key[:len(key)-3], dt.multiLabelType()}
+
+	case strings.HasSuffix(key, ".*"): // domain.*
+		if dt.opts.singleLabel {
+			return slot[V]{dt.suffix.wh, key[:len(key)-2], SingleWildcardHashValueType}
+		}
+		return slot[V]{dt.suffix.wh, key[:len(key)-2], WildcardHashValueType | ApexHashValueType}
 	}
 
-	n := strings.Index(key, "*.")
-	if n == 0 { // *.domain
-		dt.prefix.AddWildcard(key, node)
-		return
+	return slot[V]{dt.prefix.wh, key, FullHashValueType}
+}
+
+// insert stores the node in the slot unless the slot already holds one.
+func (dt *DomainTreeOf[V]) insert(sl slot[V], node *DomainNodeOf[V]) error {
+	if sl.wh == nil {
+		return dt.prefix.AddGlob(node)
 	}
+	return sl.wh.insert(sl.key, node, sl.typ)
+}
 
-	n = strings.LastIndex(key, ".*")
-	if n >= 0 {
-		dt.suffix.AddWildcard(key, node)
-		return
+// remove deletes the node of the slot.
+func (dt *DomainTreeOf[V]) remove(sl slot[V]) (*DomainNodeOf[V], bool) {
+	if sl.wh == nil {
+		node, ok := dt.prefix.glob, dt.prefix.hasGlob
+		dt.prefix.DelGlob()
+		return node, ok
 	}
+	return sl.wh.remove(sl.key, sl.typ)
+}
 
-	// fallback to prefix
-	dt.prefix.AddFull(key, node)
+// put stores the node in the slot and returns the node it replaces, if any.
+func (dt *DomainTreeOf[V]) put(sl slot[V], node *DomainNodeOf[V]) (*DomainNodeOf[V], bool) {
+	if sl.wh == nil {
+		return dt.prefix.Put("*", node)
+	}
+	return sl.wh.put(sl.key, node, sl.typ)
 }
@@ -1,7 +1,9 @@
 package domaintree
 
 import (
+	"fmt"
 	"log"
+	"regexp"
 	"testing"
 )
 
@@ -58,3 +60,201 @@ func BenchmarkDomainTree(b *testing.B) {
 		})
 	}
 }
+
+func benchmarkConcurrentLookup(b *testing.B, add func(key string, value int) error, lookup func(key string) (*DomainNodeOf[int], bool)) {
+	add("www.example.com", 1)
+	add("abcd.example.com", 2)
+	add("*.example.com", 3)
+	add("example.com", 4)
+
+	b.Run("readers", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, ok := lookup("11111111.example.com"); !ok {
+					b.Fatal("failed to lookup")
+				}
+			}
+		})
+	})
+
+	b.Run("readers with writer", func(b *testing.B) {
+		done := make(chan struct{})
+		go func() {
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+					add("www.example.com", i)
+				}
+			}
+		}()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, ok := lookup("11111111.example.com"); !ok {
+					b.Fatal("failed to lookup")
+				}
+			}
+		})
+		close(done)
+	})
+}
+
+func BenchmarkLockedDomainTree(b *testing.B) {
+	dt := NewLockedDomainTreeOf[int]()
+	benchmarkConcurrentLookup(b, dt.Add, dt.Lookup)
+}
+
+func BenchmarkAtomicDomainTree(b *testing.B) {
+	dt := NewAtomicDomainTreeOf[int]()
+	benchmarkConcurrentLookup(b, dt.Add, dt.Lookup)
+}
+
+func BenchmarkAtomicDomainTreeWrite(b *testing.B) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("www%d.example.com", i)
+	}
+
+	b.Run("add", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			dt := NewAtomicDomainTreeOf[int]()
+			for j, key := range keys[:1000] {
+				dt.Add(key, j)
+			}
+		}
+	})
+
+	b.Run("update", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			dt := NewAtomicDomainTreeOf[int]()
+			dt.Update(func(dt *DomainTreeOf[int]) error {
+				for j, key := range keys {
+					dt.Add(key, j)
+				}
+				return nil
+			})
+		}
+	})
+}
+
+func BenchmarkDomainTreeNormalize(b *testing.B) {
+	dt := NewDomainTreeOf[int](CaseInsensitive(), IgnoreTrailingDot(), StripPort())
+	dt.Add("www.example.com", 1)
+	dt.Add("*.example.com", 2)
+
+	for _, input := range []string{
+		"www.example.com",
+		"www.example.com.",
+		"www.example.com:8080",
+		"11111111.example.com.:443",
+		"WWW.Example.COM",
+	} {
+		b.Run(input, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, ok := dt.Lookup(input); !ok {
+					b.Fatal("failed to lookup")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRegexTree(b *testing.B) {
+	formats := []struct {
+		name string
+		expr string
+	}{
+		{"indexed", `^[a-z0-9-]+\.site%d\.example\.com$`},
+		{"unindexed", `[a-z0-9-]+\.site%d\.example\.com`},
+	}
+	sizes := []int{10, 100, 1000, 2000}
+	inputs := func(n int) []struct {
+		name  string
+		input string
+		ok    bool
+	} {
+		return []struct {
+			name  string
+			input string
+			ok    bool
+		}{
+			{"hit", fmt.Sprintf("www.site%d.example.com", n-1), true},
+			{"miss", "www.example.org", false},
+		}
+	}
+
+	for _, format := range formats {
+		for _, n := range sizes {
+			rt := NewRegexTreeOf[int]()
+			for i := 0; i < n; i++ {
+				if err := rt.Add(fmt.Sprintf(format.expr, i), i); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			for _, tt := range inputs(n) {
+				b.Run(fmt.Sprintf("%s/%s/%d", format.name, tt.name, n), func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						if _, ok := rt.Lookup(tt.input); ok != tt.ok {
+							b.Fatal("failed to lookup")
+						}
+					}
+				})
+			}
+		}
+	}
+
+	// the baseline running the expressions one after the other
+	for _, n := range sizes {
+		rexes := make([]*regexp.Regexp, n)
+		for i := range rexes {
+			rexes[i] = regexp.MustCompile(fmt.Sprintf(formats[0].expr, i))
+		}
+
+		for _, tt := range inputs(n) {
+			b.Run(fmt.Sprintf("linear/%s/%d", tt.name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if ok := firstMatch(rexes, tt.input) >= 0; ok != tt.ok {
+						b.Fatal("failed to lookup")
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkSnapshot(b *testing.B) {
+	dt := NewDomainTreeOf[int]()
+	for i := 0; i < 100000; i++ {
+		key := fmt.Sprintf("www%d.tenant%d.example.com", i, i%1000)
+		if i%2 == 0 {
+			key = "*." + key
+		}
+		if err := dt.Add(key, i); err != nil {
+			b.Fatal(err)
+		}
+	}
+	data, err := dt.MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("marshal", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := dt.MarshalBinary(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("unmarshal", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			if err := NewDomainTreeOf[int]().UnmarshalBinary(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
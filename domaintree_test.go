@@ -1,6 +1,9 @@
 package domaintree
 
 import (
+	"errors"
+	"fmt"
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -103,3 +106,515 @@ func TestDomainTree(t *testing.T) {
 		require.Equal(t, tt.expect, dn.GetKey(), tt.input)
 	}
 }
+
+func TestTypedDomainTree(t *testing.T) {
+	dt := NewDomainTreeOf[int]()
+	dt.Add("www.example.com", 1)
+	dt.Add("*.example.com", 2)
+	dt.Add("example.com.*", 3)
+	require.NoError(t, dt.AddRegex(`[0-9]\.abcd\.com`, 4))
+
+	for _, tt := range []struct {
+		input  string
+		expect int
+	}{
+		{"www.example.com", 1},
+		{"abcd.example.com", 2},
+		{"example.com.cn", 3},
+		{"1.abcd.com", 4},
+	} {
+		dn, ok := dt.Lookup(tt.input)
+		require.True(t, ok)
+		require.Equal(t, tt.expect, dn.GetValue(), tt.input)
+	}
+
+	_, ok := dt.Lookup("abcd.com")
+	require.False(t, ok)
+
+	sum := 0
+	dt.Walk(func(key string, value int) {
+		sum += value
+	})
+	require.Equal(t, 10, sum)
+}
+
+func TestAtomicDomainTree(t *testing.T) {
+	dt := NewAtomicDomainTreeOf[int]()
+	dt.Add("www.example.com", 1)
+	dt.Add("*.example.com", 2)
+	require.NoError(t, dt.AddRegex(`[0-9]\.abcd\.com`, 3))
+
+	snapshot := dt.dt.Load()
+
+	dt.Add("abcd.example.com", 4)
+	dt.Add("example.com.*", 5)
+	require.True(t, dt.Del("www.example.com"))
+	require.True(t, dt.DelRegex(`[0-9]\.abcd\.com`))
+
+	for _, tt := range []struct {
+		input  string
+		old    int
+		expect int
+	}{
+		{"www.example.com", 1, 2},
+		{"abcd.example.com", 2, 4},
+		{"example.com.cn", 0, 5},
+		{"1.abcd.com", 3, 0},
+	} {
+		dn, ok := snapshot.Lookup(tt.input)
+		require.Equal(t, tt.old != 0, ok, tt.input)
+		if ok {
+			require.Equal(t, tt.old, dn.GetValue(), tt.input)
+		}
+
+		dn, ok = dt.Lookup(tt.input)
+		require.Equal(t, tt.expect != 0, ok, tt.input)
+		if ok {
+			require.Equal(t, tt.expect, dn.GetValue(), tt.input)
+		}
+	}
+}
+
+func TestAtomicDomainTreeUpdate(t *testing.T) {
+	dt := NewAtomicDomainTreeOf[int]()
+	require.NoError(t, dt.Add("www.example.com", 1))
+	require.NoError(t, dt.Add("*.example.org", 2))
+	require.NoError(t, dt.AddRegex(`^api\.`, 3))
+	snapshot := dt.dt.Load()
+
+	require.NoError(t, dt.Update(func(dt *DomainTreeOf[int]) error {
+		for i := 0; i < 100; i++ {
+			if err := dt.Add(fmt.Sprintf("www%d.example.com", i), 10+i); err != nil {
+				return err
+			}
+		}
+		dt.Put("www.example.com", 4)
+		dt.Del("*.example.org")
+		dt.DelRegex(`^api\.`)
+		return dt.AddRegexWithOptions(`^www\.`, 5, Priority(1))
+	}))
+
+	for _, tt := range []struct {
+		input  string
+		old    int
+		expect int
+	}{
+		{"www.example.com", 1, 5},
+		{"www42.example.com", 0, 52},
+		{"a.example.org", 2, 0},
+		{"api.example.net", 3, 0},
+	} {
+		dn, ok := snapshot.Lookup(tt.input)
+		require.Equal(t, tt.old != 0, ok, tt.input)
+		if ok {
+			require.Equal(t, tt.old, dn.GetValue(), tt.input)
+		}
+
+		dn, ok = dt.Lookup(tt.input)
+		require.Equal(t, tt.expect != 0, ok, tt.input)
+		if ok {
+			require.Equal(t, tt.expect, dn.GetValue(), tt.input)
+		}
+	}
+
+	// a failed update is discarded
+	snapshot = dt.dt.Load()
+	err := dt.Update(func(dt *DomainTreeOf[int]) error {
+		dt.Del("www42.example.com")
+		return dt.Add("www.example.com", 6)
+	})
+	require.True(t, errors.Is(err, ErrDuplicateKey))
+	require.Same(t, snapshot, dt.dt.Load())
+	dn, ok := dt.Lookup("www42.example.com")
+	require.True(t, ok)
+	require.Equal(t, 52, dn.GetValue())
+
+	// the writes after an update copy the nodes it published
+	require.NoError(t, dt.Add("www100.example.com", 110))
+	dn, ok = snapshot.Lookup("www100.example.com")
+	require.False(t, ok)
+}
+
+func TestDomainTreeUpdate(t *testing.T) {
+	for _, tree := range []interface {
+		Add(key string, value int) error
+		Update(fn func(dt *DomainTreeOf[int]) error) error
+		Lookup(key string) (*DomainNodeOf[int], bool)
+	}{NewDomainTreeOf[int](), NewLockedDomainTreeOf[int]()} {
+		require.NoError(t, tree.Add("www.example.com", 1))
+		require.NoError(t, tree.Add("*.example.org", 2))
+
+		// a failed update is discarded
+		err := tree.Update(func(dt *DomainTreeOf[int]) error {
+			dt.Del("*.example.org")
+			dt.Put("www.example.com", 3)
+			return dt.Add("www.example.com", 4)
+		})
+		require.True(t, errors.Is(err, ErrDuplicateKey))
+		dn, ok := tree.Lookup("a.example.org")
+		require.True(t, ok)
+		require.Equal(t, 2, dn.GetValue())
+		dn, ok = tree.Lookup("www.example.com")
+		require.True(t, ok)
+		require.Equal(t, 1, dn.GetValue())
+
+		require.NoError(t, tree.Update(func(dt *DomainTreeOf[int]) error {
+			dt.Del("*.example.org")
+			_, _, err := dt.Put("www.example.com", 3)
+			return err
+		}))
+		_, ok = tree.Lookup("a.example.org")
+		require.False(t, ok)
+		dn, ok = tree.Lookup("www.example.com")
+		require.True(t, ok)
+		require.Equal(t, 3, dn.GetValue())
+	}
+}
+
+func TestSingleLabelWildcard(t *testing.T) {
+	dt := NewDomainTreeOf[string](SingleLabelWildcard())
+	require.NoError(t, dt.Add("*.example.com", "*.example.com"))
+	require.NoError(t, dt.Add("**.example.org", "**.example.org"))
+	require.NoError(t, dt.Add("*.a.example.org", "*.a.example.org"))
+	require.NoError(t, dt.Add("www.example.*", "www.example.*"))
+	require.NoError(t, dt.Add("mail.example.**", "mail.example.**"))
+
+	for _, tt := range []struct {
+		input  string
+		expect string
+	}{
+		{"a.example.com", "*.example.com"},
+		{"a.example.org", "**.example.org"},
+		{"x.a.example.org", "*.a.example.org"},
+		{"x.y.a.example.org", "**.example.org"},
+		{"a.b.c.example.org", "**.example.org"},
+		{"www.example.net", "www.example.*"},
+		{"mail.example.co.uk", "mail.example.**"},
+	} {
+		dn, ok := dt.Lookup(tt.input)
+		require.True(t, ok, tt.input)
+		require.Equal(t, tt.expect, dn.GetKey(), tt.input)
+	}
+
+	for _, input := range []string{
+		"example.com",
+		"a.b.example.com",
+		"example.org",
+		"www.example.co.uk",
+		"mail.example",
+	} {
+		_, ok := dt.Lookup(input)
+		require.False(t, ok, input)
+	}
+
+	require.True(t, dt.Del("*.example.com"))
+	require.False(t, dt.Del("*.example.com"))
+	_, ok := dt.Lookup("a.example.com")
+	require.False(t, ok)
+
+	require.True(t, dt.Del("**.example.org"))
+	dn, ok := dt.Lookup("x.a.example.org")
+	require.True(t, ok)
+	require.Equal(t, "*.a.example.org", dn.GetKey())
+	_, ok = dt.Lookup("a.example.org")
+	require.False(t, ok)
+
+	require.True(t, dt.Del("www.example.*"))
+	_, ok = dt.Lookup("www.example.net")
+	require.False(t, ok)
+}
+
+func TestMultiLabelWildcardSyntax(t *testing.T) {
+	dt := NewDomainTreeOf[int]()
+	require.NoError(t, dt.Add("**.example.com", 1))
+
+	for _, input := range []string{"example.com", "a.example.com", "a.b.example.com"} {
+		dn, ok := dt.Lookup(input)
+		require.True(t, ok, input)
+		require.Equal(t, 1, dn.GetValue(), input)
+	}
+}
+
+func TestLabelPattern(t *testing.T) {
+	dt := NewDomainTreeOf[string]()
+	for _, key := range []string{
+		"pr-*.preview.example.com",
+		"*-canary.example.com",
+		"api-*-canary.example.com",
+		"api.*.example.com",
+		"*.example.com",
+		"api-1-canary.example.com",
+		"*.api-*.example.org",
+	} {
+		require.NoError(t, dt.Add(key, key))
+	}
+
+	for _, tt := range []struct {
+		input  string
+		expect string
+	}{
+		{"pr-123.preview.example.com", "pr-*.preview.example.com"},
+		{"web-canary.example.com", "*-canary.example.com"},
+		{"api-2-canary.example.com", "api-*-canary.example.com"},
+		{"api-1-canary.example.com", "api-1-canary.example.com"},
+		{"api.eu.example.com", "api.*.example.com"},
+		{"pr.preview.example.com", "*.example.com"},
+		{"www.api.eu.example.com", "*.example.com"},
+		{"a.b.api-v1.example.org", "*.api-*.example.org"},
+	} {
+		dn, ok := dt.Lookup(tt.input)
+		require.True(t, ok, tt.input)
+		require.Equal(t, tt.expect, dn.GetKey(), tt.input)
+	}
+
+	require.True(t, dt.Del("api-*-canary.example.com"))
+	dn, ok := dt.Lookup("api-2-canary.example.com")
+	require.True(t, ok)
+	require.Equal(t, "*-canary.example.com", dn.GetKey())
+
+	require.True(t, dt.Del("api.*.example.com"))
+	dn, ok = dt.Lookup("api.eu.example.com")
+	require.True(t, ok)
+	require.Equal(t, "*.example.com", dn.GetKey())
+
+	m, ok := dt.LookupMatch("pr-1.preview.example.com")
+	require.True(t, ok)
+	require.Equal(t, LabelWildcardMatchKind, m.Kind)
+
+	adt := NewAtomicDomainTreeOf[int]()
+	require.NoError(t, adt.Add("api-*.example.com", 1))
+	snapshot := adt.dt.Load()
+	prev, replaced, err := adt.Put("api-*.example.com", 2)
+	require.NoError(t, err)
+	require.True(t, replaced)
+	require.Equal(t, 1, prev)
+	dn2, ok := snapshot.Lookup("api-1.example.com")
+	require.True(t, ok)
+	require.Equal(t, 1, dn2.GetValue())
+	dn2, ok = adt.Lookup("api-1.example.com")
+	require.True(t, ok)
+	require.Equal(t, 2, dn2.GetValue())
+}
+
+func TestDuplicateKey(t *testing.T) {
+	dt := NewDomainTreeOf[int]()
+	for _, key := range []string{"example.com", "*.example.com", "example.*", "*", "api-*.example.com"} {
+		require.NoError(t, dt.Add(key, 1), key)
+		err := dt.Add(key, 2)
+		require.True(t, errors.Is(err, ErrDuplicateKey), key)
+
+		var kerr *KeyError
+		require.True(t, errors.As(err, &kerr), key)
+		require.Equal(t, key, kerr.Key)
+	}
+
+	// *.example.com and **.example.com share their value
+	require.True(t, errors.Is(dt.Add("**.example.com", 2), ErrDuplicateKey))
+
+	require.NoError(t, dt.AddRegex(`[0-9]\.abcd\.com`, 1))
+	require.True(t, errors.Is(dt.AddRegex(`[0-9]\.abcd\.com`, 2), ErrDuplicateKey))
+
+	dn, ok := dt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, 1, dn.GetValue())
+
+	// the sub-trees follow the same policy
+	pw := NewPrefixWildcardOf[int]()
+	require.NoError(t, pw.Add("*.example.com", 1))
+	require.Equal(t, ErrDuplicateKey, pw.Add("*.example.com", 2))
+	require.NoError(t, pw.Add("*", 1))
+	require.Equal(t, ErrDuplicateKey, pw.Add("*", 2))
+
+	sw := NewSuffixWildcardOf[int]()
+	require.NoError(t, sw.Add("example.*", 1))
+	require.Equal(t, ErrDuplicateKey, sw.Add("example.*", 2))
+
+	rt := NewRegexTreeOf[int]()
+	require.NoError(t, rt.Add(`^www\.`, 1))
+	require.Equal(t, ErrDuplicateKey, rt.Add(`^www\.`, 2))
+}
+
+func TestPut(t *testing.T) {
+	dt := NewDomainTreeOf[int]()
+	for _, key := range []string{"example.com", "*.example.com", "example.*", "*", "api-*.example.com"} {
+		prev, replaced, err := dt.Put(key, 1)
+		require.NoError(t, err)
+		require.False(t, replaced, key)
+		require.Equal(t, 0, prev)
+
+		prev, replaced, err = dt.Put(key, 2)
+		require.NoError(t, err)
+		require.True(t, replaced, key)
+		require.Equal(t, 1, prev)
+	}
+
+	dn, ok := dt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, 2, dn.GetValue())
+
+	// a replaced regular expression keeps its priority
+	dt = NewDomainTreeOf[int]()
+	require.NoError(t, dt.AddRegex(`^www\.`, 1))
+	require.NoError(t, dt.AddRegex(`^www\.example\.`, 2))
+	prev, replaced, err := dt.PutRegex(`^www\.`, 3)
+	require.NoError(t, err)
+	require.True(t, replaced)
+	require.Equal(t, 1, prev)
+	dn, ok = dt.Lookup("www.example.net")
+	require.True(t, ok)
+	require.Equal(t, 3, dn.GetValue())
+
+	_, _, err = dt.PutRegex(`^(www`, 4)
+	require.Error(t, err)
+
+	dt = NewDomainTreeOf[int](NginxMode())
+	require.NoError(t, dt.Add(".example.com", 1))
+	prev, replaced, err = dt.Put("*.example.com", 2)
+	require.NoError(t, err)
+	require.True(t, replaced)
+	require.Equal(t, 1, prev)
+	require.NoError(t, dt.Add(`~^www\.`, 1))
+	prev, replaced, err = dt.Put(`~^www\.`, 2)
+	require.NoError(t, err)
+	require.True(t, replaced)
+	require.Equal(t, 1, prev)
+
+	ldt := NewLockedDomainTreeOf[int]()
+	require.NoError(t, ldt.Add("example.com", 1))
+	prev, replaced, err = ldt.Put("example.com", 2)
+	require.NoError(t, err)
+	require.True(t, replaced)
+	require.Equal(t, 1, prev)
+}
+
+func TestRemove(t *testing.T) {
+	dt := NewDomainTreeOf[string]()
+	for _, key := range []string{"example.com", "*.example.com", "*.www.example.com", "example.*", "api-*.example.com", "*"} {
+		require.NoError(t, dt.Add(key, key))
+	}
+	require.NoError(t, dt.AddRegex(`^www\.`, "regex"))
+
+	for _, key := range []string{"*.example.com", "example.*", "api-*.example.com", "example.com", "*"} {
+		value, ok := dt.Remove(key)
+		require.True(t, ok, key)
+		require.Equal(t, key, value)
+
+		_, ok = dt.Remove(key)
+		require.False(t, ok, key)
+	}
+
+	_, ok := dt.Remove("a.b.c.example.com")
+	require.False(t, ok)
+
+	dn, ok := dt.Lookup("a.www.example.com")
+	require.True(t, ok)
+	require.Equal(t, "*.www.example.com", dn.GetValue())
+
+	value, ok := dt.RemoveRegex(`^www\.`)
+	require.True(t, ok)
+	require.Equal(t, "regex", value)
+	_, ok = dt.RemoveRegex(`^www\.`)
+	require.False(t, ok)
+
+	ldt := NewLockedDomainTreeOf[int](NginxMode())
+	require.NoError(t, ldt.Add(".example.com", 1))
+	require.NoError(t, ldt.Add(`~^www\.`, 2))
+	v, ok := ldt.Remove("*.example.com")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	v, ok = ldt.Remove(`~^www\.`)
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+
+	adt := NewAtomicDomainTreeOf[int]()
+	require.NoError(t, adt.Add("www.example.*", 1))
+	require.NoError(t, adt.AddRegex(`^www\.`, 2))
+	v, ok = adt.Remove("www.example.*")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	v, ok = adt.RemoveRegex(`^www\.`)
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+	_, ok = adt.Remove("www.example.*")
+	require.False(t, ok)
+}
+
+// TestDelProperty runs random sequences of Add, Put and Remove against a tree
+// and a map and checks that both hold the same entries after each step.
+func TestDelProperty(t *testing.T) {
+	names := []string{"com", "example.com", "www.example.com", "a.www.example.com", "example.org", "b.example.org"}
+	patterns := []func(name string) string{
+		func(name string) string { return name },
+		func(name string) string { return "*." + name },
+		func(name string) string { return name + ".*" },
+		func(name string) string { return "api-*." + name },
+	}
+
+	for _, tt := range []struct {
+		opts     []Option
+		patterns int // the number of patterns used
+		extra    []string
+	}{
+		{nil, 4, []string{"*"}},
+		{[]Option{SingleLabelWildcard()}, 4, []string{"*", "**.example.com", "example.**", "**.www.example.com"}},
+		{[]Option{NginxMode()}, 3, []string{".example.net", `~^www\.`, `~\.com$`}},
+	} {
+		var keys []string
+		for _, name := range names {
+			for _, pattern := range patterns[:tt.patterns] {
+				keys = append(keys, pattern(name))
+			}
+		}
+		keys = append(keys, tt.extra...)
+
+		for seed := int64(0); seed < 20; seed++ {
+			r := rand.New(rand.NewSource(seed))
+			dt := NewDomainTreeOf[int](tt.opts...)
+			ref := make(map[string]int)
+
+			for i := 0; i < 300; i++ {
+				key := keys[r.Intn(len(keys))]
+				switch r.Intn(3) {
+				case 0:
+					err := dt.Add(key, i)
+					_, dup := ref[key]
+					if dup {
+						require.True(t, errors.Is(err, ErrDuplicateKey), key)
+						break
+					}
+					require.NoError(t, err, key)
+					ref[key] = i
+				case 1:
+					prev, replaced, err := dt.Put(key, i)
+					require.NoError(t, err, key)
+					expect, ok := ref[key]
+					require.Equal(t, ok, replaced, key)
+					require.Equal(t, expect, prev, key)
+					ref[key] = i
+				case 2:
+					value, ok := dt.Remove(key)
+					expect, found := ref[key]
+					require.Equal(t, found, ok, key)
+					require.Equal(t, expect, value, key)
+					delete(ref, key)
+				}
+
+				got := make(map[int]bool)
+				dt.Walk(func(_ string, value int) {
+					got[value] = true
+				})
+				expect := make(map[int]bool)
+				for _, value := range ref {
+					expect[value] = true
+				}
+				require.Equal(t, expect, got, fmt.Sprintf("seed %d step %d %s", seed, i, key))
+				require.Equal(t, len(ref), dt.Stats().Entries)
+			}
+
+			for key := range ref {
+				require.True(t, dt.Del(key), key)
+			}
+			require.Equal(t, 0, dt.Stats().Nodes)
+		}
+	}
+}
@@ -0,0 +1,752 @@
+package domaintree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"unsafe"
+)
+
+// The format of a frozen tree is a header followed by fixed-size records, so
+// that it is queried in place, e.g. from a memory-mapped file:
+//
+//	magic      "DTFZ"
+//	version    uint32
+//	flags      uint32, the options which change how the hostnames are
+//	           normalized and how the matches are ranked
+//	precedence 6 bytes and 2 bytes of padding
+//	glob       uint32, the entry of *, or noFrozenEntry
+//	nodes      uint32, the number of nodes
+//	entries    uint32, the number of entries
+//	regexes    uint32, the number of regular expressions
+//	strings    uint32, the length of the strings
+//	checksum   uint32, the CRC-32 (IEEE) of the bytes before and after it
+//	reserved   4 bytes
+//	nodes      36 bytes each
+//	entries    24 bytes each
+//	regexes    12 bytes each
+//	strings    the labels, the keys, the values and the expressions
+//
+// The integers are little endian. Node 0 is the root of the trie of the
+// prefix wildcards and node 1 the one of the suffix wildcards; the children
+// of a node are contiguous, the labels sorted first and then the patterns
+// like api-* in the order of the tree.
+const (
+	frozenMagic      = "DTFZ"
+	frozenVersion    = 1
+	frozenHeaderSize = 48
+	frozenNodeSize   = 36
+	frozenEntrySize  = 24
+	frozenRegexSize  = 12
+
+	noFrozenEntry = math.MaxUint32
+)
+
+// frozenNode is a node of a trie: its label, its children and the entries of
+// its values.
+type frozenNode struct {
+	label, labelLen    uint32
+	children           uint32
+	hashes, globs      uint32
+	typ                uint32
+	full, single, wild uint32
+}
+
+// frozenEntry is an entry of the tree: its key, its encoded value, its kind
+// and its priority.
+type frozenEntry struct {
+	key, keyLen     uint32
+	value, valueLen uint32
+	kind            uint32
+	priority        int32
+}
+
+// FrozenTree is a read-only DomainTree compiled by Freeze into a flat
+// structure without pointers, which is queried in place. OpenFrozenTree maps
+// the file written by WriteTo into memory, so that a tree of millions of
+// domains costs neither heap nor garbage collection and is shared through the
+// page cache by the processes opening the same file.
+//
+// The lookups match like the ones of the tree it was frozen from, whose
+// options are recorded in the frozen tree. The values are decoded by the
+// ValueCodec on every lookup. A FrozenTree is safe for concurrent use.
+type FrozenTree[V any] struct {
+	data    []byte
+	strs    string // the strings, sharing the memory of data
+	bytes   []byte // the strings as bytes
+	nodes   []byte
+	entries []byte
+	glob    uint32
+	opts    options
+	codec   ValueCodec[V]
+	regex   *RegexTreeOf[uint32]
+	unmap   func() error
+}
+
+// Freeze compiles the tree into a FrozenTree. The values are encoded with the
+// codec of the tree, see Codec.
+func (dt *DomainTreeOf[V]) Freeze() (*FrozenTree[V], error) {
+	codec, err := dt.codec()
+	if err != nil {
+		return nil, err
+	}
+
+	b := &frozenBuilder[V]{codec: codec, labels: make(map[string]uint32)}
+	b.nodes = make([]frozenNode, 2)
+	prefix := b.root(dt.prefix.wh, LeadingWildcardMatchKind)
+	suffix := b.root(dt.suffix.wh, TrailingWildcardMatchKind)
+	b.nodes[0], b.nodes[1] = prefix, suffix
+
+	glob := uint32(noFrozenEntry)
+	if dt.prefix.hasGlob {
+		glob = b.entry(dt.prefix.glob, GlobMatchKind)
+	}
+	for _, rv := range dt.regex.regex {
+		// the expression as compiled, e.g. with the (?i) of NginxMode
+		expr := rv.regex.String()
+		b.regexes = append(b.regexes, b.entry(rv.value, RegexMatchKind), b.string(expr), uint32(len(expr)))
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	data, err := b.encode(dt, glob)
+	if err != nil {
+		return nil, err
+	}
+	return NewFrozenTree(data, codec)
+}
+
+// Freeze compiles the tree into a FrozenTree (thread-safe).
+func (dt *LockedDomainTreeOf[V]) Freeze() (*FrozenTree[V], error) {
+	dt.RLock()
+	defer dt.RUnlock()
+	return dt.dt.Freeze()
+}
+
+// Freeze compiles the current snapshot into a FrozenTree (lock-free).
+func (adt *AtomicDomainTree[V]) Freeze() (*FrozenTree[V], error) {
+	return adt.dt.Load().Freeze()
+}
+
+// frozenBuilder lays out the tries and the entries of a tree, keeping the
+// first error.
+type frozenBuilder[V any] struct {
+	codec   ValueCodec[V]
+	nodes   []frozenNode
+	entries []frozenEntry
+	regexes []uint32 // the entry, the offset and the length of the expressions
+	strs    []byte
+	labels  map[string]uint32 // the offsets of the labels, which are shared
+	value   []byte
+	err     error
+}
+
+// string appends s to the strings and returns its offset.
+func (b *frozenBuilder[V]) string(s string) uint32 {
+	off := uint32(len(b.strs))
+	if b.grow(len(s)) {
+		b.strs = append(b.strs, s...)
+	}
+	return off
+}
+
+// bytes appends p to the strings and returns its offset.
+func (b *frozenBuilder[V]) bytes(p []byte) uint32 {
+	off := uint32(len(b.strs))
+	if b.grow(len(p)) {
+		b.strs = append(b.strs, p...)
+	}
+	return off
+}
+
+// grow reports whether n bytes can be appended to the strings, whose offsets
+// are 32-bit.
+func (b *frozenBuilder[V]) grow(n int) bool {
+	if uint64(len(b.strs))+uint64(n) > math.MaxUint32 {
+		b.fail(fmt.Errorf("domaintree: frozen tree larger than %d bytes of strings", uint32(math.MaxUint32)))
+		return false
+	}
+	return true
+}
+
+func (b *frozenBuilder[V]) label(s string) uint32 {
+	off, ok := b.labels[s]
+	if !ok {
+		off = b.string(s)
+		b.labels[s] = off
+	}
+	return off
+}
+
+func (b *frozenBuilder[V]) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// entry adds the entry of the node and returns its index.
+func (b *frozenBuilder[V]) entry(dn *DomainNodeOf[V], kind MatchKind) uint32 {
+	var err error
+	b.value, err = b.codec.AppendValue(b.value[:0], dn.value)
+	if err != nil {
+		b.fail(fmt.Errorf("domaintree: encoding the value of %q: %w", dn.key, err))
+	}
+	if dn.priority < math.MinInt32 || dn.priority > math.MaxInt32 {
+		b.fail(fmt.Errorf("domaintree: priority %d of %q out of the range of a frozen tree", dn.priority, dn.key))
+	}
+
+	b.entries = append(b.entries, frozenEntry{
+		key:      b.string(dn.key),
+		keyLen:   uint32(len(dn.key)),
+		value:    b.bytes(b.value),
+		valueLen: uint32(len(b.value)),
+		kind:     uint32(kind),
+		priority: int32(dn.priority),
+	})
+	return uint32(len(b.entries) - 1)
+}
+
+// root returns the root node of the trie, whose wildcard entries are of the
+// kind.
+func (b *frozenBuilder[V]) root(wh *WildcardHashOf[*DomainNodeOf[V]], kind MatchKind) frozenNode {
+	n := frozenNode{full: noFrozenEntry, single: noFrozenEntry, wild: noFrozenEntry}
+	n.children, n.hashes, n.globs = b.children(wh, kind, false)
+	return n
+}
+
+// children lays out the children of the trie contiguously, then their own
+// children, and returns their first node and their numbers.
+func (b *frozenBuilder[V]) children(wh *WildcardHashOf[*DomainNodeOf[V]], kind MatchKind, glob bool) (start, hashes, globs uint32) {
+	keys := make([]string, 0, len(wh.hash))
+	for k := range wh.hash {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	first := len(b.nodes)
+	b.nodes = append(b.nodes, make([]frozenNode, len(keys)+len(wh.globs))...)
+	// b.node appends the grandchildren, so b.nodes is indexed afterwards
+	for i, k := range keys {
+		n := b.node(k, wh.hash[k], kind, glob)
+		b.nodes[first+i] = n
+	}
+	for i, g := range wh.globs {
+		n := b.node(g.pattern, g.hv, kind, true)
+		b.nodes[first+len(keys)+i] = n
+	}
+	return uint32(first), uint32(len(keys)), uint32(len(wh.globs))
+}
+
+func (b *frozenBuilder[V]) node(label string, hv *HashValueOf[*DomainNodeOf[V]], kind MatchKind, glob bool) frozenNode {
+	n := frozenNode{
+		label:    b.label(label),
+		labelLen: uint32(len(label)),
+		typ:      uint32(hv.typ),
+		full:     noFrozenEntry,
+		single:   noFrozenEntry,
+		wild:     noFrozenEntry,
+	}
+
+	if hv.typ&FullHashValueType == FullHashValueType {
+		full := FullMatchKind
+		if glob && kind == LeadingWildcardMatchKind {
+			full = LabelWildcardMatchKind
+		}
+		n.full = b.entry(hv.fullvalue, full)
+	}
+	if hv.typ&SingleWildcardHashValueType == SingleWildcardHashValueType {
+		n.single = b.entry(hv.singlevalue, kind)
+	}
+	if hv.typ&WildcardHashValueType == WildcardHashValueType {
+		n.wild = b.entry(hv.wildcardvalue, kind)
+	}
+
+	n.children, n.hashes, n.globs = b.children(hv.hash, kind, glob)
+	return n
+}
+
+// encode returns the frozen tree.
+func (b *frozenBuilder[V]) encode(dt *DomainTreeOf[V], glob uint32) ([]byte, error) {
+	size := frozenHeaderSize + len(b.nodes)*frozenNodeSize +
+		len(b.entries)*frozenEntrySize + len(b.regexes)/3*frozenRegexSize + len(b.strs)
+	data := make([]byte, frozenHeaderSize, size)
+	copy(data, frozenMagic)
+	le := binary.LittleEndian
+	le.PutUint32(data[4:], frozenVersion)
+	le.PutUint32(data[8:], dt.opts.frozenFlags(dt.ranked()))
+	for kind, rank := range dt.opts.precedence {
+		data[12+kind] = rank
+	}
+	le.PutUint32(data[20:], glob)
+	le.PutUint32(data[24:], uint32(len(b.nodes)))
+	le.PutUint32(data[28:], uint32(len(b.entries)))
+	le.PutUint32(data[32:], uint32(len(b.regexes)/3))
+	le.PutUint32(data[36:], uint32(len(b.strs)))
+
+	for _, n := range b.nodes {
+		for _, x := range [...]uint32{n.label, n.labelLen, n.children, n.hashes, n.globs, n.typ, n.full, n.single, n.wild} {
+			data = le.AppendUint32(data, x)
+		}
+	}
+	for _, e := range b.entries {
+		for _, x := range [...]uint32{e.key, e.keyLen, e.value, e.valueLen, e.kind, uint32(e.priority)} {
+			data = le.AppendUint32(data, x)
+		}
+	}
+	for _, x := range b.regexes {
+		data = le.AppendUint32(data, x)
+	}
+	data = append(data, b.strs...)
+
+	le.PutUint32(data[40:], frozenChecksum(data))
+	return data, nil
+}
+
+// frozenFlags returns the flags of the options of a frozen tree, whose
+// matches are ranked if ranked is set.
+func (o *options) frozenFlags(ranked bool) uint32 {
+	var flags uint32
+	for i, set := range []bool{o.nginx, o.singleLabel, o.caseInsensitive, o.trailingDot, o.stripPort, o.idna, o.strict, o.mostSpecific, ranked} {
+		if set {
+			flags |= 1 << i
+		}
+	}
+	return flags
+}
+
+// frozenOptions returns the options of the flags of a frozen tree, ranked
+// being set if its matches are ranked.
+func frozenOptions(flags uint32, precedence []byte) options {
+	var o options
+	for i, set := range []*bool{&o.nginx, &o.singleLabel, &o.caseInsensitive, &o.trailingDot, &o.stripPort, &o.idna, &o.strict, &o.mostSpecific, &o.ranked} {
+		*set = flags&(1<<i) != 0
+	}
+	copy(o.precedence[:], precedence)
+	return o
+}
+
+// frozenChecksum returns the checksum of the frozen tree, skipping the one
+// in the header.
+func frozenChecksum(data []byte) uint32 {
+	crc := crc32.ChecksumIEEE(data[:40])
+	return crc32.Update(crc, crc32.IEEETable, data[44:])
+}
+
+// NewFrozenTree returns the FrozenTree written by WriteTo, whose values are
+// decoded with the codec, the default codec of Codec if nil. The tree uses
+// the memory of data, which must not be modified.
+//
+// ErrInvalidSnapshot, ErrSnapshotVersion and ErrSnapshotChecksum are returned
+// if data is not a valid frozen tree.
+func NewFrozenTree[V any](data []byte, codec ValueCodec[V]) (*FrozenTree[V], error) {
+	if codec == nil {
+		codec = defaultCodec[V]{}
+	}
+
+	if len(data) < frozenHeaderSize || string(data[:len(frozenMagic)]) != frozenMagic {
+		return nil, fmt.Errorf("%w: not a frozen tree", ErrInvalidSnapshot)
+	}
+	le := binary.LittleEndian
+	if version := le.Uint32(data[4:]); version != frozenVersion {
+		return nil, fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+
+	nodes, entries := uint64(le.Uint32(data[24:])), uint64(le.Uint32(data[28:]))
+	regexes, strs := uint64(le.Uint32(data[32:])), uint64(le.Uint32(data[36:]))
+	size := frozenHeaderSize + nodes*frozenNodeSize + entries*frozenEntrySize + regexes*frozenRegexSize + strs
+	if nodes < 2 || size != uint64(len(data)) {
+		return nil, fmt.Errorf("%w: %d bytes instead of %d", ErrInvalidSnapshot, len(data), size)
+	}
+	if le.Uint32(data[40:]) != frozenChecksum(data) {
+		return nil, ErrSnapshotChecksum
+	}
+
+	off := uint64(frozenHeaderSize)
+	section := func(n uint64) []byte {
+		p := data[off : off+n : off+n]
+		off += n
+		return p
+	}
+	ft := &FrozenTree[V]{
+		data:    data,
+		nodes:   section(nodes * frozenNodeSize),
+		entries: section(entries * frozenEntrySize),
+		glob:    le.Uint32(data[20:]),
+		opts:    frozenOptions(le.Uint32(data[8:]), data[12:12+len(defaultPrecedence)]),
+		codec:   codec,
+		regex:   NewRegexTreeOf[uint32](),
+	}
+	regex := section(regexes * frozenRegexSize)
+	ft.bytes = section(strs)
+	// the strings are only sliced, so they share the memory of data
+	ft.strs = *(*string)(unsafe.Pointer(&ft.bytes))
+
+	if err := ft.validate(); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(regex); i += frozenRegexSize {
+		entry, expr, n := le.Uint32(regex[i:]), le.Uint32(regex[i+4:]), le.Uint32(regex[i+8:])
+		if uint64(entry) >= entries || !ft.inStrings(expr, n) {
+			return nil, fmt.Errorf("%w: regular expression %d out of range", ErrInvalidSnapshot, i/frozenRegexSize)
+		}
+		// the expression is copied, as the compiled one refers to it
+		rex, err := regexp.Compile(string(ft.bytes[expr : expr+n]))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+		ft.regex.add(rex.String(), rex, entry)
+	}
+
+	return ft, nil
+}
+
+// validate checks that the nodes and the entries are within the frozen
+// tree, and that the children of a node come after it so that the tries have
+// no cycles, so that the lookups do not need to.
+func (ft *FrozenTree[V]) validate() error {
+	nodes, entries := uint64(len(ft.nodes)/frozenNodeSize), uint32(len(ft.entries)/frozenEntrySize)
+	isEntry := func(e uint32) bool {
+		return e == noFrozenEntry || e < entries
+	}
+	// hasEntry reports whether the node has the entry of its value of the typ
+	hasEntry := func(n frozenNode, typ HashValueType, e uint32) bool {
+		return HashValueType(n.typ)&typ == 0 || e != noFrozenEntry
+	}
+
+	for i := uint32(0); uint64(i) < nodes; i++ {
+		n := ft.node(i)
+		if !ft.inStrings(n.label, n.labelLen) || uint64(n.children)+uint64(n.hashes)+uint64(n.globs) > nodes ||
+			n.hashes+n.globs != 0 && n.children <= i ||
+			!isEntry(n.full) || !isEntry(n.single) || !isEntry(n.wild) ||
+			!hasEntry(n, FullHashValueType, n.full) || !hasEntry(n, SingleWildcardHashValueType, n.single) ||
+			!hasEntry(n, WildcardHashValueType, n.wild) {
+			return fmt.Errorf("%w: node %d out of range", ErrInvalidSnapshot, i)
+		}
+	}
+	for i := uint32(0); i < entries; i++ {
+		e := ft.entry(i)
+		if !ft.inStrings(e.key, e.keyLen) || !ft.inStrings(e.value, e.valueLen) || e.kind >= uint32(len(defaultPrecedence)) {
+			return fmt.Errorf("%w: entry %d out of range", ErrInvalidSnapshot, i)
+		}
+	}
+	if !isEntry(ft.glob) {
+		return fmt.Errorf("%w: glob out of range", ErrInvalidSnapshot)
+	}
+	return nil
+}
+
+// inStrings reports whether the n bytes at off are within the strings.
+func (ft *FrozenTree[V]) inStrings(off, n uint32) bool {
+	return uint64(off)+uint64(n) <= uint64(len(ft.strs))
+}
+
+// OpenFrozenTree opens the frozen tree written by WriteTo to the file, which
+// is memory-mapped where supported and read otherwise, like NewFrozenTree.
+// The tree must be closed to unmap the file.
+func OpenFrozenTree[V any](path string, codec ValueCodec[V]) (*FrozenTree[V], error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ft, err := NewFrozenTree(data, codec)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("domaintree: opening %s: %w", path, err)
+	}
+	ft.unmap = unmap
+	return ft, nil
+}
+
+// Close unmaps the file of a tree opened by OpenFrozenTree. The tree must not
+// be used afterwards.
+func (ft *FrozenTree[V]) Close() error {
+	if ft.unmap == nil {
+		return nil
+	}
+	err := ft.unmap()
+	ft.unmap = nil
+	return err
+}
+
+// WriteTo writes the frozen tree to w, to be opened by OpenFrozenTree or
+// NewFrozenTree.
+func (ft *FrozenTree[V]) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(ft.data)
+	return int64(n), err
+}
+
+// WriteFile writes the frozen tree to the file, replacing it atomically.
+func (ft *FrozenTree[V]) WriteFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := ft.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Len returns the number of entries of the tree, the regular expressions
+// included.
+func (ft *FrozenTree[V]) Len() int {
+	return len(ft.entries) / frozenEntrySize
+}
+
+func (ft *FrozenTree[V]) node(i uint32) frozenNode {
+	p := ft.nodes[int(i)*frozenNodeSize:][:frozenNodeSize]
+	le := binary.LittleEndian
+	return frozenNode{
+		label:    le.Uint32(p[0:]),
+		labelLen: le.Uint32(p[4:]),
+		children: le.Uint32(p[8:]),
+		hashes:   le.Uint32(p[12:]),
+		globs:    le.Uint32(p[16:]),
+		typ:      le.Uint32(p[20:]),
+		full:     le.Uint32(p[24:]),
+		single:   le.Uint32(p[28:]),
+		wild:     le.Uint32(p[32:]),
+	}
+}
+
+func (ft *FrozenTree[V]) entry(i uint32) frozenEntry {
+	p := ft.entries[int(i)*frozenEntrySize:][:frozenEntrySize]
+	le := binary.LittleEndian
+	return frozenEntry{
+		key:      le.Uint32(p[0:]),
+		keyLen:   le.Uint32(p[4:]),
+		value:    le.Uint32(p[8:]),
+		valueLen: le.Uint32(p[12:]),
+		kind:     le.Uint32(p[16:]),
+		priority: int32(le.Uint32(p[20:])),
+	}
+}
+
+// label returns the label of the node i without reading the rest of it.
+func (ft *FrozenTree[V]) label(i uint32) string {
+	p := ft.nodes[int(i)*frozenNodeSize:][:8]
+	off, n := binary.LittleEndian.Uint32(p), binary.LittleEndian.Uint32(p[4:])
+	return ft.strs[off : off+n]
+}
+
+// child returns the child of the label, which is not a pattern, searching
+// the sorted labels of the children.
+func (ft *FrozenTree[V]) child(n frozenNode, label string) (uint32, bool) {
+	lo, hi := n.children, n.children+n.hashes
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if ft.label(mid) < label {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, lo < n.children+n.hashes && ft.label(lo) == label
+}
+
+// frozenHashMatch is a node which matched the key in lookupAll, see
+// hashMatch.
+type frozenHashMatch struct {
+	entry    uint32
+	typ      HashValueType
+	rest     string
+	consumed bool
+	glob     bool
+}
+
+// lookupAll calls fn for every node under the node which matches the key,
+// like WildcardHash.lookupAll.
+func (ft *FrozenTree[V]) lookupAll(n frozenNode, key string, indexer StringIndexer, glob bool, fn func(m frozenHashMatch) bool) bool {
+	sub, remaining, success := indexer(key, ".")
+
+	if i, ok := ft.child(n, sub); ok {
+		if !ft.lookupNode(ft.node(i), remaining, success, indexer, glob, fn) {
+			return false
+		}
+	}
+
+	for i := n.children + n.hashes; i < n.children+n.hashes+n.globs; i++ {
+		if matchLabel(ft.label(i), sub) {
+			if !ft.lookupNode(ft.node(i), remaining, success, indexer, true, fn) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// lookupNode is HashValue.lookupAll.
+func (ft *FrozenTree[V]) lookupNode(n frozenNode, remaining string, success bool, indexer StringIndexer, glob bool, fn func(m frozenHashMatch) bool) bool {
+	typ := HashValueType(n.typ)
+	if !success {
+		if typ&FullHashValueType == FullHashValueType {
+			if !fn(frozenHashMatch{entry: n.full, typ: FullHashValueType, glob: glob}) {
+				return false
+			}
+		}
+		if typ&(WildcardHashValueType|ApexHashValueType) == WildcardHashValueType|ApexHashValueType {
+			return fn(frozenHashMatch{entry: n.wild, typ: WildcardHashValueType, glob: glob})
+		}
+		return true
+	}
+
+	if !ft.lookupAll(n, remaining, indexer, glob, fn) {
+		return false
+	}
+
+	if typ&SingleWildcardHashValueType == SingleWildcardHashValueType && isSingleLabel(remaining) {
+		if !fn(frozenHashMatch{entry: n.single, typ: SingleWildcardHashValueType, rest: remaining, consumed: true, glob: glob}) {
+			return false
+		}
+	}
+
+	if typ&WildcardHashValueType == WildcardHashValueType {
+		return fn(frozenHashMatch{entry: n.wild, typ: WildcardHashValueType, rest: remaining, consumed: true, glob: glob})
+	}
+
+	return true
+}
+
+// frozenMatch is an entry which matched a hostname, see Match.
+type frozenMatch struct {
+	entry   uint32
+	kind    MatchKind
+	literal string
+	labels  int // the labels required by the regular expression
+}
+
+// matches calls fn for every entry which matches the normalized key in the
+// order of DomainTree.matches, until fn returns false.
+func (ft *FrozenTree[V]) matches(key string, fn func(m frozenMatch) bool) {
+	ok := ft.lookupAll(ft.node(0), key, PrefixIndexer, false, func(m frozenHashMatch) bool {
+		switch {
+		case m.typ == FullHashValueType && m.glob:
+			return fn(frozenMatch{entry: m.entry, kind: LabelWildcardMatchKind, literal: key})
+		case m.typ == FullHashValueType:
+			return fn(frozenMatch{entry: m.entry, kind: FullMatchKind, literal: key})
+		case !m.consumed:
+			return fn(frozenMatch{entry: m.entry, kind: LeadingWildcardMatchKind, literal: key})
+		}
+		return fn(frozenMatch{entry: m.entry, kind: LeadingWildcardMatchKind, literal: key[len(m.rest)+1:]})
+	})
+	if !ok {
+		return
+	}
+
+	if ft.glob != noFrozenEntry && !fn(frozenMatch{entry: ft.glob, kind: GlobMatchKind}) {
+		return
+	}
+
+	ok = ft.lookupAll(ft.node(1), key, SuffixIndexer, false, func(m frozenHashMatch) bool {
+		switch {
+		case m.typ == FullHashValueType && m.glob:
+			return fn(frozenMatch{entry: m.entry, kind: LabelWildcardMatchKind, literal: key})
+		case m.typ == FullHashValueType:
+			return fn(frozenMatch{entry: m.entry, kind: FullMatchKind, literal: key})
+		case !m.consumed:
+			return fn(frozenMatch{entry: m.entry, kind: TrailingWildcardMatchKind, literal: key})
+		}
+		return fn(frozenMatch{entry: m.entry, kind: TrailingWildcardMatchKind, literal: key[:len(key)-len(m.rest)-1]})
+	})
+	if !ok {
+		return
+	}
+
+	ft.regex.lookupAll(key, func(rv *regexValue[uint32]) bool {
+		return fn(frozenMatch{entry: rv.value, kind: RegexMatchKind, labels: rv.literal.count()})
+	})
+}
+
+// less reports whether the match a wins over b like DomainTree.less.
+func (ft *FrozenTree[V]) less(a, b *frozenMatch) bool {
+	ea, eb := ft.entry(a.entry), ft.entry(b.entry)
+	if ea.priority != eb.priority {
+		return ea.priority > eb.priority
+	}
+	return ft.rank(a, ea) < ft.rank(b, eb)
+}
+
+func (ft *FrozenTree[V]) rank(m *frozenMatch, e frozenEntry) int {
+	labels := 0
+	if ft.opts.mostSpecific {
+		labels = specificity(m.kind, ft.strs[e.key:e.key+e.keyLen], m.literal, m.labels)
+	}
+	return ft.opts.rank(m.kind, labels)
+}
+
+// lookup returns the winning match of the key.
+func (ft *FrozenTree[V]) lookup(key string) (frozenMatch, bool) {
+	var match frozenMatch
+	key, ok := ft.opts.normalizeHost(key)
+	if !ok {
+		return match, false
+	}
+
+	found := false
+	ft.matches(key, func(m frozenMatch) bool {
+		if !found || ft.opts.ranked && ft.less(&m, &match) {
+			match, found = m, true
+		}
+		return ft.opts.ranked
+	})
+	return match, found
+}
+
+// value decodes the value of the entry.
+func (ft *FrozenTree[V]) value(e frozenEntry) (V, error) {
+	value, err := ft.codec.DecodeValue(ft.bytes[e.value : e.value+e.valueLen])
+	if err != nil {
+		return value, fmt.Errorf("domaintree: decoding the value of %q: %w", ft.strs[e.key:e.key+e.keyLen], err)
+	}
+	return value, nil
+}
+
+// Lookup lookups the key like DomainTree.Lookup and returns the value of the
+// entry which matched. The error is the one of the ValueCodec if the value
+// can not be decoded, in which case ok is true.
+func (ft *FrozenTree[V]) Lookup(key string) (value V, ok bool, err error) {
+	m, ok := ft.lookup(key)
+	if !ok {
+		return value, false, nil
+	}
+	value, err = ft.value(ft.entry(m.entry))
+	return value, true, err
+}
+
+// LookupEntry lookups the key like Lookup and returns the entry which
+// matched, whose Kind is how it matched.
+func (ft *FrozenTree[V]) LookupEntry(key string) (Entry[V], bool, error) {
+	m, ok := ft.lookup(key)
+	if !ok {
+		return Entry[V]{}, false, nil
+	}
+
+	e := ft.entry(m.entry)
+	value, err := ft.value(e)
+	if err != nil {
+		return Entry[V]{}, true, err
+	}
+	return Entry[V]{
+		// copied, as the tree may be closed
+		Key:      string(ft.bytes[e.key : e.key+e.keyLen]),
+		Kind:     m.kind,
+		Value:    value,
+		Priority: int(e.priority),
+	}, true, nil
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package domaintree
+
+import "os"
+
+// mapFile reads the file where it can not be mapped into memory.
+func mapFile(path string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
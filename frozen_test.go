@@ -0,0 +1,249 @@
+package domaintree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// requireFrozenLookups checks that the frozen tree looks up the hosts like
+// the tree.
+func requireFrozenLookups(t *testing.T, dt *DomainTreeOf[string], ft *FrozenTree[string], hosts []string) {
+	t.Helper()
+	for _, host := range hosts {
+		expect, eok := dt.LookupMatch(host)
+		got, ok, err := ft.LookupEntry(host)
+		require.NoError(t, err, host)
+		require.Equal(t, eok, ok, host)
+		if !ok {
+			continue
+		}
+		require.Equal(t, expect.Node.GetKey(), got.Key, host)
+		require.Equal(t, expect.Node.GetValue(), got.Value, host)
+		require.Equal(t, expect.Node.GetPriority(), got.Priority, host)
+		require.Equal(t, expect.Kind, got.Kind, host)
+
+		value, ok, err := ft.Lookup(host)
+		require.NoError(t, err, host)
+		require.True(t, ok, host)
+		require.Equal(t, expect.Node.GetValue(), value, host)
+	}
+}
+
+func TestFrozen(t *testing.T) {
+	hosts := []string{
+		"example.com", "a.example.com", "a.b.example.com", "api-1.example.com", "api.x.example.com",
+		"www.example.net", "www.example.co.uk", "www1.example.org", "mail.example", "EXAMPLE.COM.",
+		"www.example.com:8080", "www.bücher.example", "www2.münchen.example", "example.org", "org", "",
+	}
+
+	for _, tt := range []struct {
+		opts []Option
+		keys []string
+	}{
+		{nil, []string{"*", "example.com", "*.example.com", "api-*.example.com", "api.*.example.com", "www.example.*", `^www\d+\.`}},
+		{nil, []string{"www.example.com", "example.*", "*.b.example.com"}},
+		{[]Option{SingleLabelWildcard(), CaseInsensitive(), IgnoreTrailingDot()}, []string{"*.Example.com", "**.example.com", "example.**", "mail.*"}},
+		{[]Option{NginxMode(), IDNA()}, []string{".bücher.example", "*.example.com", "mail.*", `~^www\d+\.münchen\.example$`, "_"}},
+		{[]Option{NginxMode(), StripPort()}, []string{"example.com", ".example.com", "www.example.*", `~^api`}},
+		{[]Option{MostSpecific()}, []string{"*", "*.example.com", "www.example.*", "a.b.example.*", `\.example\.com$`}},
+		{[]Option{Precedence(RegexMatchKind, TrailingWildcardMatchKind)}, []string{"*.example.com", "www.example.*", `^www\.`}},
+	} {
+		dt := NewDomainTreeOf[string](tt.opts...)
+		for i, key := range tt.keys {
+			if strings.ContainsAny(key, `^\$`) && !dt.opts.nginx {
+				require.NoError(t, dt.AddRegexWithOptions(key, key, Priority(i%2)))
+				continue
+			}
+			require.NoError(t, dt.AddWithOptions(key, key, Priority(i%2)))
+		}
+
+		ft, err := dt.Freeze()
+		require.NoError(t, err)
+		require.Equal(t, len(tt.keys), ft.Len())
+		requireFrozenLookups(t, dt, ft, hosts)
+		require.NoError(t, ft.Close())
+	}
+}
+
+func TestFrozenRandom(t *testing.T) {
+	labels := []string{"a", "b", "c", "ab", "a-*", "*"}
+	random := func(r *rand.Rand, glob bool) string {
+		n := 1 + r.Intn(4)
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = labels[r.Intn(len(labels)-2)]
+			if glob {
+				parts[i] = labels[r.Intn(len(labels))]
+			}
+		}
+		return strings.Join(parts, ".")
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for _, opts := range [][]Option{nil, {SingleLabelWildcard()}, {NginxMode()}, {MostSpecific()}} {
+		dt := NewDomainTreeOf[string](opts...)
+		for i := 0; i < 200; i++ {
+			key := random(r, true)
+			dt.AddWithOptions(key, key, Priority(r.Intn(2)))
+		}
+
+		ft, err := dt.Freeze()
+		require.NoError(t, err)
+		hosts := make([]string, 500)
+		for i := range hosts {
+			hosts[i] = random(r, false)
+		}
+		requireFrozenLookups(t, dt, ft, hosts)
+	}
+}
+
+func TestFrozenFile(t *testing.T) {
+	dt := NewDomainTreeOf[int](Codec[int](intCodec{}))
+	require.NoError(t, dt.Add("*.example.com", 1))
+	require.NoError(t, dt.Add("www.example.com", 200))
+	require.NoError(t, dt.AddRegex(`^mail\.`, 3))
+
+	ft, err := dt.Freeze()
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "tree.frozen")
+	require.NoError(t, ft.WriteFile(path))
+
+	var buf bytes.Buffer
+	n, err := ft.WriteTo(&buf)
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, data, buf.Bytes())
+
+	ft, err = OpenFrozenTree[int](path, intCodec{})
+	require.NoError(t, err)
+	for host, expect := range map[string]int{"a.example.com": 1, "www.example.com": 200, "mail.example.org": 3} {
+		value, ok, err := ft.Lookup(host)
+		require.NoError(t, err, host)
+		require.True(t, ok, host)
+		require.Equal(t, expect, value, host)
+	}
+	_, ok, err := ft.Lookup("example.org")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, ft.Close())
+	require.NoError(t, ft.Close())
+
+	// the default codec does not decode the values of intCodec, which is not
+	// a miss
+	ft, err = OpenFrozenTree[int](path, nil)
+	require.NoError(t, err)
+	_, ok, err = ft.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Error(t, err)
+	_, ok, err = ft.LookupEntry("www.example.com")
+	require.True(t, ok)
+	require.Error(t, err)
+	_, ok, err = ft.Lookup("example.org")
+	require.False(t, ok)
+	require.NoError(t, err)
+	require.NoError(t, ft.Close())
+
+	_, err = OpenFrozenTree[int](filepath.Join(t.TempDir(), "missing"), nil)
+	require.True(t, errors.Is(err, os.ErrNotExist))
+
+	empty := filepath.Join(t.TempDir(), "empty")
+	require.NoError(t, os.WriteFile(empty, nil, 0o644))
+	_, err = OpenFrozenTree[int](empty, nil)
+	require.True(t, errors.Is(err, ErrInvalidSnapshot))
+}
+
+func TestFrozenTrees(t *testing.T) {
+	ldt := NewLockedDomainTreeOf[string]()
+	require.NoError(t, ldt.Add("*.example.com", "leading"))
+	ft, err := ldt.Freeze()
+	require.NoError(t, err)
+	value, ok, err := ft.Lookup("www.example.com")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "leading", value)
+
+	adt := NewAtomicDomainTreeOf[string]()
+	require.NoError(t, adt.Add("example.*", "trailing"))
+	ft, err = adt.Freeze()
+	require.NoError(t, err)
+	value, ok, err = ft.Lookup("example.org")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "trailing", value)
+}
+
+func TestFrozenInvalid(t *testing.T) {
+	dt := NewDomainTreeOf[string]()
+	require.NoError(t, dt.Add("*.example.com", "leading"))
+	require.NoError(t, dt.AddRegex(`^www\.`, "regex"))
+	ft, err := dt.Freeze()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = ft.WriteTo(&buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	corrupt := func(fn func(p []byte) []byte) error {
+		_, err := NewFrozenTree[string](fn(append([]byte(nil), data...)), nil)
+		return err
+	}
+
+	require.True(t, errors.Is(corrupt(func(p []byte) []byte { return p[:len(p)-1] }), ErrInvalidSnapshot))
+	require.True(t, errors.Is(corrupt(func(p []byte) []byte { return p[:10] }), ErrInvalidSnapshot))
+	require.True(t, errors.Is(corrupt(func(p []byte) []byte { p[0] = 'X'; return p }), ErrInvalidSnapshot))
+	require.True(t, errors.Is(corrupt(func(p []byte) []byte { p[4] = 9; return p }), ErrSnapshotVersion))
+	require.True(t, errors.Is(corrupt(func(p []byte) []byte { p[len(p)-1] ^= 1; return p }), ErrSnapshotChecksum))
+
+	// a node out of range with a valid checksum
+	checksum := func(p []byte) []byte {
+		binary.LittleEndian.PutUint32(p[40:], frozenChecksum(p))
+		return p
+	}
+	err = corrupt(func(p []byte) []byte {
+		p[frozenHeaderSize+8] = 0xff
+		return checksum(p)
+	})
+	require.True(t, errors.Is(err, ErrInvalidSnapshot))
+
+	// a node which is its own child
+	err = corrupt(func(p []byte) []byte {
+		binary.LittleEndian.PutUint32(p[frozenHeaderSize+2*frozenNodeSize+8:], 2)
+		binary.LittleEndian.PutUint32(p[frozenHeaderSize+2*frozenNodeSize+12:], 1)
+		return checksum(p)
+	})
+	require.True(t, errors.Is(err, ErrInvalidSnapshot))
+}
+
+func BenchmarkFrozen(b *testing.B) {
+	dt := NewDomainTreeOf[string]()
+	for i := 0; i < 100000; i++ {
+		dt.Add(strings.Repeat("a", i%7)+"x"+string(rune('a'+i%26))+".example"+string(rune('a'+i/26%26))+".com", "v")
+	}
+	dt.Add("*.example.com", "leading")
+	ft, err := dt.Freeze()
+	require.NoError(b, err)
+
+	for _, host := range []string{"aaxb.exampleb.com", "www.example.com", "www.example.org"} {
+		b.Run("tree/"+host, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				dt.Lookup(host)
+			}
+		})
+		b.Run("frozen/"+host, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ft.Lookup(host)
+			}
+		})
+	}
+}
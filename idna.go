@@ -0,0 +1,234 @@
+package domaintree
+
+import (
+	"errors"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	errIDNAWildcard = errors.New("idna: wildcard within an internationalized label")
+	errIDNARegex    = errors.New("idna: internationalized literal is not a whole label")
+)
+
+// idnaProfile maps the names as UTS #46 does for the lookup, but lets the
+// wildcards and the underscores through.
+var idnaProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+// IDNA makes the tree canonicalize the internationalized domain names to
+// their ASCII form with the UTS #46 mapping on Add, AddRegex and Lookup, so
+// *.bücher.example matches both www.bücher.example and
+// www.xn--bcher-kva.example. Add reports an invalid name and Lookup never
+// matches one. IDNA implies CaseInsensitive.
+//
+// The non-ASCII literals of a regular expression are converted as well,
+// which requires them to be whole labels as in ^(.+)\.bücher\.example$.
+//
+// Walk reports the keys in the ASCII form and WalkUnicode in the Unicode
+// form. A hostname which is already ASCII is looked up without allocation.
+func IDNA() Option {
+	return func(o *options) {
+		o.idna = true
+		o.caseInsensitive = true
+	}
+}
+
+// canonicalKey normalizes a key on Add or Del and converts it to the ASCII
+// form in IDNA mode.
+func (dt *DomainTreeOf[V]) canonicalKey(key string) (string, error) {
+	key = dt.normalizeKey(key)
+	if dt.opts.idna {
+		return toIDNA(key)
+	}
+	return key, nil
+}
+
+// compileRegex compiles the regular expression, converting its non-ASCII
+// literals to the ASCII form in IDNA mode.
+func (dt *DomainTreeOf[V]) compileRegex(expr string) (*regexp.Regexp, error) {
+	if dt.opts.idna {
+		var err error
+		expr, err = idnaRegex(expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return regexp.Compile(expr)
+}
+
+// WalkUnicode walks the domain tree like Walk but reports the keys with their
+// A-labels converted to U-labels, e.g. bücher.example rather than
+// xn--bcher-kva.example. The regular expressions are reported as added.
+func (dt *DomainTreeOf[V]) WalkUnicode(fn func(key string, value V)) {
+	dt.entries(func(e Entry[V]) bool {
+		if e.Kind != RegexMatchKind {
+			e.Key = toUnicode(e.Key)
+		}
+		fn(e.Key, e.Value)
+		return true
+	})
+}
+
+// toIDNA converts the labels of the key to A-labels. The labels holding a
+// wildcard must be ASCII.
+func toIDNA(key string) (string, error) {
+	if isASCII(key) && !strings.Contains(key, "xn--") {
+		return key, nil
+	}
+
+	labels := strings.Split(key, ".")
+	for i, label := range labels {
+		if isASCII(label) && !strings.HasPrefix(label, "xn--") {
+			continue
+		}
+		if strings.Contains(label, "*") {
+			return "", errIDNAWildcard
+		}
+		a, err := idnaProfile.ToASCII(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = a
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+// toIDNAHost converts the hostname to A-labels. ok is false if the hostname
+// is not a valid IDN.
+func toIDNAHost(host string) (string, bool) {
+	if isASCII(host) {
+		return host, true
+	}
+	host, err := idnaProfile.ToASCII(host)
+	return host, err == nil
+}
+
+// toUnicode converts the A-labels of the key to U-labels, leaving the labels
+// which fail to convert as they are.
+func toUnicode(key string) string {
+	if !strings.Contains(key, "xn--") {
+		return key
+	}
+
+	labels := strings.Split(key, ".")
+	for i, label := range labels {
+		if !strings.HasPrefix(label, "xn--") {
+			continue
+		}
+		if u, err := idna.ToUnicode(label); err == nil {
+			labels[i] = u
+		}
+	}
+
+	return strings.Join(labels, ".")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// idnaRegex converts the non-ASCII literals of the regular expression to
+// A-labels. Such a literal must be made of whole labels, i.e. be delimited by
+// dots or by the anchors ^ and $.
+func idnaRegex(expr string) (string, error) {
+	if isASCII(expr) {
+		return expr, nil
+	}
+
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+
+	if err := idnaRegexp(re, false, false); err != nil {
+		return "", err
+	}
+
+	return re.String(), nil
+}
+
+// idnaRegexp converts the literals of re in place. left and right report
+// whether re is preceded and followed by a label boundary.
+func idnaRegexp(re *syntax.Regexp, left, right bool) error {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return idnaLiteral(re, left, right)
+
+	case syntax.OpConcat:
+		for i, sub := range re.Sub {
+			l := left
+			if i > 0 {
+				l = isLabelBoundary(re.Sub[i-1])
+			}
+			r := right
+			if i < len(re.Sub)-1 {
+				r = isLabelBoundary(re.Sub[i+1])
+			}
+			if err := idnaRegexp(sub, l, r); err != nil {
+				return err
+			}
+		}
+
+	case syntax.OpCapture, syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if err := idnaRegexp(sub, left, right); err != nil {
+				return err
+			}
+		}
+
+	default:
+		for _, sub := range re.Sub {
+			if err := idnaRegexp(sub, false, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isLabelBoundary reports whether re delimits a label.
+func isLabelBoundary(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpBeginText, syntax.OpEndText, syntax.OpBeginLine, syntax.OpEndLine:
+		return true
+	}
+	return false
+}
+
+// idnaLiteral converts the non-ASCII labels of the literal. The first and the
+// last label are whole only if the literal is delimited by left and right.
+func idnaLiteral(re *syntax.Regexp, left, right bool) error {
+	lit := string(re.Rune)
+	if isASCII(lit) {
+		return nil
+	}
+
+	labels := strings.Split(lit, ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		if (i == 0 && !left) || (i == len(labels)-1 && !right) {
+			return errIDNARegex
+		}
+		a, err := idnaProfile.ToASCII(label)
+		if err != nil {
+			return err
+		}
+		labels[i] = a
+	}
+
+	re.Rune = []rune(strings.Join(labels, "."))
+	return nil
+}
@@ -0,0 +1,117 @@
+package domaintree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIDNA(t *testing.T) {
+	dt := NewDomainTreeOf[string](IDNA())
+	require.NoError(t, dt.Add("*.bücher.example", "*.bücher.example"))
+	require.NoError(t, dt.Add("www.xn--mnchen-3ya.example", "www.xn--mnchen-3ya.example"))
+	require.NoError(t, dt.Add("Straße.*", "Straße.*"))
+	require.NoError(t, dt.AddRegex(`^(.+)\.bücher\.test$`, `^(.+)\.bücher\.test$`))
+
+	for _, tt := range []struct {
+		input  string
+		expect string
+	}{
+		{"www.bücher.example", "*.bücher.example"},
+		{"www.xn--bcher-kva.example", "*.bücher.example"},
+		{"WWW.BÜCHER.EXAMPLE", "*.bücher.example"},
+		{"www.XN--BCHER-KVA.example", "*.bücher.example"},
+		{"www.münchen.example", "www.xn--mnchen-3ya.example"},
+		{"www.xn--mnchen-3ya.example", "www.xn--mnchen-3ya.example"},
+		{"xn--strae-oqa.com", "Straße.*"},
+		{"straße.com", "Straße.*"},
+		{"a.xn--bcher-kva.test", `^(.+)\.bücher\.test$`},
+		{"a.bücher.test", `^(.+)\.bücher\.test$`},
+	} {
+		dn, ok := dt.Lookup(tt.input)
+		require.True(t, ok, tt.input)
+		require.Equal(t, tt.expect, dn.GetKey(), tt.input)
+	}
+
+	m, ok := dt.LookupMatch("www.bücher.test")
+	require.True(t, ok)
+	require.Equal(t, []string{"www.xn--bcher-kva.test", "www"}, m.Submatches)
+
+	_, ok = dt.Lookup("www.bucher.example")
+	require.False(t, ok)
+
+	require.True(t, dt.Del("*.xn--bcher-kva.example"))
+	_, ok = dt.Lookup("www.bücher.example")
+	require.False(t, ok)
+
+	// without the option the labels are compared as they are
+	dt = NewDomainTreeOf[string]()
+	require.NoError(t, dt.Add("*.bücher.example", "*.bücher.example"))
+	_, ok = dt.Lookup("www.xn--bcher-kva.example")
+	require.False(t, ok)
+}
+
+func TestIDNAInvalid(t *testing.T) {
+	dt := NewDomainTreeOf[int](IDNA())
+	require.Error(t, dt.Add("́abc.example", 1))
+	require.Error(t, dt.Add("bü*.example", 1))
+	require.Error(t, dt.AddRegex(`^[a-z]+ücher\.example$`, 1))
+	require.Error(t, dt.AddRegex(`bücher\.example`, 1))
+	require.Empty(t, dt.regex.regex)
+
+	require.NoError(t, dt.Add("*", 1))
+	_, ok := dt.Lookup("́abc.example")
+	require.False(t, ok)
+
+	txn := NewLockedDomainTreeOf[int](IDNA()).Begin()
+	require.Error(t, txn.Add("́abc.example", 1))
+	require.Equal(t, 0, txn.Len())
+}
+
+func TestIDNANginx(t *testing.T) {
+	dt := NewDomainTreeOf[string](NginxMode(), IDNA())
+	require.NoError(t, dt.Add(".bücher.example", ".bücher.example"))
+	require.NoError(t, dt.Add(`~^(?<sub>.+)\.münchen\.example$`, "regex"))
+
+	dn, ok := dt.Lookup("xn--bcher-kva.example")
+	require.True(t, ok)
+	require.Equal(t, ".bücher.example", dn.GetKey())
+
+	m, ok := dt.LookupMatch("Www.MÜNCHEN.example")
+	require.True(t, ok)
+	require.Equal(t, "regex", m.Node.GetValue())
+	sub, ok := m.Named("sub")
+	require.True(t, ok)
+	require.Equal(t, "www", sub)
+
+	require.True(t, dt.Del(".xn--bcher-kva.example"))
+	require.True(t, dt.Del(`~^(?<sub>.+)\.münchen\.example$`))
+}
+
+func TestIDNAWalk(t *testing.T) {
+	dt := NewDomainTreeOf[int](IDNA())
+	require.NoError(t, dt.Add("bücher.example", 1))
+	require.NoError(t, dt.Add("www.example", 2))
+
+	var ascii, unicode []string
+	dt.Walk(func(key string, _ int) {
+		ascii = append(ascii, key)
+	})
+	dt.WalkUnicode(func(key string, _ int) {
+		unicode = append(unicode, key)
+	})
+	require.Equal(t, []string{"www.example", "xn--bcher-kva.example"}, ascii)
+	require.Equal(t, []string{"www.example", "bücher.example"}, unicode)
+}
+
+func TestIDNAZeroAlloc(t *testing.T) {
+	dt := NewDomainTreeOf[int](IDNA())
+	require.NoError(t, dt.Add("*.bücher.example", 1))
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, ok := dt.Lookup("www.xn--bcher-kva.example"); !ok {
+			t.Fatal("failed to lookup")
+		}
+	})
+	require.Equal(t, float64(0), allocs)
+}
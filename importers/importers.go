@@ -0,0 +1,340 @@
+// Package importers imports the domain lists of the DNS blocklists into a
+// domain tree: /etc/hosts files, Adblock Plus rules, dnsmasq configurations
+// and plain lists of domains.
+//
+// The lists are parsed a line at a time, so that a list of millions of lines
+// is never held in memory, and each rule becomes an entry of the tree:
+//
+//	hosts     0.0.0.0 ads.example.com      ads.example.com
+//	adblock   ||example.com^               example.com and *.example.com
+//	adblock   @@||cdn.example.com^         allowed cdn.example.com and *.cdn.example.com
+//	dnsmasq   address=/example.com/0.0.0.0 example.com and *.example.com
+//	domains   ads.example.com              ads.example.com
+//
+// The wildcards match any number of labels, as in the default mode of the
+// tree.
+//
+// ParseNginx reads the server blocks of an nginx configuration into a tree
+// per listen address, to find the block serving a host without nginx.
+package importers
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	domaintree "github.com/detailyang/domaintree-go"
+)
+
+// Format is the format of a list.
+type Format uint8
+
+const (
+	// Hosts is the format of /etc/hosts: an address and its names per line.
+	Hosts Format = iota
+	// Adblock is the format of the Adblock Plus filters, of which the rules
+	// of whole domains like ||example.com^ and their exceptions like
+	// @@||example.com^ are imported.
+	Adblock
+	// Dnsmasq is the format of the dnsmasq configuration, of which the
+	// address=/domain/address and local=/domain/ options are imported.
+	Dnsmasq
+	// Domains is a plain list of domains, one per line.
+	Domains
+)
+
+func (f Format) String() string {
+	switch f {
+	case Hosts:
+		return "hosts"
+	case Adblock:
+		return "adblock"
+	case Dnsmasq:
+		return "dnsmasq"
+	case Domains:
+		return "domains"
+	}
+	return "unknown"
+}
+
+// Action is what a rule does with the domains it matches.
+type Action uint8
+
+const (
+	// Block blocks the domains.
+	Block Action = iota
+	// Allow allows the domains in spite of the rules which block them, like
+	// the exceptions of Adblock.
+	Allow
+)
+
+func (a Action) String() string {
+	if a == Allow {
+		return "allow"
+	}
+	return "block"
+}
+
+// AllowPriority is the priority of the entries of the Allow rules, so that
+// they win over the Block ones: @@||cdn.example.com^ allows
+// www.cdn.example.com blocked by ||example.com^.
+const AllowPriority = 1
+
+// Rule is an entry of a list.
+type Rule struct {
+	// Pattern is the key of the entry, e.g. example.com or *.example.com.
+	Pattern string
+	Action  Action
+	// Address is the address of a hosts or dnsmasq rule, e.g. 0.0.0.0, empty
+	// for dnsmasq when the domain does not resolve.
+	Address string
+	// Line is the line of the rule in the list.
+	Line int
+}
+
+// maxLine is the length of the longest line of a list.
+const maxLine = 1 << 20
+
+// Parse calls fn for every rule of the list read from r, a line at a time,
+// until fn returns an error, which Parse returns. The blank lines and the
+// comments are skipped, and ignored counts the other lines which are not
+// rules of the format, like the cosmetic filters of Adblock.
+func Parse(r io.Reader, format Format, fn func(rule Rule) error) (ignored int, err error) {
+	var parse func(line string, emit func(pattern, address string, action Action)) bool
+	switch format {
+	case Hosts:
+		parse = parseHosts
+	case Adblock:
+		parse = parseAdblock
+	case Dnsmasq:
+		parse = parseDnsmasq
+	case Domains:
+		parse = parseDomains
+	default:
+		return 0, fmt.Errorf("importers: unknown format %d", format)
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 4096), maxLine)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		ok := parse(line, func(pattern, address string, action Action) {
+			if err == nil {
+				err = fn(Rule{Pattern: pattern, Action: action, Address: address, Line: n})
+			}
+		})
+		if err != nil {
+			return ignored, err
+		}
+		if !ok {
+			ignored++
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return ignored, fmt.Errorf("importers: line %d: %w", n+1, err)
+	}
+	return ignored, nil
+}
+
+// localNames are the names of the loopback and broadcast addresses of the
+// hosts files, which are not blocked.
+var localNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+}
+
+// stripComment strips the comment starting with # from the line.
+func stripComment(line string) string {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+	return line
+}
+
+// parseHosts parses a line like 0.0.0.0 ads.example.com tracker.example.com.
+func parseHosts(line string, emit func(pattern, address string, action Action)) bool {
+	line = stripComment(line)
+	if line == "" {
+		return true
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+		return false
+	}
+	for _, name := range fields[1:] {
+		if !localNames[name] && net.ParseIP(name) == nil {
+			emit(name, fields[0], Block)
+		}
+	}
+	return true
+}
+
+// parseDomains parses a line holding a domain.
+func parseDomains(line string, emit func(pattern, address string, action Action)) bool {
+	line = stripComment(line)
+	if line == "" {
+		return true
+	}
+	if strings.ContainsAny(line, " \t") {
+		return false
+	}
+	emit(line, "", Block)
+	return true
+}
+
+// parseAdblock parses a rule like ||example.com^ or @@||example.com^.
+func parseAdblock(line string, emit func(pattern, address string, action Action)) bool {
+	if line[0] == '!' || line[0] == '[' {
+		// a comment or the [Adblock Plus 2.0] header
+		return true
+	}
+
+	action := Block
+	if strings.HasPrefix(line, "@@") {
+		action, line = Allow, line[2:]
+	}
+	if !strings.HasPrefix(line, "||") {
+		return false
+	}
+	line = strings.TrimSuffix(line[2:], "|")
+	domain := strings.TrimSuffix(line, "^")
+	if len(domain) == len(line) || !isDomain(domain) {
+		// a rule with options or a path, or a prefix of the domain
+		return false
+	}
+
+	emitDomain(domain, "", action, emit)
+	return true
+}
+
+// parseDnsmasq parses an option like address=/example.com/0.0.0.0 or
+// local=/example.com/, the domain # matching any domain.
+func parseDnsmasq(line string, emit func(pattern, address string, action Action)) bool {
+	if line[0] == '#' {
+		// a comment, # being the address of the domains which do not resolve
+		return true
+	}
+
+	name, value, ok := strings.Cut(line, "=")
+	if !ok || name != "address" && name != "local" || !strings.HasPrefix(value, "/") {
+		return false
+	}
+	i := strings.LastIndexByte(value, '/')
+	if i == 0 {
+		return false
+	}
+	domains, address := strings.Split(value[1:i], "/"), value[i+1:]
+	if address == "#" {
+		address = ""
+	}
+	for _, domain := range domains {
+		if domain != "#" && !isDomain(domain) {
+			return false
+		}
+	}
+
+	for _, domain := range domains {
+		if domain == "#" {
+			emit("*", address, Block)
+			continue
+		}
+		emitDomain(domain, address, Block, emit)
+	}
+	return true
+}
+
+// emitDomain emits the rules of the domain and its subdomains.
+func emitDomain(domain, address string, action Action, emit func(pattern, address string, action Action)) {
+	emit(domain, address, action)
+	emit("*."+domain, address, action)
+}
+
+// isDomain reports whether s looks like a domain, the tree validating it.
+func isDomain(s string) bool {
+	if s == "" || s[0] == '.' || s[len(s)-1] == '.' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c >= 0x80) {
+			return false
+		}
+	}
+	return true
+}
+
+// Tree is the part of the API of DomainTree, LockedDomainTree and
+// AtomicDomainTree used to import the lists.
+type Tree[V any] interface {
+	AddWithOptions(key string, value V, opts ...domaintree.AddOption) error
+	PutWithOptions(key string, value V, opts ...domaintree.AddOption) (V, bool, error)
+}
+
+// Summary counts what Import did with the lines of a list.
+type Summary struct {
+	// Entries is the number of entries added to the tree.
+	Entries int
+	// Duplicates is the number of rules whose pattern was already in the
+	// tree, which are dropped unless they allow it.
+	Duplicates int
+	// Invalid is the number of rules whose pattern the tree rejected.
+	Invalid int
+	// Ignored is the number of lines which are not rules, see Parse.
+	Ignored int
+}
+
+// Import adds the rules of the list read from r to the tree, value returning
+// the value of the entry of a rule. The Allow rules are added with
+// AllowPriority and replace a Block rule of the same pattern, while the
+// duplicated Block rules are dropped.
+func Import[V any](t Tree[V], r io.Reader, format Format, value func(rule Rule) V) (Summary, error) {
+	var s Summary
+	ignored, err := Parse(r, format, func(rule Rule) error {
+		var opts []domaintree.AddOption
+		if rule.Action == Allow {
+			opts = append(opts, domaintree.Priority(AllowPriority))
+		}
+
+		v := value(rule)
+		err := t.AddWithOptions(rule.Pattern, v, opts...)
+		if errors.Is(err, domaintree.ErrDuplicateKey) {
+			s.Duplicates++
+			if rule.Action != Allow {
+				return nil
+			}
+			// the exception replaces the entry in a single write, whatever its
+			// action, the entry being kept if it fails
+			if _, _, err := t.PutWithOptions(rule.Pattern, v, opts...); err != nil {
+				s.Invalid++
+			}
+			return nil
+		}
+		if err != nil {
+			s.Invalid++
+			return nil
+		}
+		s.Entries++
+		return nil
+	})
+	s.Ignored = ignored
+	return s, err
+}
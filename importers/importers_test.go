@@ -0,0 +1,186 @@
+package importers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	domaintree "github.com/detailyang/domaintree-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		format  Format
+		data    string
+		expect  []Rule
+		ignored int
+	}{
+		{Hosts, `# hosts
+127.0.0.1 localhost
+::1 localhost ip6-localhost
+0.0.0.0 0.0.0.0
+
+0.0.0.0 ads.example.com tracker.example.com # trackers
+  ::  ads.example.org
+example.net
+`, []Rule{
+			{Pattern: "ads.example.com", Address: "0.0.0.0", Line: 6},
+			{Pattern: "tracker.example.com", Address: "0.0.0.0", Line: 6},
+			{Pattern: "ads.example.org", Address: "::", Line: 7},
+		}, 1},
+		{Adblock, `[Adblock Plus 2.0]
+! Title: blocklist
+||example.com^
+@@||cdn.example.com^|
+||ads.example.org^$third-party
+||example.net/ads/*
+||example.info
+example.com##.banner
+/banner\d+/
+`, []Rule{
+			{Pattern: "example.com", Line: 3},
+			{Pattern: "*.example.com", Line: 3},
+			{Pattern: "cdn.example.com", Action: Allow, Line: 4},
+			{Pattern: "*.cdn.example.com", Action: Allow, Line: 4},
+		}, 5},
+		{Dnsmasq, `# dnsmasq.conf
+address=/example.com/0.0.0.0
+address=/a.example.org/b.example.org/::
+local=/example.net/
+address=/#/#
+server=/example.info/1.1.1.1
+address=/bad domain/0.0.0.0
+`, []Rule{
+			{Pattern: "example.com", Address: "0.0.0.0", Line: 2},
+			{Pattern: "*.example.com", Address: "0.0.0.0", Line: 2},
+			{Pattern: "a.example.org", Address: "::", Line: 3},
+			{Pattern: "*.a.example.org", Address: "::", Line: 3},
+			{Pattern: "b.example.org", Address: "::", Line: 3},
+			{Pattern: "*.b.example.org", Address: "::", Line: 3},
+			{Pattern: "example.net", Line: 4},
+			{Pattern: "*.example.net", Line: 4},
+			{Pattern: "*", Line: 5},
+		}, 2},
+		{Domains, "# domains\nads.example.com\n\n*.example.org # wildcard\nexample.net extra\n", []Rule{
+			{Pattern: "ads.example.com", Line: 2},
+			{Pattern: "*.example.org", Line: 4},
+		}, 1},
+	} {
+		var rules []Rule
+		ignored, err := Parse(strings.NewReader(tt.data), tt.format, func(rule Rule) error {
+			rules = append(rules, rule)
+			return nil
+		})
+		require.NoError(t, err, tt.format)
+		require.Equal(t, tt.expect, rules, tt.format)
+		require.Equal(t, tt.ignored, ignored, tt.format)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	_, err := Parse(strings.NewReader("a.com\nb.com\nc.com\n"), Domains, func(rule Rule) error {
+		n++
+		return stop
+	})
+	require.True(t, errors.Is(err, stop))
+	require.Equal(t, 1, n)
+
+	_, err = Parse(strings.NewReader(strings.Repeat("a", maxLine+1)), Domains, func(rule Rule) error { return nil })
+	require.Error(t, err)
+	_, err = Parse(strings.NewReader(""), Format(10), func(rule Rule) error { return nil })
+	require.Error(t, err)
+}
+
+func TestImport(t *testing.T) {
+	dt := domaintree.NewDomainTreeOf[Action](domaintree.Strict())
+	action := func(rule Rule) Action { return rule.Action }
+
+	s, err := Import[Action](dt, strings.NewReader(`@@||www.example.org^
+||example.com^
+||example.org^
+@@||cdn.example.com^
+||cdn.example.com^
+||example.com^
+||a..com^
+example.com##.banner
+`), Adblock, action)
+	require.NoError(t, err)
+	require.Equal(t, Summary{Entries: 8, Duplicates: 4, Invalid: 2, Ignored: 1}, s)
+
+	// the hosts of a second list
+	s, err = Import[Action](dt, strings.NewReader("0.0.0.0 cdn.example.com tracker.example.net\n"), Hosts, action)
+	require.NoError(t, err)
+	require.Equal(t, Summary{Entries: 1, Duplicates: 1}, s)
+
+	for host, expect := range map[string]Action{
+		"example.com":         Block,
+		"ads.example.com":     Block,
+		"cdn.example.com":     Allow,
+		"img.cdn.example.com": Allow,
+		"example.org":         Block,
+		"www.example.org":     Allow,
+		"tracker.example.net": Block,
+	} {
+		dn, ok := dt.Lookup(host)
+		require.True(t, ok, host)
+		require.Equal(t, expect, dn.GetValue(), host)
+	}
+	_, ok := dt.Lookup("example.net")
+	require.False(t, ok)
+
+	// an exception replaces the rule blocking the same domains
+	adt := domaintree.NewAtomicDomainTreeOf[Rule]()
+	s, err = Import[Rule](adt, strings.NewReader("address=/example.com/0.0.0.0\n"), Dnsmasq, func(rule Rule) Rule { return rule })
+	require.NoError(t, err)
+	require.Equal(t, 2, s.Entries)
+	s, err = Import[Rule](adt, strings.NewReader("@@||example.com^\n"), Adblock, func(rule Rule) Rule { return rule })
+	require.NoError(t, err)
+	require.Equal(t, Summary{Entries: 0, Duplicates: 2}, s)
+	dn, ok := adt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, Rule{Pattern: "*.example.com", Action: Allow, Line: 1}, dn.GetValue())
+	require.Equal(t, AllowPriority, dn.GetPriority())
+}
+
+// failingTree fails the replacements of the entries.
+type failingTree[V any] struct {
+	*domaintree.DomainTreeOf[V]
+}
+
+func (failingTree[V]) PutWithOptions(key string, value V, opts ...domaintree.AddOption) (V, bool, error) {
+	var zero V
+	return zero, false, errors.New("failed")
+}
+
+func TestImportFailedReplace(t *testing.T) {
+	// the entry is kept when the exception can not replace it
+	dt := domaintree.NewDomainTreeOf[Action]()
+	require.NoError(t, dt.Add("example.com", Block))
+	s, err := Import[Action](failingTree[Action]{dt}, strings.NewReader("@@||example.com^\n"), Adblock, func(rule Rule) Action { return rule.Action })
+	require.NoError(t, err)
+	require.Equal(t, Summary{Entries: 1, Duplicates: 1, Invalid: 1}, s)
+	action, ok := dt.Remove("example.com")
+	require.True(t, ok)
+	require.Equal(t, Block, action)
+}
+
+func BenchmarkImport(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 100000; i++ {
+		sb.WriteString("0.0.0.0 ads")
+		sb.WriteString(strconv.Itoa(i))
+		sb.WriteString(".example.com\n")
+	}
+	data := sb.String()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dt := domaintree.NewDomainTreeOf[struct{}]()
+		if _, err := Import[struct{}](dt, strings.NewReader(data), Hosts, func(Rule) struct{} { return struct{}{} }); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
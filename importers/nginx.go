@@ -0,0 +1,565 @@
+package importers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	domaintree "github.com/detailyang/domaintree-go"
+)
+
+// NginxConfig holds the server blocks of an nginx configuration and a tree
+// per listen address, which reproduces how nginx chooses the block serving
+// a host.
+type NginxConfig struct {
+	// Servers holds the server blocks of the http blocks, in the order of the
+	// configuration.
+	Servers []*NginxServer
+	// Trees maps a listen address like *:443, 10.0.0.1:80 or [::]:443 to the
+	// tree of the server names of the blocks listening on it, in NginxMode.
+	Trees map[string]*domaintree.DomainTreeOf[NginxBlock]
+	// Warnings holds the server names ignored by nginx, like a name already
+	// given to another block on the same address.
+	Warnings []*NginxError
+
+	// defaults maps an address to the block serving the hosts without a
+	// server name.
+	defaults map[string]NginxBlock
+}
+
+// NginxServer is a server block.
+type NginxServer struct {
+	File    string
+	Line    int
+	Names   []string
+	Listens []NginxListen
+}
+
+// NginxListen is a listen directive of a server block.
+type NginxListen struct {
+	// Address is the normalized address, e.g. *:80 for listen 80.
+	Address       string
+	DefaultServer bool
+}
+
+// NginxBlock identifies a server block on an address, the value of the trees
+// of NginxConfig.
+type NginxBlock struct {
+	File string
+	Line int
+	// DefaultServer reports whether the block is the default_server of the
+	// address.
+	DefaultServer bool
+}
+
+// NginxError is an error of an nginx configuration at a line of a file.
+type NginxError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *NginxError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+}
+
+func (e *NginxError) Unwrap() error {
+	return e.Err
+}
+
+// The errors of the nginx configurations.
+var (
+	ErrNginxSyntax          = errors.New("syntax error")
+	ErrNginxIncludeCycle    = errors.New("include cycle")
+	ErrNginxListen          = errors.New("invalid listen")
+	ErrNginxDefaultServer   = errors.New("duplicate default server")
+	ErrNginxConflictingName = errors.New("conflicting server name")
+)
+
+// defaultListen is the address of a server block without listen directive.
+const defaultListen = "*:80"
+
+// maxIncludeDepth bounds the nesting of the include directives.
+const maxIncludeDepth = 32
+
+// ParseNginx parses the nginx configuration file, following its include
+// directives, whose relative paths and globs are relative to the directory
+// of the file like the nginx prefix, and builds the trees of its server
+// blocks.
+//
+// The server names are added to the tree of every address of the block. A
+// name given to several blocks on an address is kept by the first one and
+// reported in the warnings, as nginx does.
+func ParseNginx(path string) (*NginxConfig, error) {
+	ld := &nginxLoader{dir: filepath.Dir(path)}
+	directives, err := ld.load(path, "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &NginxConfig{
+		Trees:    make(map[string]*domaintree.DomainTreeOf[NginxBlock]),
+		defaults: make(map[string]NginxBlock),
+	}
+	for _, d := range directives {
+		if d.name != "http" || d.block == nil {
+			continue
+		}
+		for _, sd := range d.block {
+			if sd.name != "server" || sd.block == nil {
+				continue
+			}
+			s, err := nginxServer(sd)
+			if err != nil {
+				return nil, err
+			}
+			if err := c.add(s); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// nginxServer returns the server block of the directive.
+func nginxServer(d *nginxDirective) (*NginxServer, error) {
+	s := &NginxServer{File: d.file, Line: d.line}
+	for _, sd := range d.block {
+		switch sd.name {
+		case "server_name":
+			s.Names = append(s.Names, sd.args...)
+		case "listen":
+			if len(sd.args) == 0 {
+				return nil, sd.error(ErrNginxListen)
+			}
+			address, err := nginxAddress(sd.args[0])
+			if err != nil {
+				return nil, sd.error(fmt.Errorf("%w %q", ErrNginxListen, sd.args[0]))
+			}
+			l := s.listen(address)
+			for _, arg := range sd.args[1:] {
+				if arg == "default_server" || arg == "default" {
+					l.DefaultServer = true
+				}
+			}
+		}
+	}
+	if len(s.Listens) == 0 {
+		s.Listens = []NginxListen{{Address: defaultListen}}
+	}
+	return s, nil
+}
+
+// listen returns the listen of the address, added if new.
+func (s *NginxServer) listen(address string) *NginxListen {
+	for i := range s.Listens {
+		if s.Listens[i].Address == address {
+			return &s.Listens[i]
+		}
+	}
+	s.Listens = append(s.Listens, NginxListen{Address: address})
+	return &s.Listens[len(s.Listens)-1]
+}
+
+// add adds the server block to the trees of its addresses.
+func (c *NginxConfig) add(s *NginxServer) error {
+	c.Servers = append(c.Servers, s)
+	for _, l := range s.Listens {
+		t, ok := c.Trees[l.Address]
+		if !ok {
+			t = domaintree.NewDomainTreeOf[NginxBlock](domaintree.NginxMode(), domaintree.StripPort())
+			c.Trees[l.Address] = t
+		}
+
+		block := NginxBlock{File: s.File, Line: s.Line, DefaultServer: l.DefaultServer}
+		if def, ok := c.defaults[l.Address]; !ok || !def.DefaultServer && block.DefaultServer {
+			c.defaults[l.Address] = block
+		} else if def.DefaultServer && block.DefaultServer {
+			return &NginxError{File: s.File, Line: s.Line, Err: fmt.Errorf("%w for %s", ErrNginxDefaultServer, l.Address)}
+		}
+
+		for _, name := range s.Names {
+			err := t.Add(name, block)
+			if errors.Is(err, domaintree.ErrDuplicateKey) {
+				err = fmt.Errorf("%w %q on %s, ignored", ErrNginxConflictingName, name, l.Address)
+			}
+			if err != nil {
+				c.Warnings = append(c.Warnings, &NginxError{File: s.File, Line: s.Line, Err: err})
+			}
+		}
+	}
+	return nil
+}
+
+// Lookup returns the server block which serves the host on the address, like
+// 443, *:443 or 10.0.0.1:443: the block of the server name matching the host
+// or else the default server of the address. The blocks listening on the
+// wildcard address of the port serve the addresses without blocks of their
+// own.
+func (c *NginxConfig) Lookup(address, host string) (NginxBlock, bool) {
+	address, err := nginxAddress(address)
+	if err != nil {
+		return NginxBlock{}, false
+	}
+	t, ok := c.Trees[address]
+	if !ok {
+		address = nginxWildcardAddress(address)
+		if t, ok = c.Trees[address]; !ok {
+			return NginxBlock{}, false
+		}
+	}
+
+	if dn, ok := t.Lookup(host); ok {
+		return dn.GetValue(), true
+	}
+	return c.defaults[address], true
+}
+
+// nginxAddress normalizes the address of a listen directive into host:port,
+// the port defaulting to 80 and the host to *.
+func nginxAddress(s string) (string, error) {
+	s = strings.ToLower(s)
+	if strings.HasPrefix(s, "unix:") {
+		return s, nil
+	}
+
+	host, port := s, "80"
+	if strings.HasPrefix(s, "[") {
+		i := strings.IndexByte(s, ']')
+		if i < 0 {
+			return "", ErrNginxListen
+		}
+		host = s[:i+1]
+		if rest := s[i+1:]; rest != "" {
+			if rest[0] != ':' {
+				return "", ErrNginxListen
+			}
+			port = rest[1:]
+		}
+	} else if i := strings.LastIndexByte(s, ':'); i >= 0 {
+		host, port = s[:i], s[i+1:]
+	} else if isPort(s) {
+		host, port = "*", s
+	}
+
+	if host == "" || host == "0.0.0.0" {
+		host = "*"
+	}
+	if !isPort(port) {
+		return "", ErrNginxListen
+	}
+	return host + ":" + port, nil
+}
+
+// nginxWildcardAddress returns the wildcard address of the port of the
+// address, [::] for an IPv6 address.
+func nginxWildcardAddress(address string) string {
+	if strings.HasPrefix(address, "unix:") {
+		return address
+	}
+	port := address[strings.LastIndexByte(address, ':')+1:]
+	if strings.HasPrefix(address, "[") {
+		return "[::]:" + port
+	}
+	return "*:" + port
+}
+
+func isPort(s string) bool {
+	if s == "" || len(s) > 5 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// nginxDirective is a directive of a configuration, with its block if any.
+type nginxDirective struct {
+	name  string
+	args  []string
+	file  string
+	line  int
+	block []*nginxDirective
+}
+
+func (d *nginxDirective) error(err error) *NginxError {
+	return &NginxError{File: d.file, Line: d.line, Err: err}
+}
+
+// nginxLoader loads the files of a configuration.
+type nginxLoader struct {
+	// dir is the directory of the relative includes.
+	dir string
+	// files holds the files being loaded.
+	files []string
+}
+
+// load parses the directives of the file, expanding its includes.
+func (ld *nginxLoader) load(path string, from string, line int) ([]*nginxDirective, error) {
+	for _, f := range ld.files {
+		if f == path {
+			return nil, &NginxError{File: from, Line: line, Err: fmt.Errorf("%w: %s", ErrNginxIncludeCycle, path)}
+		}
+	}
+	if len(ld.files) >= maxIncludeDepth {
+		return nil, &NginxError{File: from, Line: line, Err: fmt.Errorf("%w: too deep at %s", ErrNginxIncludeCycle, path)}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if from == "" {
+			return nil, err
+		}
+		return nil, &NginxError{File: from, Line: line, Err: err}
+	}
+
+	ld.files = append(ld.files, path)
+	defer func() { ld.files = ld.files[:len(ld.files)-1] }()
+
+	lx := &nginxLexer{file: path, data: data, line: 1}
+	return ld.parse(lx, false)
+}
+
+// include returns the directives of the files matching the include.
+func (ld *nginxLoader) include(d *nginxDirective) ([]*nginxDirective, error) {
+	if len(d.args) != 1 {
+		return nil, d.error(fmt.Errorf("%w: invalid number of arguments in include", ErrNginxSyntax))
+	}
+	pattern := d.args[0]
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(ld.dir, pattern)
+	}
+
+	paths := []string{pattern}
+	if strings.ContainsAny(pattern, "*?[") {
+		var err error
+		if paths, err = filepath.Glob(pattern); err != nil {
+			return nil, d.error(err)
+		}
+	}
+
+	var directives []*nginxDirective
+	for _, path := range paths {
+		ds, err := ld.load(path, d.file, d.line)
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, ds...)
+	}
+	return directives, nil
+}
+
+// parse parses the directives up to the end of the block or of the file.
+func (ld *nginxLoader) parse(lx *nginxLexer, inBlock bool) ([]*nginxDirective, error) {
+	var directives []*nginxDirective
+	var d *nginxDirective
+	for {
+		tok, err := lx.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case tok.eof:
+			if d != nil || inBlock {
+				return nil, lx.error(tok.line, `unexpected end of file, expecting ";" or "}"`)
+			}
+			return directives, nil
+
+		case tok.special && tok.text == "}":
+			if d != nil || !inBlock {
+				return nil, lx.error(tok.line, `unexpected "}"`)
+			}
+			return directives, nil
+
+		case tok.special && tok.text == ";":
+			if d == nil {
+				return nil, lx.error(tok.line, `unexpected ";"`)
+			}
+			if d.name == "include" {
+				ds, err := ld.include(d)
+				if err != nil {
+					return nil, err
+				}
+				directives = append(directives, ds...)
+			} else {
+				directives = append(directives, d)
+			}
+			d = nil
+
+		case tok.special && tok.text == "{":
+			if d == nil {
+				return nil, lx.error(tok.line, `unexpected "{"`)
+			}
+			block, err := ld.parse(lx, true)
+			if err != nil {
+				return nil, err
+			}
+			d.block = block
+			if d.block == nil {
+				d.block = []*nginxDirective{}
+			}
+			directives = append(directives, d)
+			d = nil
+
+		case d == nil:
+			d = &nginxDirective{name: tok.text, file: lx.file, line: tok.line}
+
+		default:
+			d.args = append(d.args, tok.text)
+		}
+	}
+}
+
+// nginxToken is a word or one of ; { and } or the end of the file.
+type nginxToken struct {
+	text    string
+	line    int
+	special bool
+	eof     bool
+}
+
+// nginxLexer splits a configuration into tokens like ngx_conf_read_token.
+type nginxLexer struct {
+	file string
+	data []byte
+	off  int
+	line int
+}
+
+func (lx *nginxLexer) error(line int, msg string) *NginxError {
+	return &NginxError{File: lx.file, Line: line, Err: fmt.Errorf("%w: %s", ErrNginxSyntax, msg)}
+}
+
+func (lx *nginxLexer) next() (nginxToken, error) {
+	// skip the spaces and the comments
+	for lx.off < len(lx.data) {
+		c := lx.data[lx.off]
+		if c == '#' {
+			for lx.off < len(lx.data) && lx.data[lx.off] != '\n' {
+				lx.off++
+			}
+			continue
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			break
+		}
+		if c == '\n' {
+			lx.line++
+		}
+		lx.off++
+	}
+	if lx.off == len(lx.data) {
+		return nginxToken{line: lx.line, eof: true}, nil
+	}
+
+	line := lx.line
+	switch c := lx.data[lx.off]; c {
+	case ';', '{', '}':
+		lx.off++
+		return nginxToken{text: string(c), line: line, special: true}, nil
+
+	case '"', '\'':
+		start := lx.off + 1
+		for i := start; i < len(lx.data); i++ {
+			switch lx.data[i] {
+			case '\\':
+				i++
+				if i < len(lx.data) && lx.data[i] == '\n' {
+					lx.line++
+				}
+			case '\n':
+				lx.line++
+			case c:
+				lx.off = i + 1
+				if lx.off < len(lx.data) && !isNginxSeparator(lx.data[lx.off]) {
+					return nginxToken{}, lx.error(lx.line, fmt.Sprintf("unexpected %q", lx.data[lx.off]))
+				}
+				return nginxToken{text: nginxUnescape(lx.data[start:i]), line: line}, nil
+			}
+		}
+		return nginxToken{}, lx.error(line, "unexpected end of file, expecting closing quote")
+	}
+
+	start := lx.off
+	variable := false
+	for lx.off < len(lx.data) {
+		c := lx.data[lx.off]
+		if c == '\\' {
+			if lx.off+1 < len(lx.data) && lx.data[lx.off+1] == '\n' {
+				lx.line++
+			}
+			lx.off += 2
+			variable = false
+			continue
+		}
+		if c == '{' && variable {
+			// ${name}
+			for lx.off < len(lx.data) && lx.data[lx.off] != '}' {
+				if lx.data[lx.off] == '\n' {
+					lx.line++
+				}
+				lx.off++
+			}
+			lx.off++
+			variable = false
+			continue
+		}
+		if isNginxSeparator(c) {
+			break
+		}
+		variable = c == '$'
+		lx.off++
+	}
+	if lx.off > len(lx.data) {
+		lx.off = len(lx.data)
+	}
+	return nginxToken{text: nginxUnescape(lx.data[start:lx.off]), line: line}, nil
+}
+
+func isNginxSeparator(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', ';', '{', '}':
+		return true
+	}
+	return false
+}
+
+// nginxUnescape unescapes the quotes, the backslashes, \t, \r and \n, the
+// other escapes like \d being kept for the regular expressions.
+func nginxUnescape(b []byte) string {
+	if !strings.Contains(string(b), `\`) {
+		return string(b)
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(b); i++ {
+		if b[i] == '\\' && i+1 < len(b) {
+			switch b[i+1] {
+			case '"', '\'', '\\':
+				sb.WriteByte(b[i+1])
+				i++
+				continue
+			case 't':
+				sb.WriteByte('\t')
+				i++
+				continue
+			case 'r':
+				sb.WriteByte('\r')
+				i++
+				continue
+			case 'n':
+				sb.WriteByte('\n')
+				i++
+				continue
+			}
+		}
+		sb.WriteByte(b[i])
+	}
+	return sb.String()
+}
@@ -0,0 +1,153 @@
+package importers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+	}
+	return dir
+}
+
+func TestParseNginx(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"nginx.conf": `# main
+user nginx;
+events { worker_connections 1024; }
+
+http {
+    include mime.types;
+    log_format main '$remote_addr "$request"';
+
+    server {
+        listen 80 default_server;
+        server_name _;
+        return 444;
+    }
+
+    include sites/*.conf;
+}
+
+stream {
+    server { listen 5353; }
+}
+`,
+		"mime.types": "types { text/html html; }\n",
+		"sites/a.conf": `server {
+    listen 80;
+    listen [::]:443 ssl;
+    listen 443 ssl http2;
+    server_name example.com *.example.com;
+    server_name "~^(?<app>[a-z]+)\.apps\.example\.org$";
+
+    location / { proxy_pass http://${app}_backend; }
+}
+
+server {
+    listen 10.0.0.1:443 ssl default_server;
+    server_name .example.net;
+}
+`,
+		"sites/b.conf": `server {
+    listen 443 ssl;
+    server_name www.example.com www.example.*;
+}
+
+server {
+    listen 0.0.0.0:443 default;
+    server_name example.com; # conflicts with a.conf
+}
+`,
+	})
+
+	c, err := ParseNginx(filepath.Join(dir, "nginx.conf"))
+	require.NoError(t, err)
+
+	main, a, b := filepath.Join(dir, "nginx.conf"), filepath.Join(dir, "sites/a.conf"), filepath.Join(dir, "sites/b.conf")
+	require.Len(t, c.Servers, 5)
+	require.Equal(t, &NginxServer{
+		File:  a,
+		Line:  1,
+		Names: []string{"example.com", "*.example.com", `~^(?<app>[a-z]+)\.apps\.example\.org$`},
+		Listens: []NginxListen{
+			{Address: "*:80"},
+			{Address: "[::]:443"},
+			{Address: "*:443"},
+		},
+	}, c.Servers[1])
+	require.Equal(t, []NginxListen{{Address: "10.0.0.1:443", DefaultServer: true}}, c.Servers[2].Listens)
+
+	require.Len(t, c.Trees, 4)
+	require.Len(t, c.Warnings, 1)
+	require.Equal(t, b, c.Warnings[0].File)
+	require.Equal(t, 6, c.Warnings[0].Line)
+	require.True(t, errors.Is(c.Warnings[0], ErrNginxConflictingName))
+
+	for _, tt := range []struct {
+		address, host string
+		expect        NginxBlock
+	}{
+		{"80", "example.com", NginxBlock{File: a, Line: 1}},
+		{"*:80", "WWW.Example.com.", NginxBlock{File: a, Line: 1}},
+		{"80", "unknown.org", NginxBlock{File: main, Line: 9, DefaultServer: true}},
+		{"443", "www.example.com:443", NginxBlock{File: b, Line: 1}},
+		{"443", "www.example.de", NginxBlock{File: b, Line: 1}},
+		{"443", "api.example.com", NginxBlock{File: a, Line: 1}},
+		{"443", "shop.apps.example.org", NginxBlock{File: a, Line: 1}},
+		{"443", "example.net", NginxBlock{File: b, Line: 6, DefaultServer: true}},
+		{"10.0.0.2:443", "example.com", NginxBlock{File: a, Line: 1}},
+		{"10.0.0.1:443", "example.com", NginxBlock{File: a, Line: 11, DefaultServer: true}},
+		{"10.0.0.1:443", "www.example.net", NginxBlock{File: a, Line: 11, DefaultServer: true}},
+		{"[::1]:443", "example.net", NginxBlock{File: a, Line: 1}},
+	} {
+		block, ok := c.Lookup(tt.address, tt.host)
+		require.True(t, ok, tt.address+" "+tt.host)
+		require.Equal(t, tt.expect, block, tt.address+" "+tt.host)
+	}
+	_, ok := c.Lookup("8080", "example.com")
+	require.False(t, ok)
+	_, ok = c.Lookup("5353", "example.com")
+	require.False(t, ok)
+}
+
+func TestParseNginxErrors(t *testing.T) {
+	for _, tt := range []struct {
+		files  map[string]string
+		expect string
+		err    error
+	}{
+		{map[string]string{"nginx.conf": "http {\n  server {\n    listen 80;\n"}, `nginx.conf:4: syntax error: unexpected end of file`, ErrNginxSyntax},
+		{map[string]string{"nginx.conf": "http {\n}\n}\n"}, `nginx.conf:3: syntax error: unexpected "}"`, ErrNginxSyntax},
+		{map[string]string{"nginx.conf": "http {\n  server_name 'a.com;\n}\n"}, `nginx.conf:2: syntax error: unexpected end of file, expecting closing quote`, ErrNginxSyntax},
+		{map[string]string{"nginx.conf": "http {\n  server_name a.com\\\n    b.com;\n}\n}\n"}, `nginx.conf:5: syntax error: unexpected "}"`, ErrNginxSyntax},
+		{map[string]string{"nginx.conf": "http {\n  set $a ${b\n};\n}\n}\n"}, `nginx.conf:5: syntax error: unexpected "}"`, ErrNginxSyntax},
+		{map[string]string{"nginx.conf": "http {\n  include a.conf;\n}\n", "a.conf": "include nginx.conf;\n"}, `a.conf:1: include cycle`, ErrNginxIncludeCycle},
+		{map[string]string{"nginx.conf": "http {\n  include missing.conf;\n}\n"}, `nginx.conf:2: open`, os.ErrNotExist},
+		{map[string]string{"nginx.conf": "http {\n  server {\n    listen 80:http;\n  }\n}\n"}, `nginx.conf:3: invalid listen "80:http"`, ErrNginxListen},
+		{map[string]string{"nginx.conf": "http {\n  server { listen 80 default_server; }\n  server { listen *:80 default_server; }\n}\n"}, `nginx.conf:3: duplicate default server for *:80`, ErrNginxDefaultServer},
+	} {
+		dir := writeFiles(t, tt.files)
+		_, err := ParseNginx(filepath.Join(dir, "nginx.conf"))
+		require.Error(t, err)
+		msg := strings.TrimPrefix(err.Error(), dir+string(filepath.Separator))
+		require.True(t, strings.HasPrefix(msg, tt.expect), msg)
+		require.True(t, errors.Is(err, tt.err), msg)
+	}
+
+	// an include glob may match no files
+	dir := writeFiles(t, map[string]string{"nginx.conf": "http {\n  include sites/*.conf;\n}\n"})
+	c, err := ParseNginx(filepath.Join(dir, "nginx.conf"))
+	require.NoError(t, err)
+	require.Len(t, c.Servers, 0)
+}
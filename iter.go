@@ -0,0 +1,32 @@
+//go:build go1.23
+
+package domaintree
+
+import "iter"
+
+// All returns an iterator over the keys and values of the tree in
+// HierarchicalOrder, the keys being reported as by WalkEntries.
+func (dt *DomainTreeOf[V]) All() iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		dt.entries(func(e Entry[V]) bool {
+			return yield(e.Key, e.Value)
+		})
+	}
+}
+
+// All returns an iterator over the keys and values of the tree (thread-safe).
+// The tree is read locked during the iteration, so the loop must not modify
+// it.
+func (dt *LockedDomainTreeOf[V]) All() iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		dt.RLock()
+		defer dt.RUnlock()
+		dt.dt.All()(yield)
+	}
+}
+
+// All returns an iterator over the keys and values of the current snapshot
+// (lock-free).
+func (adt *AtomicDomainTree[V]) All() iter.Seq2[string, V] {
+	return adt.dt.Load().All()
+}
@@ -0,0 +1,36 @@
+//go:build go1.23
+
+package domaintree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAll(t *testing.T) {
+	dt := NewLockedDomainTreeOf[int]()
+	require.NoError(t, dt.Add("www.example.com", 1))
+	require.NoError(t, dt.Add("*.example.com", 2))
+	require.NoError(t, dt.Add("example.*", 3))
+	require.NoError(t, dt.AddRegex(`^www\.`, 4))
+
+	var keys []string
+	for key, value := range dt.All() {
+		keys = append(keys, key)
+		if value == 3 {
+			break
+		}
+	}
+	require.Equal(t, []string{"*.example.com", "www.example.com", "example.*"}, keys)
+
+	// the lock is released after a break
+	require.NoError(t, dt.Add("example.com", 5))
+
+	adt := NewAtomicDomainTreeOf[int]()
+	require.NoError(t, adt.Add("example.com", 1))
+	for key, value := range adt.All() {
+		require.Equal(t, "example.com", key)
+		require.Equal(t, 1, value)
+	}
+}
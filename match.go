@@ -0,0 +1,201 @@
+package domaintree
+
+import "regexp"
+
+// MatchKind represents how an entry matched a hostname.
+type MatchKind uint8
+
+const (
+	// FullMatchKind is an exact match like example.com.
+	FullMatchKind MatchKind = iota
+	// LeadingWildcardMatchKind is a match of a leading wildcard like *.example.com.
+	LeadingWildcardMatchKind
+	// TrailingWildcardMatchKind is a match of a trailing wildcard like example.*.
+	TrailingWildcardMatchKind
+	// GlobMatchKind is a match of the catch-all *.
+	GlobMatchKind
+	// RegexMatchKind is a match of a regular expression.
+	RegexMatchKind
+	// LabelWildcardMatchKind is a match of a pattern within the labels like
+	// api-*.example.com or api.*.example.com.
+	LabelWildcardMatchKind
+)
+
+func (k MatchKind) String() string {
+	switch k {
+	case FullMatchKind:
+		return "full"
+	case LeadingWildcardMatchKind:
+		return "leading-wildcard"
+	case TrailingWildcardMatchKind:
+		return "trailing-wildcard"
+	case GlobMatchKind:
+		return "glob"
+	case RegexMatchKind:
+		return "regex"
+	case LabelWildcardMatchKind:
+		return "label-wildcard"
+	}
+	return "unknown"
+}
+
+// Match holds an entry which matched a hostname and how it matched.
+type Match[V any] struct {
+	Node *DomainNodeOf[V]
+	Kind MatchKind
+	// Submatches holds the text of the submatches of a regex match, the
+	// whole match being Submatches[0]. It is nil for the other kinds.
+	Submatches []string
+	// Wildcard holds the part of the hostname consumed by a wildcard, e.g.
+	// "a.b" for *.example.com matching a.b.example.com, and Literal holds the
+	// part matched literally, e.g. "example.com". Both are substrings of the
+	// (normalized) hostname; a full or label wildcard match has an empty
+	// Wildcard and a glob match an empty Literal. Both are empty for a regex
+	// match.
+	Wildcard string
+	Literal  string
+
+	regex  *regexp.Regexp
+	labels int // the labels required by the regular expression
+}
+
+// SubexpNames returns the names of the submatches of a regex match, see
+// regexp.Regexp.SubexpNames.
+func (m *Match[V]) SubexpNames() []string {
+	if m.regex == nil {
+		return nil
+	}
+	return m.regex.SubexpNames()
+}
+
+// Named returns the text of the named submatch of a regex match.
+func (m *Match[V]) Named(name string) (string, bool) {
+	if m.regex == nil || m.Submatches == nil {
+		return "", false
+	}
+	i := m.regex.SubexpIndex(name)
+	if i < 0 {
+		return "", false
+	}
+	return m.Submatches[i], true
+}
+
+// NamedSubmatches returns the text of every named submatch of a regex match.
+func (m *Match[V]) NamedSubmatches() map[string]string {
+	if m.regex == nil || m.Submatches == nil {
+		return nil
+	}
+	named := make(map[string]string)
+	for i, name := range m.regex.SubexpNames() {
+		if name != "" {
+			named[name] = m.Submatches[i]
+		}
+	}
+	return named
+}
+
+// submatch fills the submatches of a regex match against the key.
+func (m *Match[V]) submatch(key string) {
+	if m.regex != nil {
+		m.Submatches = m.regex.FindStringSubmatch(key)
+	}
+}
+
+// Matches calls fn for every entry which matches the key in the priority order
+// of Lookup, i.e. the first call receives what Lookup returns, until fn
+// returns false.
+func (dt *DomainTreeOf[V]) Matches(key string, fn func(m Match[V]) bool) {
+	key, ok := dt.normalizeHost(key)
+	if !ok {
+		return
+	}
+
+	dt.ordered(key, func(m Match[V]) bool {
+		m.submatch(key)
+		return fn(m)
+	})
+}
+
+// matches is Matches without the normalization of the hostname and without
+// the submatches of the regex matches.
+func (dt *DomainTreeOf[V]) matches(key string, fn func(m Match[V]) bool) {
+	yield := func(dn *DomainNodeOf[V], kind MatchKind, wildcard, literal string) bool {
+		return fn(Match[V]{Node: dn, Kind: kind, Wildcard: wildcard, Literal: literal})
+	}
+
+	if !dt.prefix.lookupAll(key, yield) {
+		return
+	}
+
+	if !dt.suffix.lookupAll(key, yield) {
+		return
+	}
+
+	dt.regex.lookupAll(key, func(rv *regexValue[*DomainNodeOf[V]]) bool {
+		return fn(Match[V]{Node: rv.value, Kind: RegexMatchKind, regex: rv.regex, labels: rv.literal.count()})
+	})
+}
+
+// LookupMatch lookups the key like Lookup and also reports how the entry
+// matched. The submatches are only computed for a regex match.
+func (dt *DomainTreeOf[V]) LookupMatch(key string) (Match[V], bool) {
+	var match Match[V]
+	key, ok := dt.normalizeHost(key)
+	if !ok {
+		return match, false
+	}
+
+	found := false
+	if dt.ranked() {
+		match, found = dt.best(key)
+	} else {
+		dt.matches(key, func(m Match[V]) bool {
+			match, found = m, true
+			return false
+		})
+	}
+	if found {
+		match.submatch(key)
+	}
+
+	return match, found
+}
+
+// LookupAll returns every entry which matches the key in the priority order
+// of Lookup.
+func (dt *DomainTreeOf[V]) LookupAll(key string) []Match[V] {
+	var matches []Match[V]
+	dt.Matches(key, func(m Match[V]) bool {
+		matches = append(matches, m)
+		return true
+	})
+	return matches
+}
+
+// LookupAll returns every entry which matches the key (thread-safe).
+func (dt *LockedDomainTreeOf[V]) LookupAll(key string) []Match[V] {
+	dt.RLock()
+	matches := dt.dt.LookupAll(key)
+	dt.RUnlock()
+	return matches
+}
+
+// LookupAll returns every entry which matches the key in the current snapshot (lock-free).
+func (adt *AtomicDomainTree[V]) LookupAll(key string) []Match[V] {
+	return adt.dt.Load().LookupAll(key)
+}
+
+// LookupMatch lookups the key like Lookup and also reports how the entry
+// matched (thread-safe).
+func (dt *LockedDomainTreeOf[V]) LookupMatch(key string) (Match[V], bool) {
+	dt.RLock()
+	match, ok := dt.dt.LookupMatch(key)
+	dt.RUnlock()
+	return match, ok
+}
+
+// LookupMatch lookups the key like Lookup and also reports how the entry
+// matched in the current snapshot (lock-free).
+func (adt *AtomicDomainTree[V]) LookupMatch(key string) (Match[V], bool) {
+	return adt.dt.Load().LookupMatch(key)
+}
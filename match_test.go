@@ -0,0 +1,145 @@
+package domaintree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupAll(t *testing.T) {
+	dt := NewDomainTreeOf[int]()
+	dt.Add("www.example.com", 1)
+	dt.Add("*.example.com", 2)
+	dt.Add("*.com", 3)
+	dt.Add("www.example.*", 4)
+	dt.Add("*", 5)
+	require.NoError(t, dt.AddRegex(`example\.com$`, 6))
+	require.NoError(t, dt.AddRegex(`^www\.`, 7))
+
+	type match struct {
+		key  string
+		kind MatchKind
+	}
+
+	for _, tt := range []struct {
+		input  string
+		expect []match
+	}{
+		{
+			"www.example.com",
+			[]match{
+				{"www.example.com", FullMatchKind},
+				{"*.example.com", LeadingWildcardMatchKind},
+				{"*.com", LeadingWildcardMatchKind},
+				{"*", GlobMatchKind},
+				{"www.example.*", TrailingWildcardMatchKind},
+				{`example\.com$`, RegexMatchKind},
+				{`^www\.`, RegexMatchKind},
+			},
+		},
+		{
+			"a.b.example.com",
+			[]match{
+				{"*.example.com", LeadingWildcardMatchKind},
+				{"*.com", LeadingWildcardMatchKind},
+				{"*", GlobMatchKind},
+				{`example\.com$`, RegexMatchKind},
+			},
+		},
+		{
+			"www.example.org",
+			[]match{
+				{"*", GlobMatchKind},
+				{"www.example.*", TrailingWildcardMatchKind},
+				{`^www\.`, RegexMatchKind},
+			},
+		},
+	} {
+		matches := dt.LookupAll(tt.input)
+		got := make([]match, 0, len(matches))
+		for _, m := range matches {
+			got = append(got, match{m.Node.GetKey(), m.Kind})
+		}
+		require.Equal(t, tt.expect, got, tt.input)
+
+		dn, ok := dt.Lookup(tt.input)
+		require.True(t, ok)
+		require.Equal(t, dn, matches[0].Node, tt.input)
+	}
+
+	n := 0
+	dt.Matches("www.example.com", func(m Match[int]) bool {
+		n++
+		return m.Kind != LeadingWildcardMatchKind
+	})
+	require.Equal(t, 2, n)
+
+	require.Empty(t, NewDomainTreeOf[int]().LookupAll("www.example.com"))
+}
+
+func TestLookupMatchSubmatches(t *testing.T) {
+	dt := NewDomainTreeOf[int]()
+	dt.Add("www.api.example.com", 1)
+	require.NoError(t, dt.AddRegex(`^(?P<tenant>[a-z0-9]+)\.api\.example\.com$`, 2))
+	require.NoError(t, dt.AddRegex(`^([a-z]+)-([0-9]+)\.example\.com$`, 3))
+
+	m, ok := dt.LookupMatch("www.api.example.com")
+	require.True(t, ok)
+	require.Equal(t, FullMatchKind, m.Kind)
+	require.Nil(t, m.Submatches)
+	require.Nil(t, m.NamedSubmatches())
+	_, ok = m.Named("tenant")
+	require.False(t, ok)
+
+	m, ok = dt.LookupMatch("acme.api.example.com")
+	require.True(t, ok)
+	require.Equal(t, RegexMatchKind, m.Kind)
+	require.Equal(t, 2, m.Node.GetValue())
+	require.Equal(t, []string{"acme.api.example.com", "acme"}, m.Submatches)
+	tenant, ok := m.Named("tenant")
+	require.True(t, ok)
+	require.Equal(t, "acme", tenant)
+	require.Equal(t, map[string]string{"tenant": "acme"}, m.NamedSubmatches())
+
+	m, ok = dt.LookupMatch("pr-123.example.com")
+	require.True(t, ok)
+	require.Equal(t, 3, m.Node.GetValue())
+	require.Equal(t, []string{"pr-123.example.com", "pr", "123"}, m.Submatches)
+	require.Equal(t, []string{"", "", ""}, m.SubexpNames())
+
+	_, ok = dt.LookupMatch("example.com")
+	require.False(t, ok)
+
+	matches := dt.LookupAll("acme.api.example.com")
+	require.Len(t, matches, 1)
+	require.Equal(t, "acme", matches[0].Submatches[1])
+}
+
+func TestLookupMatchNginxCaptures(t *testing.T) {
+	dt := NewDomainTreeOf[int](NginxMode())
+	require.NoError(t, dt.Add(`~^(?<user>.+)\.example\.net$`, 1))
+
+	m, ok := dt.LookupMatch("Alice.Example.NET")
+	require.True(t, ok)
+	user, ok := m.Named("user")
+	require.True(t, ok)
+	require.Equal(t, "alice", user)
+}
+
+func TestLookupMatchWildcard(t *testing.T) {
+	dt := NewDomainTreeOf[int]()
+	dt.Add("*.example.com", 1)
+	dt.Add("www.example.*", 2)
+
+	m, ok := dt.LookupMatch("a.b.example.com")
+	require.True(t, ok)
+	require.Equal(t, LeadingWildcardMatchKind, m.Kind)
+	require.Equal(t, "a.b", m.Wildcard)
+	require.Equal(t, "example.com", m.Literal)
+
+	m, ok = dt.LookupMatch("www.example.co.uk")
+	require.True(t, ok)
+	require.Equal(t, TrailingWildcardMatchKind, m.Kind)
+	require.Equal(t, "co.uk", m.Wildcard)
+	require.Equal(t, "www.example", m.Literal)
+}
@@ -0,0 +1,192 @@
+package domaintree
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var errInvalidServerName = errors.New("invalid server name or wildcard")
+
+// NginxMode makes the tree parse its keys as nginx server_name and reproduce
+// the nginx precedence:
+//
+//  1. the exact name, e.g. www.example.com
+//  2. the longest wildcard name starting with an asterisk, e.g. *.example.com
+//  3. the longest wildcard name ending with an asterisk, e.g. mail.*
+//  4. the first matching regular expression in the order of addition, e.g.
+//     ~^(?<user>.+)\.example\.net$
+//
+// .example.com matches both example.com and its subdomains at the priority of
+// a leading wildcard, while *.example.com does not match example.com. ""
+// matches the empty hostname and _ is an ordinary name which no real hostname
+// matches. Names are case-insensitive and regular expressions are compiled
+// case-insensitively, as nginx does. NginxMode implies CaseInsensitive and
+// IgnoreTrailingDot.
+func NginxMode() Option {
+	return func(o *options) {
+		o.nginx = true
+		o.caseInsensitive = true
+		o.trailingDot = true
+	}
+}
+
+type nginxNameKind uint8
+
+const (
+	nginxExactName nginxNameKind = iota
+	nginxLeadingWildcardName
+	nginxDotWildcardName
+	nginxTrailingWildcardName
+	nginxRegexName
+)
+
+// parseNginxName parses the server_name and returns its kind and the key to
+// be stored in the sub-tree.
+func parseNginxName(name string) (nginxNameKind, string, error) {
+	if name == `""` || name == "" {
+		return nginxExactName, "", nil
+	}
+
+	if name[0] == '~' {
+		if len(name) == 1 {
+			return 0, "", errInvalidServerName
+		}
+		return nginxRegexName, name[1:], nil
+	}
+
+	name = toASCIILower(name)
+
+	switch {
+	case name[0] == '.':
+		name = name[1:]
+		if name == "" || strings.Contains(name, "*") {
+			return 0, "", errInvalidServerName
+		}
+		return nginxDotWildcardName, name, nil
+
+	case strings.HasPrefix(name, "*."):
+		name = name[2:]
+		if name == "" || strings.Contains(name, "*") {
+			return 0, "", errInvalidServerName
+		}
+		return nginxLeadingWildcardName, name, nil
+
+	case strings.HasSuffix(name, ".*"):
+		name = name[:len(name)-2]
+		if name == "" || strings.Contains(name, "*") {
+			return 0, "", errInvalidServerName
+		}
+		return nginxTrailingWildcardName, name, nil
+
+	case strings.Contains(name, "*"):
+		return 0, "", errInvalidServerName
+	}
+
+	return nginxExactName, name, nil
+}
+
+// compileNginxRegex compiles the server_name regular expression without its
+// leading ~.
+func (dt *DomainTreeOf[V]) compileNginxRegex(expr string) (*regexp.Regexp, error) {
+	return dt.compileRegex("(?i)" + pythonNamedGroups(expr))
+}
+
+// pythonNamedGroups rewrites the named groups (?<name>...) and (?'name'...)
+// of PCRE into the (?P<name>...) syntax, the only one regexp accepts before
+// Go 1.22. The lookbehinds (?<= and (?<!, which regexp rejects anyway, the
+// escaped characters and the character classes are left alone.
+func pythonNamedGroups(expr string) string {
+	if !strings.Contains(expr, "(?<") && !strings.Contains(expr, "(?'") {
+		return expr
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '\\' && i+1 < len(expr):
+			b.WriteString(expr[i : i+2])
+			i++
+		case c == '[':
+			// copy the class, a ] right after [ or [^ being a literal
+			j := i + 1
+			if j < len(expr) && expr[j] == '^' {
+				j++
+			}
+			if j < len(expr) && expr[j] == ']' {
+				j++
+			}
+			for j < len(expr) && expr[j] != ']' {
+				if expr[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(expr) {
+				j = len(expr) - 1
+			}
+			b.WriteString(expr[i : j+1])
+			i = j
+		case strings.HasPrefix(expr[i:], "(?<") && !strings.HasPrefix(expr[i:], "(?<=") && !strings.HasPrefix(expr[i:], "(?<!"):
+			b.WriteString("(?P<")
+			i += 2
+		case strings.HasPrefix(expr[i:], "(?'") && strings.IndexByte(expr[i+3:], '\'') >= 0:
+			j := i + 3 + strings.IndexByte(expr[i+3:], '\'')
+			b.WriteString("(?P<")
+			b.WriteString(expr[i+3 : j])
+			b.WriteByte('>')
+			i = j
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// parseNginxName parses the server_name like parseNginxName and converts the
+// name to the ASCII form in IDNA mode.
+func (dt *DomainTreeOf[V]) parseNginxName(key string) (nginxNameKind, string, error) {
+	kind, name, err := parseNginxName(key)
+	if err != nil || kind == nginxRegexName || !dt.opts.idna {
+		return kind, name, err
+	}
+
+	name, err = toIDNA(name)
+	return kind, name, err
+}
+
+// resolveNginx parses the server_name and returns its slot, or the compiled
+// expression of a regular expression.
+func (dt *DomainTreeOf[V]) resolveNginx(key string) (slot[V], *regexp.Regexp, error) {
+	kind, name, err := dt.parseNginxName(key)
+	if err != nil {
+		return slot[V]{}, nil, err
+	}
+
+	if dt.opts.strict {
+		if err := dt.validateNginxName(kind, name); err != nil {
+			return slot[V]{}, nil, &KeyError{Key: key, Err: err}
+		}
+	}
+
+	if kind == nginxRegexName {
+		rex, err := dt.compileNginxRegex(name)
+		return slot[V]{}, rex, err
+	}
+
+	return dt.locateNginx(kind, name), nil, nil
+}
+
+// locateNginx returns the slot of the parsed server_name, which must not be a
+// regular expression. .example.com and *.example.com share their slot.
+func (dt *DomainTreeOf[V]) locateNginx(kind nginxNameKind, name string) slot[V] {
+	switch kind {
+	case nginxLeadingWildcardName:
+		return slot[V]{dt.prefix.wh, name, WildcardHashValueType}
+	case nginxDotWildcardName:
+		return slot[V]{dt.prefix.wh, name, WildcardHashValueType | ApexHashValueType}
+	case nginxTrailingWildcardName:
+		return slot[V]{dt.suffix.wh, name, WildcardHashValueType}
+	}
+	return slot[V]{dt.prefix.wh, name, FullHashValueType}
+}
@@ -0,0 +1,167 @@
+package domaintree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The cases are derived from http://nginx.org/en/docs/http/server_names.html.
+func TestNginxModeConformance(t *testing.T) {
+	dt := NewDomainTreeOf[string](NginxMode())
+	for _, name := range []string{
+		"example.org",
+		"www.example.org",
+		"*.example.org",
+		"mail.*",
+		"www.example.*",
+		".example.com",
+		"*.www.example.com",
+		`~^(?<user>.+)\.example\.net$`,
+		`~^www\..+\.example\.net$`,
+		`~^(www\.)?example\.io$`,
+		`""`,
+		"_",
+		"Upper.Example.NET",
+	} {
+		require.NoError(t, dt.Add(name, name), name)
+	}
+
+	for _, tt := range []struct {
+		input  string
+		expect string
+	}{
+		// exact names
+		{"example.org", "example.org"},
+		{"www.example.org", "www.example.org"},
+		// *.example.org does not match example.org itself
+		{"a.example.org", "*.example.org"},
+		{"a.b.example.org", "*.example.org"},
+		// the longest leading wildcard wins
+		{"a.www.example.com", "*.www.example.com"},
+		// .example.com matches both the apex and the subdomains
+		{"example.com", ".example.com"},
+		{"www.example.com", ".example.com"},
+		{"a.b.example.com", ".example.com"},
+		// leading wildcards win over trailing wildcards
+		{"mail.example.org", "*.example.org"},
+		{"mail.example.io", "mail.*"},
+		{"www.example.io", "www.example.*"},
+		{"mail.a.b", "mail.*"},
+		// trailing wildcards win over regular expressions
+		{"www.example.net", "www.example.*"},
+		// the first matching regular expression in order of appearance
+		{"www.a.example.net", `~^(?<user>.+)\.example\.net$`},
+		{"example.io", `~^(www\.)?example\.io$`},
+		// the empty name and the catch-all name
+		{"", `""`},
+		{"_", "_"},
+		// names are case-insensitive and the trailing dot is ignored
+		{"WWW.Example.ORG", "www.example.org"},
+		{"www.example.org.", "www.example.org"},
+		{"upper.example.net", "Upper.Example.NET"},
+		{"A.EXAMPLE.NET", `~^(?<user>.+)\.example\.net$`},
+	} {
+		dn, ok := dt.Lookup(tt.input)
+		require.True(t, ok, tt.input)
+		require.Equal(t, tt.expect, dn.GetKey(), tt.input)
+	}
+
+	for _, input := range []string{
+		"mail",
+		"org",
+		"example.net",
+		"www.example",
+	} {
+		_, ok := dt.Lookup(input)
+		require.False(t, ok, input)
+	}
+}
+
+func TestNginxModeInvalidNames(t *testing.T) {
+	dt := NewDomainTreeOf[int](NginxMode())
+	for _, name := range []string{
+		"www.*.example.org",
+		"w*.example.org",
+		"*.example.*",
+		"*",
+		".",
+		"~",
+		"~[0-9",
+	} {
+		require.Error(t, dt.Add(name, 1), name)
+	}
+
+	require.NoError(t, dt.Add(`~^www\d+\.example\.net$`, 1))
+	require.Error(t, dt.Add(`~^www\d+\.example\.net$`, 2))
+}
+
+func TestNginxModeDel(t *testing.T) {
+	dt := NewDomainTreeOf[int](NginxMode())
+	require.NoError(t, dt.Add("www.example.com", 1))
+	require.NoError(t, dt.Add(".example.com", 2))
+	require.NoError(t, dt.Add("www.example.*", 3))
+	require.NoError(t, dt.Add(`~^www\.`, 4))
+
+	for _, tt := range []struct {
+		del    string
+		expect int
+	}{
+		{"www.example.com", 2},
+		{".example.com", 3},
+		{"www.example.*", 4},
+		{`~^www\.`, 0},
+	} {
+		require.True(t, dt.Del(tt.del), tt.del)
+		dn, ok := dt.Lookup("www.example.com")
+		require.Equal(t, tt.expect != 0, ok, tt.del)
+		if ok {
+			require.Equal(t, tt.expect, dn.GetValue(), tt.del)
+		}
+	}
+}
+
+func TestNginxModeAtomicEmptyName(t *testing.T) {
+	adt := NewAtomicDomainTreeOf[int](NginxMode())
+	require.NoError(t, adt.Add(`""`, 1))
+	snapshot := adt.dt.Load()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			adt.Put(`""`, i)
+			adt.Remove(`""`)
+			adt.Add(`""`, i)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		dn, ok := snapshot.Lookup("")
+		require.True(t, ok)
+		require.Equal(t, 1, dn.GetValue())
+		adt.Lookup("")
+	}
+	<-done
+
+	dn, ok := snapshot.Lookup("")
+	require.True(t, ok)
+	require.Equal(t, 1, dn.GetValue())
+}
+
+func TestPythonNamedGroups(t *testing.T) {
+	for _, tt := range []struct {
+		input  string
+		expect string
+	}{
+		{`^www\.example\.com$`, `^www\.example\.com$`},
+		{`^(?<user>.+)\.example\.net$`, `^(?P<user>.+)\.example\.net$`},
+		{`^(?'user'.+)\.(?<domain>[^.]+)\.net$`, `^(?P<user>.+)\.(?P<domain>[^.]+)\.net$`},
+		{`^(?P<user>.+)\.example\.net$`, `^(?P<user>.+)\.example\.net$`},
+		{`^(?<=a)(?<!b)(?<c>d)$`, `^(?<=a)(?<!b)(?P<c>d)$`},
+		{`^\(?<a>[(?<]]$`, `^\(?<a>[(?<]]$`},
+		{`^[](?<]+(?<a>b)$`, `^[](?<]+(?P<a>b)$`},
+		{`^[\](?<]`, `^[\](?<]`},
+	} {
+		require.Equal(t, tt.expect, pythonNamedGroups(tt.input), tt.input)
+	}
+}
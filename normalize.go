@@ -0,0 +1,106 @@
+package domaintree
+
+import "strings"
+
+// CaseInsensitive makes the tree fold the ASCII letters of the keys on Add
+// and of the hostnames on Lookup, so WWW.Example.COM matches www.example.com.
+// Regular expressions are left untouched.
+//
+// A hostname which is already lowercase is looked up without allocation.
+func CaseInsensitive() Option {
+	return func(o *options) {
+		o.caseInsensitive = true
+	}
+}
+
+// IgnoreTrailingDot makes the tree strip the trailing dot of the keys on Add
+// and of the hostnames on Lookup, so the FQDN www.example.com. matches
+// www.example.com.
+func IgnoreTrailingDot() Option {
+	return func(o *options) {
+		o.trailingDot = true
+	}
+}
+
+// StripPort makes the tree strip the port of host:port and [ipv6]:port
+// hostnames on Lookup, so a Host header can be looked up as it is.
+func StripPort() Option {
+	return func(o *options) {
+		o.stripPort = true
+	}
+}
+
+// normalizeKey normalizes a key on Add or Del.
+func (dt *DomainTreeOf[V]) normalizeKey(key string) string {
+	return dt.opts.normalizeKey(key)
+}
+
+func (o *options) normalizeKey(key string) string {
+	if o.trailingDot {
+		key = strings.TrimSuffix(key, ".")
+	}
+	if o.caseInsensitive {
+		key = toASCIILower(key)
+	}
+	return key
+}
+
+// normalizeHost normalizes a hostname on Lookup. ok is false if the hostname
+// can not match any key, e.g. an invalid IDN in IDNA mode.
+func (dt *DomainTreeOf[V]) normalizeHost(host string) (string, bool) {
+	return dt.opts.normalizeHost(host)
+}
+
+func (o *options) normalizeHost(host string) (string, bool) {
+	if o.stripPort {
+		host = stripPort(host)
+	}
+	host = o.normalizeKey(host)
+	if o.idna {
+		return toIDNAHost(host)
+	}
+	return host, true
+}
+
+// toASCIILower returns s with the ASCII letters mapped to lower case. s itself
+// is returned if it has no upper case ASCII letter.
+func toASCIILower(s string) string {
+	i := 0
+	for i < len(s) && !('A' <= s[i] && s[i] <= 'Z') {
+		i++
+	}
+	if i == len(s) {
+		return s
+	}
+
+	b := []byte(s)
+	for ; i < len(b); i++ {
+		if 'A' <= b[i] && b[i] <= 'Z' {
+			b[i] += 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// stripPort strips the port of host:port and [ipv6]:port. A bare IPv6
+// address is returned as is.
+func stripPort(host string) string {
+	i := strings.LastIndexByte(host, ':')
+	if i < 0 {
+		return host
+	}
+
+	if host[0] == '[' {
+		j := strings.IndexByte(host, ']')
+		if j < 0 || j > i {
+			return host
+		}
+		return host[:j+1]
+	}
+
+	if strings.IndexByte(host[:i], ':') >= 0 { // bare IPv6
+		return host
+	}
+
+	return host[:i]
+}
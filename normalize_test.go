@@ -0,0 +1,88 @@
+package domaintree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	dt := NewDomainTreeOf[string](CaseInsensitive(), IgnoreTrailingDot(), StripPort())
+	require.NoError(t, dt.Add("WWW.Example.COM", "WWW.Example.COM"))
+	require.NoError(t, dt.Add("*.example.org.", "*.example.org."))
+	require.NoError(t, dt.Add("Example.*", "Example.*"))
+
+	for _, tt := range []struct {
+		input  string
+		expect string
+	}{
+		{"www.example.com", "WWW.Example.COM"},
+		{"WWW.EXAMPLE.COM", "WWW.Example.COM"},
+		{"www.example.com.", "WWW.Example.COM"},
+		{"www.example.com:8080", "WWW.Example.COM"},
+		{"Www.Example.Com.:443", "WWW.Example.COM"},
+		{"a.example.org", "*.example.org."},
+		{"A.Example.Org.", "*.example.org."},
+		{"example.net", "Example.*"},
+	} {
+		dn, ok := dt.Lookup(tt.input)
+		require.True(t, ok, tt.input)
+		require.Equal(t, tt.expect, dn.GetKey(), tt.input)
+	}
+
+	require.True(t, dt.Del("www.EXAMPLE.com."))
+	_, ok := dt.Lookup("www.example.com")
+	require.False(t, ok)
+
+	// without the options the keys are compared as they are
+	dt = NewDomainTreeOf[string]()
+	require.NoError(t, dt.Add("www.example.com", "www.example.com"))
+	for _, input := range []string{"WWW.example.com", "www.example.com.", "www.example.com:80"} {
+		_, ok := dt.Lookup(input)
+		require.False(t, ok, input)
+	}
+}
+
+func TestNormalizeZeroAlloc(t *testing.T) {
+	dt := NewDomainTreeOf[int](CaseInsensitive(), IgnoreTrailingDot(), StripPort())
+	require.NoError(t, dt.Add("www.example.com", 1))
+	require.NoError(t, dt.Add("*.example.com", 2))
+
+	for _, input := range []string{
+		"www.example.com",
+		"www.example.com.",
+		"www.example.com:8080",
+		"a.b.example.com.:443",
+	} {
+		allocs := testing.AllocsPerRun(100, func() {
+			if _, ok := dt.Lookup(input); !ok {
+				t.Fatal("failed to lookup")
+			}
+		})
+		require.Zero(t, allocs, input)
+	}
+}
+
+func TestStripPort(t *testing.T) {
+	for _, tt := range []struct {
+		input  string
+		expect string
+	}{
+		{"example.com", "example.com"},
+		{"example.com:8080", "example.com"},
+		{"example.com:", "example.com"},
+		{"[::1]:8080", "[::1]"},
+		{"[::1]", "[::1]"},
+		{"::1", "::1"},
+		{"127.0.0.1:80", "127.0.0.1"},
+	} {
+		require.Equal(t, tt.expect, stripPort(tt.input), tt.input)
+	}
+}
+
+func TestToASCIILower(t *testing.T) {
+	require.Equal(t, "www.example.com", toASCIILower("WWW.Example.COM"))
+	require.Equal(t, "bÜcher.example", toASCIILower("BÜcher.Example"))
+	s := "www.example.com"
+	require.Equal(t, s, toASCIILower(s))
+}
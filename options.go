@@ -0,0 +1,39 @@
+package domaintree
+
+// Option configures a DomainTree.
+type Option func(*options)
+
+type options struct {
+	nginx           bool
+	singleLabel     bool
+	caseInsensitive bool
+	trailingDot     bool
+	stripPort       bool
+	idna            bool
+	strict          bool
+	precedence      precedence
+	mostSpecific    bool
+	ranked          bool // Precedence or MostSpecific
+	codec           any  // a ValueCodec of the type of the values
+}
+
+func newOptions(opts []Option) options {
+	o := options{precedence: newPrecedence(nil)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// SingleLabelWildcard makes *.example.com and example.* match exactly one
+// label, as the wildcard certificates of RFC 6125 do: *.example.com matches
+// a.example.com but neither a.b.example.com nor example.com. **.example.com
+// and example.** match any number of labels, but not example.com itself.
+//
+// Without the option both * and ** match any number of labels and the apex.
+// The option has no effect in NginxMode.
+func SingleLabelWildcard() Option {
+	return func(o *options) {
+		o.singleLabel = true
+	}
+}
@@ -0,0 +1,146 @@
+package domaintree
+
+import (
+	"sort"
+	"strings"
+)
+
+// defaultPrecedence is the order of the kinds of matches in Lookup.
+var defaultPrecedence = [...]MatchKind{
+	FullMatchKind,
+	LabelWildcardMatchKind,
+	LeadingWildcardMatchKind,
+	GlobMatchKind,
+	TrailingWildcardMatchKind,
+	RegexMatchKind,
+}
+
+// precedence ranks the kinds of matches, the lowest rank winning.
+type precedence [len(defaultPrecedence)]uint8
+
+// Precedence makes Lookup prefer the matches by their kind in the order of
+// kinds, e.g. Precedence(RegexMatchKind, FullMatchKind) lets a regular
+// expression override every other entry but the full matches, and
+// Precedence(TrailingWildcardMatchKind) lets www.example.* beat
+// *.example.com. The kinds which are not listed follow in the default order:
+// full, label wildcard, leading wildcard, glob, trailing wildcard and regex.
+//
+// The matches of the same kind keep the order of Lookup, e.g. the deepest
+// wildcard first. Matches and LookupAll report the matches in the same order.
+func Precedence(kinds ...MatchKind) Option {
+	return func(o *options) {
+		o.precedence = newPrecedence(kinds)
+		o.ranked = true
+	}
+}
+
+func newPrecedence(kinds []MatchKind) precedence {
+	var p precedence
+	seen := make(map[MatchKind]bool)
+	rank := 0
+	for _, kind := range append(append([]MatchKind(nil), kinds...), defaultPrecedence[:]...) {
+		if int(kind) < len(p) && !seen[kind] {
+			seen[kind] = true
+			p[kind] = uint8(rank)
+			rank++
+		}
+	}
+	return p
+}
+
+// MostSpecific makes Lookup prefer the match with the most labels matched
+// literally, whatever its kind: a full match of a.b.example.com scores 4,
+// *.b.example.com 3, api-*.example.com 2 as the pattern label is not
+// literal, the glob * 0, and a regular expression the labels it requires
+// at the beginning or at the end of the hostname, e.g. 3 for
+// ^(.+)\.eu\.example\.com$. The ties are broken by Precedence.
+func MostSpecific() Option {
+	return func(o *options) {
+		o.mostSpecific = true
+		o.ranked = true
+	}
+}
+
+// rank returns the rank of the match, the lowest rank winning.
+func (dt *DomainTreeOf[V]) rank(m *Match[V]) int {
+	labels := 0
+	if dt.opts.mostSpecific {
+		labels = specificity(m.Kind, m.Node.key, m.Literal, m.labels)
+	}
+	return dt.opts.rank(m.Kind, labels)
+}
+
+// rank returns the rank of a match of the kind which matched the number of
+// labels literally.
+func (o *options) rank(kind MatchKind, labels int) int {
+	rank := int(o.precedence[kind])
+	if o.mostSpecific {
+		rank -= labels * len(defaultPrecedence)
+	}
+	return rank
+}
+
+// specificity returns the number of labels of the hostname matched
+// literally by the entry of the key, given the part of the hostname matched
+// literally and the number of labels required by a regular expression.
+func specificity(kind MatchKind, key, literal string, regexLabels int) int {
+	switch kind {
+	case GlobMatchKind:
+		return 0
+	case RegexMatchKind:
+		return regexLabels
+	case LabelWildcardMatchKind:
+		n := 0
+		for _, label := range strings.Split(key, ".") {
+			if label != "" && !isLabelGlob(label) {
+				n++
+			}
+		}
+		return n
+	}
+	return countLabels(literal)
+}
+
+func countLabels(s string) int {
+	if s == "" {
+		return 0
+	}
+	return strings.Count(s, ".") + 1
+}
+
+// best returns the winning match, the first one for a tie.
+func (dt *DomainTreeOf[V]) best(key string) (Match[V], bool) {
+	var match Match[V]
+	found := false
+	dt.matches(key, func(m Match[V]) bool {
+		if !found || dt.less(&m, &match) {
+			match, found = m, true
+		}
+		return true
+	})
+	return match, found
+}
+
+// ordered calls fn for every match in the order of Lookup until fn returns
+// false.
+func (dt *DomainTreeOf[V]) ordered(key string, fn func(m Match[V]) bool) {
+	if !dt.ranked() {
+		dt.matches(key, fn)
+		return
+	}
+
+	var matches []Match[V]
+	dt.matches(key, func(m Match[V]) bool {
+		matches = append(matches, m)
+		return true
+	})
+	sort.SliceStable(matches, func(i, j int) bool {
+		return dt.less(&matches[i], &matches[j])
+	})
+
+	for _, m := range matches {
+		if !fn(m) {
+			return
+		}
+	}
+}
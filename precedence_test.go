@@ -0,0 +1,108 @@
+package domaintree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrecedence(t *testing.T) {
+	build := func(opts ...Option) *DomainTreeOf[string] {
+		dt := NewDomainTreeOf[string](opts...)
+		require.NoError(t, dt.Add("www.example.com", "full"))
+		require.NoError(t, dt.Add("*.example.com", "leading"))
+		require.NoError(t, dt.Add("*.eu.example.com", "leading-eu"))
+		require.NoError(t, dt.Add("www.example.*", "trailing"))
+		require.NoError(t, dt.AddRegex(`^eu-.*`, "regex"))
+		return dt
+	}
+
+	for _, tt := range []struct {
+		opts   []Option
+		key    string
+		expect string
+	}{
+		{nil, "www.example.com", "full"},
+		{nil, "www.example.org", "trailing"},
+		{nil, "eu-1.example.com", "leading"},
+		{nil, "a.eu.example.com", "leading-eu"},
+		{[]Option{Precedence()}, "eu-1.example.com", "leading"},
+		{[]Option{Precedence(RegexMatchKind)}, "eu-1.example.com", "regex"},
+		{[]Option{Precedence(RegexMatchKind)}, "www.example.com", "full"},
+		{[]Option{Precedence(RegexMatchKind)}, "eu-1.eu.example.com", "regex"},
+		{[]Option{Precedence(TrailingWildcardMatchKind)}, "www.example.com", "trailing"},
+		{[]Option{Precedence(TrailingWildcardMatchKind, RegexMatchKind, RegexMatchKind)}, "eu-1.example.com", "regex"},
+		// the deepest wildcard still wins within its kind
+		{[]Option{Precedence(LeadingWildcardMatchKind)}, "eu-1.eu.example.com", "leading-eu"},
+	} {
+		dn, ok := build(tt.opts...).Lookup(tt.key)
+		require.True(t, ok, tt.key)
+		require.Equal(t, tt.expect, dn.GetValue(), tt.key)
+	}
+
+	dt := build(Precedence(RegexMatchKind, TrailingWildcardMatchKind))
+	var got []string
+	for _, m := range dt.LookupAll("www.example.com") {
+		got = append(got, m.Node.GetValue())
+	}
+	require.Equal(t, []string{"trailing", "full", "leading"}, got)
+
+	m, ok := dt.LookupMatch("eu-1.example.com")
+	require.True(t, ok)
+	require.Equal(t, RegexMatchKind, m.Kind)
+	require.Equal(t, []string{"eu-1.example.com"}, m.Submatches)
+}
+
+func TestMostSpecific(t *testing.T) {
+	dt := NewDomainTreeOf[string](MostSpecific())
+	require.NoError(t, dt.Add("*", "glob"))
+	require.NoError(t, dt.Add("*.example.com", "leading"))
+	require.NoError(t, dt.Add("api-*.eu.example.com", "label"))
+	require.NoError(t, dt.Add("www.example.*", "trailing"))
+	require.NoError(t, dt.Add("a.www.example.*", "trailing-a"))
+	require.NoError(t, dt.AddRegex(`^(.+)\.eu\.example\.com$`, "regex"))
+	require.NoError(t, dt.AddRegex(`^x\.y\.eu\.example\.com$`, "regex-full"))
+
+	for _, tt := range []struct {
+		key    string
+		expect string
+	}{
+		{"localhost", "glob"},
+		{"b.example.com", "leading"},
+		// www.example.* and *.example.com both match two labels, a tie broken
+		// by the kind
+		{"www.example.com", "leading"},
+		// a.www.example.* matches three labels
+		{"a.www.example.com", "trailing-a"},
+		// the regular expression matches eu.example.com
+		{"b.eu.example.com", "regex"},
+		// a tie with the label wildcard, broken by the kind
+		{"api-1.eu.example.com", "label"},
+		{"x.y.eu.example.com", "regex-full"},
+	} {
+		dn, ok := dt.Lookup(tt.key)
+		require.True(t, ok, tt.key)
+		require.Equal(t, tt.expect, dn.GetValue(), tt.key)
+	}
+
+	// the ties follow Precedence
+	dt = NewDomainTreeOf[string](MostSpecific(), Precedence(RegexMatchKind))
+	require.NoError(t, dt.Add("api-*.eu.example.com", "label"))
+	require.NoError(t, dt.AddRegex(`^(.+)\.eu\.example\.com$`, "regex"))
+	dn, ok := dt.Lookup("api-1.eu.example.com")
+	require.True(t, ok)
+	require.Equal(t, "regex", dn.GetValue())
+}
+
+func TestPrecedenceNginx(t *testing.T) {
+	dt := NewLockedDomainTreeOf[string](NginxMode(), Precedence(RegexMatchKind))
+	require.NoError(t, dt.Add(".example.com", "dot"))
+	require.NoError(t, dt.Add(`~^www\d+\.example\.com$`, "regex"))
+
+	dn, ok := dt.Lookup("www1.example.com")
+	require.True(t, ok)
+	require.Equal(t, "regex", dn.GetValue())
+	dn, ok = dt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, "dot", dn.GetValue())
+}
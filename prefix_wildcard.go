@@ -2,9 +2,10 @@ package domaintree
 
 import "strings"
 
-type PrefixWildcard struct {
-	glob interface{}
-	wh   *WildcardHash
+type PrefixWildcardOf[V any] struct {
+	glob    V
+	hasGlob bool
+	wh      *WildcardHashOf[V]
 }
 
 func PrefixIndexer(s, substr string) (left, right string, ok bool) {
@@ -15,102 +16,191 @@ func PrefixIndexer(s, substr string) (left, right string, ok bool) {
 	return s, s, false
 }
 
-func NewPrefixWildcard() *PrefixWildcard {
-	return &PrefixWildcard{
-		wh: NewWildcardHash(PrefixIndexer),
+// NewPrefixWildcard returns a new PrefixWildcard which holds interface{} values.
+func NewPrefixWildcard() *PrefixWildcardOf[interface{}] {
+	return NewPrefixWildcardOf[interface{}]()
+}
+
+// NewPrefixWildcardOf returns a new PrefixWildcard which holds values of type V.
+func NewPrefixWildcardOf[V any]() *PrefixWildcardOf[V] {
+	return &PrefixWildcardOf[V]{
+		wh: NewWildcardHashOf[V](PrefixIndexer),
 	}
 }
 
-func (wc *PrefixWildcard) Walk(fn func(key string, value interface{})) {
+func (wc *PrefixWildcardOf[V]) Walk(fn func(key string, value V)) {
 	wc.wh.Walk(fn)
-	if wc.glob != nil {
+	if wc.hasGlob {
 		fn("*", wc.glob)
 	}
 }
 
-func (wc *PrefixWildcard) DelFull(key string) bool {
+func (wc *PrefixWildcardOf[V]) DelFull(key string) bool {
 	return wc.wh.DelFull(key)
 }
 
-func (wc *PrefixWildcard) Lookup(key string) (interface{}, bool) {
+func (wc *PrefixWildcardOf[V]) Lookup(key string) (V, bool) {
 	hv, typ := wc.wh.Lookup(key)
 	if typ > NodeHashValueType {
-		if typ == FullHashValueType {
-			return hv.fullvalue, true
-		}
-		return hv.wildcardvalue, true
+		return hv.valueOf(typ), true
 	}
 
-	if wc.glob != nil {
+	if wc.hasGlob {
 		return wc.glob, true
 	}
 
-	return nil, false
+	var zero V
+	return zero, false
+}
+
+// lookupAll calls fn for every entry which matches the key in the order of
+// Lookup, until fn returns false. wildcard is the part of the key consumed by
+// the wildcard and literal is the part matched literally, both being
+// substrings of the key.
+func (wc *PrefixWildcardOf[V]) lookupAll(key string, fn func(value V, kind MatchKind, wildcard, literal string) bool) bool {
+	ok := wc.wh.lookupAll(key, false, func(m hashMatch[V]) bool {
+		value := m.hv.valueOf(m.typ)
+		switch {
+		case m.typ == FullHashValueType && m.glob:
+			return fn(value, LabelWildcardMatchKind, "", key)
+		case m.typ == FullHashValueType:
+			return fn(value, FullMatchKind, "", key)
+		case !m.consumed:
+			return fn(value, LeadingWildcardMatchKind, "", key)
+		}
+		return fn(value, LeadingWildcardMatchKind, m.rest, key[len(m.rest)+1:])
+	})
+	if !ok {
+		return false
+	}
+
+	if wc.hasGlob {
+		return fn(wc.glob, GlobMatchKind, key, "")
+	}
+
+	return true
+}
+
+// LookupCapture lookups the key like Lookup and also returns the part of the
+// key consumed by the wildcard and the part matched literally, e.g. "a.b" and
+// "example.com" for *.example.com matching a.b.example.com.
+func (wc *PrefixWildcardOf[V]) LookupCapture(key string) (value V, wildcard, literal string, ok bool) {
+	wc.lookupAll(key, func(v V, _ MatchKind, w, l string) bool {
+		value, wildcard, literal, ok = v, w, l, true
+		return false
+	})
+	return
 }
 
-func (wc *PrefixWildcard) Del(key string) bool {
+func (wc *PrefixWildcardOf[V]) Del(key string) bool {
 	if key == "*" {
+		ok := wc.hasGlob
 		wc.DelGlob()
-		return true
+		return ok
 	}
 
-	first := strings.Index(key, "*")
-	if first == -1 {
+	if !strings.HasPrefix(key, "*.") {
 		return wc.DelFull(key)
 	}
 
 	return wc.DelWildcard(key)
 }
 
-func (wc *PrefixWildcard) DelGlob() {
-	wc.glob = nil
+func (wc *PrefixWildcardOf[V]) DelGlob() {
+	var zero V
+	wc.glob = zero
+	wc.hasGlob = false
 }
 
-func (wc *PrefixWildcard) AddGlob(value interface{}) {
+// AddGlob adds the glob "*" which matches everything.
+func (wc *PrefixWildcardOf[V]) AddGlob(value V) error {
+	if wc.hasGlob {
+		return ErrDuplicateKey
+	}
 	wc.glob = value
+	wc.hasGlob = true
+	return nil
 }
 
-// Add adds the key to the trie tree.
-func (wc *PrefixWildcard) Add(key string, value interface{}) {
+// Add adds the key to the trie tree. ErrDuplicateKey is returned if the key
+// is already in the tree.
+func (wc *PrefixWildcardOf[V]) Add(key string, value V) error {
 	if key == "*" {
-		wc.AddGlob(value)
-		return
+		return wc.AddGlob(value)
 	}
 
-	first := strings.Index(key, "*")
-	if first == -1 {
-		wc.AddFull(key, value)
-		return
+	if !strings.HasPrefix(key, "*.") {
+		return wc.AddFull(key, value)
 	}
 
-	wc.AddWildcard(key, value)
+	return wc.AddWildcard(key, value)
 }
 
-// AddFull adds the key to the trie tree.
-func (wc *PrefixWildcard) AddFull(key string, value interface{}) {
-	wc.wh.add(key, value, FullHashValueType)
+// Put adds the key to the trie tree like Add, but replaces the value of the
+// key if it is already in the tree and returns the replaced value.
+func (wc *PrefixWildcardOf[V]) Put(key string, value V) (V, bool) {
+	if key == "*" {
+		prev, ok := wc.glob, wc.hasGlob
+		wc.glob = value
+		wc.hasGlob = true
+		return prev, ok
+	}
+
+	if !strings.HasPrefix(key, "*.") {
+		return wc.wh.put(key, value, FullHashValueType)
+	}
+
+	return wc.wh.put(key[2:], value, WildcardHashValueType|ApexHashValueType)
+}
+
+// AddFull adds the key to the trie tree. A label of the key may be a pattern
+// like "api-*" or "*", which matches any part of exactly one label.
+func (wc *PrefixWildcardOf[V]) AddFull(key string, value V) error {
+	return wc.wh.insert(key, value, FullHashValueType)
 }
 
 // AddWildcard adds the suffix match like "*.abcd.com".
-func (wc *PrefixWildcard) AddWildcard(key string, value interface{}) {
-	n := strings.Index(key, "*.")
-	if n >= 0 {
-		key = key[n+2:]
-		wc.wh.add(key, value, WildcardHashValueType)
-		return
+func (wc *PrefixWildcardOf[V]) AddWildcard(key string, value V) error {
+	if strings.HasPrefix(key, "*.") {
+		key = key[2:]
+		return wc.wh.insert(key, value, WildcardHashValueType|ApexHashValueType)
 	}
-	wc.AddFull(key, value)
+	return wc.AddFull(key, value)
+}
+
+// AddSingleWildcard adds the single-label match like "*.abcd.com" which
+// matches "a.abcd.com" but neither "a.b.abcd.com" nor "abcd.com".
+func (wc *PrefixWildcardOf[V]) AddSingleWildcard(key string, value V) error {
+	key = strings.TrimPrefix(key, "*.")
+	return wc.wh.insert(key, value, SingleWildcardHashValueType)
+}
+
+// DelSingleWildcard deletes the single-label wildcard match.
+func (wc *PrefixWildcardOf[V]) DelSingleWildcard(key string) bool {
+	key = strings.TrimPrefix(key, "*.")
+	return wc.wh.del(key, SingleWildcardHashValueType)
 }
 
 // DelWildcard deletes the wildcard match.
-func (wc *PrefixWildcard) DelWildcard(key string) bool {
-	n := strings.Index(key, "*.")
-	if n >= 0 {
-		key = key[n+2:]
-	}
+func (wc *PrefixWildcardOf[V]) DelWildcard(key string) bool {
+	key = strings.TrimPrefix(key, "*.")
 	return wc.wh.delWildcard(key)
 }
 
-func (wc *PrefixWildcard) String() string {
+func (wc *PrefixWildcardOf[V]) copyPath(key string, owned cowSet) *PrefixWildcardOf[V] {
+	if owned[wc] {
+		wc.wh = wc.wh.copyPath(key, owned)
+		return wc
+	}
+	nwc := &PrefixWildcardOf[V]{
+		glob:    wc.glob,
+		hasGlob: wc.hasGlob,
+		wh:      wc.wh.copyPath(key, owned),
+	}
+	owned.add(nwc)
+	return nwc
+}
+
+func (wc *PrefixWildcardOf[V]) String() string {
 	return wc.wh.String()
 }
@@ -0,0 +1,186 @@
+package domaintree
+
+// AddOption configures an entry added by AddWithOptions.
+type AddOption func(*addOptions)
+
+type addOptions struct {
+	priority int
+}
+
+// Priority sets the priority of the entry. Lookup returns the match of the
+// highest priority, whatever its kind, e.g. *.example.com added with
+// Priority(10) beats www.example.com added with the default priority 0.
+//
+// The matches of the same priority are ordered as without priorities: by
+// MostSpecific if set, then by the kind of the match (see Precedence), then
+// the deepest trie match first for the wildcards and in insertion order for
+// the regular expressions.
+func Priority(n int) AddOption {
+	return func(o *addOptions) {
+		o.priority = n
+	}
+}
+
+func newNode[V any](key string, value V, opts []AddOption) *DomainNodeOf[V] {
+	var o addOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	node := NewDomainNode(key, value)
+	node.priority = o.priority
+	return node
+}
+
+// AddWithOptions adds a domain to the tree like Add with the options of the
+// entry.
+func (dt *DomainTreeOf[V]) AddWithOptions(key string, value V, opts ...AddOption) error {
+	node := newNode(key, value, opts)
+	if err := dt.add(node); err != nil {
+		return err
+	}
+	return nil
+}
+
+// PutWithOptions adds or replaces a domain like Put with the options of the
+// entry, which replace the ones of the replaced entry.
+func (dt *DomainTreeOf[V]) PutWithOptions(key string, value V, opts ...AddOption) (V, bool, error) {
+	node := newNode(key, value, opts)
+	prev, replaced, err := dt.putNode(node, false)
+	if err != nil {
+		return prev, false, err
+	}
+	return prev, replaced, nil
+}
+
+// PutRegexWithOptions adds or replaces a regular expression like PutRegex
+// with the options of the entry, which replace the ones of the replaced entry.
+func (dt *DomainTreeOf[V]) PutRegexWithOptions(key string, value V, opts ...AddOption) (V, bool, error) {
+	return dt.putRegexNode(newNode(key, value, opts), false)
+}
+
+// AddRegexWithOptions adds a regular expression like AddRegex with the
+// options of the entry.
+func (dt *DomainTreeOf[V]) AddRegexWithOptions(key string, value V, opts ...AddOption) error {
+	node := newNode(key, value, opts)
+	if err := dt.addRegex(node); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AddWithOptions adds a domain with the options of the entry (thread-safe).
+func (dt *LockedDomainTreeOf[V]) AddWithOptions(key string, value V, opts ...AddOption) error {
+	dt.Lock()
+	err := dt.dt.AddWithOptions(key, value, opts...)
+	dt.Unlock()
+	return err
+}
+
+// PutWithOptions adds or replaces a domain with the options of the entry and
+// returns the replaced value (thread-safe).
+func (dt *LockedDomainTreeOf[V]) PutWithOptions(key string, value V, opts ...AddOption) (V, bool, error) {
+	dt.Lock()
+	prev, replaced, err := dt.dt.PutWithOptions(key, value, opts...)
+	dt.Unlock()
+	return prev, replaced, err
+}
+
+// PutRegexWithOptions adds or replaces a regular expression with the options
+// of the entry and returns the replaced value (thread-safe).
+func (dt *LockedDomainTreeOf[V]) PutRegexWithOptions(key string, value V, opts ...AddOption) (V, bool, error) {
+	dt.Lock()
+	prev, replaced, err := dt.dt.PutRegexWithOptions(key, value, opts...)
+	dt.Unlock()
+	return prev, replaced, err
+}
+
+// AddRegexWithOptions adds a regular expression with the options of the entry
+// (thread-safe).
+func (dt *LockedDomainTreeOf[V]) AddRegexWithOptions(key string, value V, opts ...AddOption) error {
+	dt.Lock()
+	err := dt.dt.AddRegexWithOptions(key, value, opts...)
+	dt.Unlock()
+	return err
+}
+
+// AddWithOptions adds a domain with the options of the entry and publishes the
+// new snapshot.
+func (adt *AtomicDomainTree[V]) AddWithOptions(key string, value V, opts ...AddOption) error {
+	adt.mu.Lock()
+	defer adt.mu.Unlock()
+
+	dt := adt.dt.Load().copyPath(key)
+	if err := dt.AddWithOptions(key, value, opts...); err != nil {
+		return err
+	}
+	adt.dt.Store(dt)
+	return nil
+}
+
+// PutWithOptions adds or replaces a domain with the options of the entry,
+// publishes the new snapshot and returns the replaced value.
+func (adt *AtomicDomainTree[V]) PutWithOptions(key string, value V, opts ...AddOption) (V, bool, error) {
+	adt.mu.Lock()
+	defer adt.mu.Unlock()
+
+	dt := adt.dt.Load().copyPath(key)
+	prev, replaced, err := dt.PutWithOptions(key, value, opts...)
+	if err != nil {
+		return prev, false, err
+	}
+	adt.dt.Store(dt)
+	return prev, replaced, nil
+}
+
+// PutRegexWithOptions adds or replaces a regular expression with the options
+// of the entry, publishes the new snapshot and returns the replaced value.
+func (adt *AtomicDomainTree[V]) PutRegexWithOptions(key string, value V, opts ...AddOption) (V, bool, error) {
+	adt.mu.Lock()
+	defer adt.mu.Unlock()
+
+	dt := adt.dt.Load().copyRegex()
+	prev, replaced, err := dt.PutRegexWithOptions(key, value, opts...)
+	if err != nil {
+		return prev, false, err
+	}
+	adt.dt.Store(dt)
+	return prev, replaced, nil
+}
+
+// AddRegexWithOptions adds a regular expression with the options of the entry
+// and publishes the new snapshot.
+func (adt *AtomicDomainTree[V]) AddRegexWithOptions(key string, value V, opts ...AddOption) error {
+	adt.mu.Lock()
+	defer adt.mu.Unlock()
+
+	dt := adt.dt.Load().copyRegex()
+	if err := dt.AddRegexWithOptions(key, value, opts...); err != nil {
+		return err
+	}
+	adt.dt.Store(dt)
+	return nil
+}
+
+// ranked reports whether the matches are ordered by rank rather than in the
+// order of the tiers.
+func (dt *DomainTreeOf[V]) ranked() bool {
+	return dt.opts.ranked || dt.prioritized > 0
+}
+
+// count updates the count of the entries of a non-zero priority by n for the
+// node added (1) or removed (-1).
+func (dt *DomainTreeOf[V]) count(node *DomainNodeOf[V], n int) {
+	if node.priority != 0 {
+		dt.prioritized += n
+	}
+}
+
+// less reports whether the match a wins over b: the highest priority first,
+// then the lowest rank.
+func (dt *DomainTreeOf[V]) less(a, b *Match[V]) bool {
+	if a.Node.priority != b.Node.priority {
+		return a.Node.priority > b.Node.priority
+	}
+	return dt.rank(a) < dt.rank(b)
+}
@@ -0,0 +1,199 @@
+package domaintree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriority(t *testing.T) {
+	dt := NewDomainTreeOf[string]()
+	require.NoError(t, dt.AddWithOptions("*.example.com", "leading", Priority(1)))
+	require.NoError(t, dt.Add("*.eu.example.com", "leading-eu"))
+	require.NoError(t, dt.AddRegexWithOptions(`^eu-.*`, "regex", Priority(2)))
+	require.NoError(t, dt.AddRegexWithOptions(`^eu-1\.`, "regex-1", Priority(2)))
+	require.NoError(t, dt.Add("www.example.com", "full"))
+
+	for _, tt := range []struct {
+		key    string
+		expect string
+	}{
+		// the highest priority wins across the tiers
+		{"www.example.com", "leading"},
+		{"a.eu.example.com", "leading"},
+		{"eu-2.eu.example.com", "regex"},
+		// a tie is broken by the insertion order of the regular expressions
+		{"eu-1.example.com", "regex"},
+		{"www.example.org", ""},
+	} {
+		dn, ok := dt.Lookup(tt.key)
+		if tt.expect == "" {
+			require.False(t, ok, tt.key)
+			continue
+		}
+		require.True(t, ok, tt.key)
+		require.Equal(t, tt.expect, dn.GetValue(), tt.key)
+	}
+
+	var got []string
+	for _, m := range dt.LookupAll("eu-1.eu.example.com") {
+		got = append(got, m.Node.GetValue())
+	}
+	require.Equal(t, []string{"regex", "regex-1", "leading", "leading-eu"}, got)
+
+	// Put keeps the priority
+	prev, replaced, err := dt.Put("*.example.com", "leading-2")
+	require.NoError(t, err)
+	require.True(t, replaced)
+	require.Equal(t, "leading", prev)
+	dn, ok := dt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, "leading-2", dn.GetValue())
+	require.Equal(t, 1, dn.GetPriority())
+
+	// while PutWithOptions replaces it
+	prev, replaced, err = dt.PutWithOptions("*.example.com", "leading-3")
+	require.NoError(t, err)
+	require.True(t, replaced)
+	require.Equal(t, "leading-2", prev)
+	dn, ok = dt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, "full", dn.GetValue())
+	_, replaced, err = dt.PutWithOptions("*.example.com", "leading", Priority(1))
+	require.NoError(t, err)
+	require.True(t, replaced)
+
+	_, _, err = dt.PutRegex(`^eu-.*`, "regex-2")
+	require.NoError(t, err)
+	dn, ok = dt.Lookup("eu-2.example.com")
+	require.True(t, ok)
+	require.Equal(t, "regex-2", dn.GetValue())
+	require.Equal(t, 2, dn.GetPriority())
+
+	prev, replaced, err = dt.PutRegexWithOptions(`^eu-.*`, "regex-3")
+	require.NoError(t, err)
+	require.True(t, replaced)
+	require.Equal(t, "regex-2", prev)
+	dn, ok = dt.Lookup("eu-2.example.com")
+	require.True(t, ok)
+	require.Equal(t, "leading", dn.GetValue())
+	_, _, err = dt.PutRegexWithOptions(`^eu-.*`, "regex-2", Priority(2))
+	require.NoError(t, err)
+
+	// a negative priority loses against the default one
+	require.NoError(t, dt.AddWithOptions("*", "glob", Priority(-1)))
+	require.NoError(t, dt.Add("www.example.*", "trailing"))
+	dn, ok = dt.Lookup("www.example.org")
+	require.True(t, ok)
+	require.Equal(t, "trailing", dn.GetValue())
+}
+
+func TestPriorityTies(t *testing.T) {
+	// the ties follow the kinds, then the depth in the trie
+	dt := NewDomainTreeOf[string]()
+	require.NoError(t, dt.AddWithOptions("*.example.com", "leading", Priority(1)))
+	require.NoError(t, dt.AddWithOptions("*.eu.example.com", "leading-eu", Priority(1)))
+	require.NoError(t, dt.AddWithOptions("eu.example.*", "trailing", Priority(1)))
+	require.NoError(t, dt.AddRegexWithOptions(`\.com$`, "regex", Priority(1)))
+
+	dn, ok := dt.Lookup("a.eu.example.com")
+	require.True(t, ok)
+	require.Equal(t, "leading-eu", dn.GetValue())
+
+	dt = NewDomainTreeOf[string](Precedence(RegexMatchKind))
+	require.NoError(t, dt.AddWithOptions("*.example.com", "leading", Priority(1)))
+	require.NoError(t, dt.AddRegexWithOptions(`\.com$`, "regex", Priority(1)))
+	require.NoError(t, dt.AddRegexWithOptions(`^a\.`, "regex-a"))
+	dn, ok = dt.Lookup("a.example.com")
+	require.True(t, ok)
+	require.Equal(t, "regex", dn.GetValue())
+
+	dt = NewDomainTreeOf[string](MostSpecific())
+	require.NoError(t, dt.AddWithOptions("*.example.com", "leading", Priority(1)))
+	require.NoError(t, dt.AddWithOptions("*.eu.example.com", "leading-eu", Priority(1)))
+	require.NoError(t, dt.AddWithOptions("a.b.eu.example.*", "trailing", Priority(1)))
+	dn, ok = dt.Lookup("a.b.eu.example.com")
+	require.True(t, ok)
+	require.Equal(t, "trailing", dn.GetValue())
+}
+
+func TestPriorityCount(t *testing.T) {
+	// the priorities are compared as long as an entry has one
+	dt := NewDomainTreeOf[string]()
+	require.False(t, dt.ranked())
+	require.NoError(t, dt.AddWithOptions("*.example.com", "leading", Priority(1)))
+	require.NoError(t, dt.AddRegexWithOptions(`^www\.`, "regex", Priority(2)))
+	require.NoError(t, dt.Add("www.example.com", "full"))
+	require.Equal(t, 2, dt.prioritized)
+
+	_, _, err := dt.Put("*.example.com", "leading-2")
+	require.NoError(t, err)
+	require.Equal(t, 2, dt.prioritized)
+	_, _, err = dt.PutWithOptions("*.example.com", "leading-3")
+	require.NoError(t, err)
+	require.Equal(t, 1, dt.prioritized)
+	_, _, err = dt.PutWithOptions("www.example.com", "full-2", Priority(3))
+	require.NoError(t, err)
+	require.Equal(t, 2, dt.prioritized)
+
+	data, err := dt.MarshalBinary()
+	require.NoError(t, err)
+	loaded := NewDomainTreeOf[string]()
+	require.NoError(t, loaded.UnmarshalBinary(data))
+	require.Equal(t, 2, loaded.prioritized)
+
+	_, _, err = dt.PutRegexWithOptions(`^www\.`, "regex-2")
+	require.NoError(t, err)
+	require.Equal(t, 1, dt.prioritized)
+	_, _, err = dt.PutRegexWithOptions(`^www\.`, "regex", Priority(2))
+	require.NoError(t, err)
+	require.Equal(t, 2, dt.prioritized)
+
+	require.True(t, dt.DelRegex(`^www\.`))
+	require.True(t, dt.ranked())
+	require.True(t, dt.Del("www.example.com"))
+	require.Equal(t, 0, dt.prioritized)
+	require.False(t, dt.ranked())
+
+	adt := NewAtomicDomainTreeOf[string]()
+	require.NoError(t, adt.AddWithOptions("*.example.com", "leading", Priority(1)))
+	_, ok := adt.Remove("*.example.com")
+	require.True(t, ok)
+	require.False(t, adt.dt.Load().ranked())
+}
+
+func TestPriorityTrees(t *testing.T) {
+	ldt := NewLockedDomainTreeOf[string]()
+	require.NoError(t, ldt.Add("www.example.com", "full"))
+	require.NoError(t, ldt.AddRegexWithOptions(`example`, "regex", Priority(1)))
+	dn, ok := ldt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, "regex", dn.GetValue())
+
+	adt := NewAtomicDomainTreeOf[string]()
+	require.NoError(t, adt.Add("www.example.com", "full"))
+	require.NoError(t, adt.AddWithOptions("*.example.com", "leading", Priority(1)))
+	dn, ok = adt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, "leading", dn.GetValue())
+
+	require.NoError(t, adt.AddRegexWithOptions(`^www\.`, "regex", Priority(2)))
+	dn, ok = adt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, "regex", dn.GetValue())
+
+	prev, replaced, err := adt.PutWithOptions("www.example.com", "full-3", Priority(3))
+	require.NoError(t, err)
+	require.True(t, replaced)
+	require.Equal(t, "full", prev)
+	dn, ok = adt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, "full-3", dn.GetValue())
+
+	_, replaced, err = ldt.PutWithOptions("www.example.com", "full-2", Priority(2))
+	require.NoError(t, err)
+	require.True(t, replaced)
+	dn, ok = ldt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, "full-2", dn.GetValue())
+}
@@ -0,0 +1,246 @@
+package domaintree
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// regexLiteral holds the labels which a regular expression requires at the
+// end or at the beginning of the key, e.g. example.com for
+// ^(.+)\.example\.com$ and www for ^www\.
+type regexLiteral struct {
+	labels string // empty if the expression requires none
+	suffix bool
+}
+
+// count returns the number of labels.
+func (lit regexLiteral) count() int {
+	if lit.labels == "" {
+		return 0
+	}
+	return strings.Count(lit.labels, ".") + 1
+}
+
+// requiredLiteral extracts the labels of the literal which follows ^ or
+// precedes $ at the top of the expression. The label which the literal
+// only holds a part of is dropped, and the labels are lower cased as the keys
+// are on lookup.
+func requiredLiteral(rex *regexp.Regexp) regexLiteral {
+	re, err := syntax.Parse(rex.String(), syntax.Perl)
+	if err != nil || re.Op != syntax.OpConcat || len(re.Sub) < 2 {
+		return regexLiteral{}
+	}
+
+	var suffix, prefix string
+	n := len(re.Sub)
+	if n == 3 && re.Sub[0].Op == syntax.OpBeginText && re.Sub[2].Op == syntax.OpEndText {
+		// ^www\.example\.com$ holds every label
+		if lit, ok := literalString(re.Sub[1]); ok && isIndexableLabels(lit) {
+			return regexLiteral{labels: lit, suffix: true}
+		}
+	}
+	if re.Sub[n-1].Op == syntax.OpEndText {
+		if lit, ok := literalString(re.Sub[n-2]); ok {
+			if i := strings.IndexByte(lit, '.'); i >= 0 {
+				suffix = lit[i+1:]
+			}
+		}
+	}
+	if re.Sub[0].Op == syntax.OpBeginText {
+		if lit, ok := literalString(re.Sub[1]); ok {
+			if i := strings.LastIndexByte(lit, '.'); i >= 0 {
+				prefix = lit[:i]
+			}
+		}
+	}
+
+	if !isIndexableLabels(suffix) {
+		suffix = ""
+	}
+	if !isIndexableLabels(prefix) {
+		prefix = ""
+	}
+
+	if len(suffix) >= len(prefix) {
+		return regexLiteral{labels: suffix, suffix: suffix != ""}
+	}
+	return regexLiteral{labels: prefix}
+}
+
+// literalString returns the lower cased literal. Only the ASCII literals are
+// used, as a non-ASCII rune may fold to an ASCII one like the Kelvin sign.
+func literalString(re *syntax.Regexp) (string, bool) {
+	if re.Op != syntax.OpLiteral {
+		return "", false
+	}
+
+	lit := string(re.Rune)
+	if !isASCII(lit) {
+		return "", false
+	}
+	return toASCIILower(lit), true
+}
+
+// isIndexableLabels reports whether the labels can be stored as they are in
+// a WildcardHash.
+func isIndexableLabels(labels string) bool {
+	if labels == "" || strings.IndexByte(labels, '*') >= 0 {
+		return false
+	}
+	for _, label := range strings.Split(labels, ".") {
+		if label == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// regexIndex finds the first regular expression which matches a key.
+//
+// The expressions which require some labels at the end or at the beginning of
+// the key are indexed by these labels in a WildcardHash and only run when
+// the key holds them, while the other ones are run at once.
+type regexIndex struct {
+	regex []*regexp.Regexp
+
+	unindexed *regexBucket // nil if every expression is indexed
+
+	suffix  *WildcardHashOf[*regexBucket] // by the labels at the end of the key
+	prefix  *WildcardHashOf[*regexBucket] // by the labels at the beginning of the key
+	indexed []int
+
+	// buckets maps the labels to their bucket, the zero regexLiteral to the
+	// unindexed one.
+	buckets map[regexLiteral]*regexBucket
+}
+
+// regexBucket runs a list of expressions at once with a regexSet.
+type regexBucket struct {
+	index []int // the index of each expression in the regexIndex
+	progs []*syntax.Prog
+	set   *regexSet
+}
+
+// first returns the index of the first expression of the bucket which
+// matches the key if it is before best, or best.
+func (b *regexBucket) first(key string, best int) int {
+	if b.index[0] >= best {
+		return best
+	}
+	if i := b.set.match(key); i >= 0 && b.index[i] < best {
+		return b.index[i]
+	}
+	return best
+}
+
+// newRegexIndex indexes the expressions, whose order is their priority. The
+// buckets of prev, the index of a previous version of the list, which hold
+// the same programs are reused with their regexSet and its cached states, so
+// that only the buckets of the added, removed or replaced expressions are
+// built again.
+func newRegexIndex(regex []*regexp.Regexp, progs []*syntax.Prog, literals []regexLiteral, prev *regexIndex) *regexIndex {
+	idx := &regexIndex{
+		regex:   regex,
+		suffix:  NewWildcardHashOf[*regexBucket](PrefixIndexer),
+		prefix:  NewWildcardHashOf[*regexBucket](SuffixIndexer),
+		buckets: make(map[regexLiteral]*regexBucket),
+	}
+
+	for i, lit := range literals {
+		b := idx.buckets[lit]
+		if b == nil {
+			b = &regexBucket{}
+			idx.buckets[lit] = b
+			if lit.labels == "" {
+				idx.unindexed = b
+			} else {
+				wh := idx.prefix
+				if lit.suffix {
+					wh = idx.suffix
+				}
+				// the apex is matched for the expressions holding every label
+				wh.put(lit.labels, b, WildcardHashValueType|ApexHashValueType)
+			}
+		}
+		b.index = append(b.index, i)
+		b.progs = append(b.progs, progs[i])
+		if lit.labels != "" {
+			idx.indexed = append(idx.indexed, i)
+		}
+	}
+
+	for lit, b := range idx.buckets {
+		if pb := prev.bucket(lit); pb != nil && sameProgs(pb.progs, b.progs) {
+			b.set = pb.set
+		} else {
+			b.set = newRegexSet(b.progs)
+		}
+	}
+
+	return idx
+}
+
+// bucket returns the bucket of the labels, nil if there is none or idx is nil.
+func (idx *regexIndex) bucket(lit regexLiteral) *regexBucket {
+	if idx == nil {
+		return nil
+	}
+	return idx.buckets[lit]
+}
+
+func sameProgs(a, b []*syntax.Prog) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// first returns the index of the first expression which matches the key, or
+// -1 if none does.
+func (idx *regexIndex) first(key string) int {
+	best := len(idx.regex)
+	if idx.unindexed != nil {
+		best = idx.unindexed.first(key, best)
+	}
+
+	if len(idx.indexed) == 0 {
+		return idx.result(best)
+	}
+
+	// a non-ASCII key may match a literal by folding, e.g. with the Kelvin
+	// sign, so every indexed expression is tried
+	if !isASCII(key) {
+		for _, i := range idx.indexed {
+			if i >= best {
+				break
+			}
+			if idx.regex[i].MatchString(key) {
+				best = i
+			}
+		}
+		return idx.result(best)
+	}
+
+	lkey := toASCIILower(key)
+	try := func(m hashMatch[*regexBucket]) bool {
+		best = m.hv.wildcardvalue.first(key, best)
+		return true
+	}
+	idx.suffix.lookupAll(lkey, false, try)
+	idx.prefix.lookupAll(lkey, false, try)
+
+	return idx.result(best)
+}
+
+func (idx *regexIndex) result(best int) int {
+	if best == len(idx.regex) {
+		return -1
+	}
+	return best
+}
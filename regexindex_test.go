@@ -0,0 +1,160 @@
+package domaintree
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequiredLiteral(t *testing.T) {
+	for _, tt := range []struct {
+		expr   string
+		expect regexLiteral
+	}{
+		{`^(.+)\.example\.com$`, regexLiteral{labels: "example.com", suffix: true}},
+		{`[0-9]\.abcd\.com$`, regexLiteral{labels: "abcd.com", suffix: true}},
+		{`abcd\.com$`, regexLiteral{labels: "com", suffix: true}},
+		{`(?i)\.Example\.COM$`, regexLiteral{labels: "example.com", suffix: true}},
+		{`^www\.`, regexLiteral{labels: "www"}},
+		{`^api\.v1\.example`, regexLiteral{labels: "api.v1"}},
+		{`^www\.example\.com$`, regexLiteral{labels: "www.example.com", suffix: true}},
+		{`^mail\.example\.[a-z]+$`, regexLiteral{labels: "mail.example"}},
+		{`\.example\.com`, regexLiteral{}},
+		{`^www`, regexLiteral{}},
+		{`com$`, regexLiteral{}},
+		{`(?m)\.example\.com$`, regexLiteral{}},
+		{`\.a$|\.b$`, regexLiteral{}},
+		{`\.a\.\.com$`, regexLiteral{}},
+		{`\.\*\.com$`, regexLiteral{}},
+		{`\.bücher\.example$`, regexLiteral{}},
+	} {
+		require.Equal(t, tt.expect, requiredLiteral(regexp.MustCompile(tt.expr)), tt.expr)
+	}
+}
+
+func TestRegexIndex(t *testing.T) {
+	exprs := []string{
+		`^api\.`,
+		`\.example\.com$`,
+		`(?i)\.EXAMPLE\.org$`,
+		`^[a-z]+[0-9]\.`,
+		`^www\.example\.net$`,
+		`(?i)\.kk\.com$`,
+		`^mail\.example\.[a-z]+$`,
+		`\.com$`,
+		`.`,
+	}
+	inputs := []string{
+		"",
+		"api",
+		"api.example.com",
+		"www.example.com",
+		"example.com",
+		"www.Example.ORG",
+		"www.example.org",
+		"www1.example.org",
+		"www.example.net",
+		"WWW.EXAMPLE.NET",
+		"a.kk.com",
+		"a.Kk.com", // Kelvin sign
+		"mail.example.io",
+		"mail.example.io.cn",
+		"a.b.c.com",
+		"localhost",
+	}
+
+	// every suffix of the list of expressions, so that each one gets to win
+	for n := range exprs {
+		rt := NewRegexTreeOf[int]()
+		var rexes []*regexp.Regexp
+		for i, expr := range exprs[n:] {
+			require.NoError(t, rt.Add(expr, i))
+			rexes = append(rexes, regexp.MustCompile(expr))
+		}
+
+		for _, input := range inputs {
+			expect := firstMatch(rexes, input)
+			rv, ok := rt.Lookup(input)
+			require.Equal(t, expect >= 0, ok, "%q in %q", input, exprs[n:])
+			if ok {
+				require.Equal(t, expect, rv.value, "%q in %q", input, exprs[n:])
+			}
+		}
+	}
+}
+
+func TestRegexIndexZeroAlloc(t *testing.T) {
+	rt := NewRegexTreeOf[int]()
+	require.NoError(t, rt.Add(`^[0-9]+\.abcd\.com$`, 1))
+	require.NoError(t, rt.Add(`^www\.`, 2))
+	require.NoError(t, rt.Add(`[0-9]{3}`, 3))
+
+	for _, input := range []string{"1.abcd.com", "www.example.com", "a123.example.com", "example.com"} {
+		allocs := testing.AllocsPerRun(100, func() {
+			rt.Lookup(input)
+		})
+		require.Equal(t, float64(0), allocs, input)
+	}
+}
+
+func TestRegexIndexIncremental(t *testing.T) {
+	rt := NewRegexTreeOf[int]()
+	for i, expr := range []string{`^api\.`, `\.example\.com$`, `\.example\.org$`, `[0-9]+`, `^www\.example\.com$`} {
+		require.NoError(t, rt.Add(expr, i))
+	}
+	sets := func() map[regexLiteral]*regexSet {
+		rt.Lookup("example.com")
+		m := make(map[regexLiteral]*regexSet)
+		for lit, b := range rt.index.Load().buckets {
+			m[lit] = b.set
+		}
+		return m
+	}
+	lookup := func(key string) int {
+		rv, ok := rt.Lookup(key)
+		if !ok {
+			return -1
+		}
+		return rv.value
+	}
+
+	com := regexLiteral{labels: "example.com", suffix: true}
+	before := sets()
+	require.Len(t, before, 5)
+
+	require.NoError(t, rt.Add(`^(.+)\.example\.com$`, 5))
+	after := sets()
+	for lit, set := range before {
+		if lit == com {
+			require.NotSame(t, set, after[lit])
+		} else {
+			require.Same(t, set, after[lit], lit.labels)
+		}
+	}
+	require.Equal(t, 1, lookup("a.example.com"))
+
+	// the positions of the other buckets shift on removal
+	before = after
+	require.True(t, rt.Del(`^api\.`))
+	after = sets()
+	require.NotContains(t, after, regexLiteral{labels: "api"})
+	require.Same(t, before[com], after[com])
+	require.Equal(t, 1, lookup("a.example.com"))
+	require.Equal(t, 3, lookup("api1.example.net"))
+
+	_, _, err := rt.Put(`\.example\.org$`, 6)
+	require.NoError(t, err)
+	after = sets()
+	require.NotSame(t, before[regexLiteral{labels: "example.org", suffix: true}], after[regexLiteral{labels: "example.org", suffix: true}])
+	require.Same(t, before[com], after[com])
+	require.Equal(t, 6, lookup("a.example.org"))
+
+	// a clone keeps the sets and their cached states
+	c := rt.clone()
+	c.Add(`^mail\.`, 7)
+	rv, ok := c.Lookup("a.example.com")
+	require.True(t, ok)
+	require.Equal(t, 1, rv.value)
+	require.Same(t, after[com], c.index.Load().buckets[com].set)
+}
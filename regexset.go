@@ -0,0 +1,269 @@
+package domaintree
+
+import (
+	"encoding/binary"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// maxDFAStates bounds the number of states cached by a regexSet, which are
+// flushed once there are more.
+const maxDFAStates = 10000
+
+// regexSet matches a key against a list of regular expressions at once and
+// reports the first one which matches in the order of the list.
+//
+// The programs of the expressions are merged into one which is run as a DFA.
+// The states of the DFA are built lazily and cached, so once the states a
+// key goes through are built, a lookup costs one transition per byte of the
+// key however many expressions there are.
+type regexSet struct {
+	inst   []syntax.Inst
+	regex  []int32  // the index of the expression of each instruction
+	starts []uint32 // the first instruction of each expression
+
+	mu        sync.Mutex // guards states and the building of the states
+	states    map[string]*dfaState
+	maxStates int
+	start     atomic.Pointer[dfaState]
+}
+
+// dfaState is a set of instructions waiting for the next rune.
+type dfaState struct {
+	inst []uint32
+	ctx  dfaContext
+	// first is the index of the first expression matched so far, or the
+	// number of expressions if none did. The instructions of the expressions
+	// after it are dropped, as they can not win anymore.
+	first int32
+	next  [utf8.RuneSelf]atomic.Pointer[dfaState]
+	end   atomic.Int32 // the result at the end of the key, -2 until known
+}
+
+// dfaContext is the class of the previous rune, which the empty-width
+// assertions like ^ and \b depend on.
+type dfaContext uint8
+
+const (
+	dfaBegin dfaContext = iota
+	dfaNewline
+	dfaWord
+	dfaOther
+)
+
+// dfaContextRunes holds a rune of each class for syntax.EmptyOpContext.
+var dfaContextRunes = [...]rune{
+	dfaBegin:   -1,
+	dfaNewline: '\n',
+	dfaWord:    'a',
+	dfaOther:   ' ',
+}
+
+func dfaContextOf(r rune) dfaContext {
+	switch {
+	case r == '\n':
+		return dfaNewline
+	case r < utf8.RuneSelf && syntax.IsWordChar(r):
+		return dfaWord
+	}
+	return dfaOther
+}
+
+// compileProg compiles the program of the regular expression, which can not
+// fail as the expression has already been compiled by regexp.Compile.
+func compileProg(rex *regexp.Regexp) *syntax.Prog {
+	re, err := syntax.Parse(rex.String(), syntax.Perl)
+	if err != nil {
+		panic(err)
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		panic(err)
+	}
+	return prog
+}
+
+// newRegexSet merges the programs, whose order is the priority of the
+// expressions.
+func newRegexSet(progs []*syntax.Prog) *regexSet {
+	set := &regexSet{
+		states:    make(map[string]*dfaState),
+		maxStates: maxDFAStates,
+	}
+
+	for i, prog := range progs {
+		off := uint32(len(set.inst))
+		for _, inst := range prog.Inst {
+			inst.Out += off
+			if inst.Op == syntax.InstAlt || inst.Op == syntax.InstAltMatch {
+				inst.Arg += off
+			}
+			set.inst = append(set.inst, inst)
+			set.regex = append(set.regex, int32(i))
+		}
+		set.starts = append(set.starts, uint32(prog.Start)+off)
+	}
+
+	return set
+}
+
+// match returns the index of the first expression which matches the key, or
+// -1 if none does.
+func (set *regexSet) match(key string) int {
+	st := set.start.Load()
+	if st == nil {
+		st = set.initial()
+	}
+
+	for i := 0; i < len(key) && st.first > 0; {
+		c := key[i]
+		if c < utf8.RuneSelf {
+			next := st.next[c].Load()
+			if next == nil {
+				next = set.step(st, rune(c))
+				st.next[c].Store(next)
+			}
+			st = next
+			i++
+			continue
+		}
+
+		r, n := utf8.DecodeRuneInString(key[i:])
+		st = set.step(st, r)
+		i += n
+	}
+
+	first := st.end.Load()
+	if first == -2 {
+		first, _ = set.closure(st, -1)
+		st.end.Store(first)
+	}
+
+	if int(first) == len(set.starts) {
+		return -1
+	}
+	return int(first)
+}
+
+// initial returns the state at the beginning of the key.
+func (set *regexSet) initial() *dfaState {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	st := set.intern(dfaBegin, int32(len(set.starts)), nil)
+	set.start.Store(st)
+	return st
+}
+
+// step returns the state following st on the rune r.
+func (set *regexSet) step(st *dfaState, r rune) *dfaState {
+	first, runes := set.closure(st, r)
+
+	var inst []uint32
+	for _, pc := range runes {
+		if set.regex[pc] < first && set.inst[pc].MatchRune(r) {
+			inst = append(inst, set.inst[pc].Out)
+		}
+	}
+	sort.Slice(inst, func(i, j int) bool { return inst[i] < inst[j] })
+	inst = dedupUint32(inst)
+
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return set.intern(dfaContextOf(r), first, inst)
+}
+
+// closure follows the instructions of st and the first instructions of the
+// expressions before st.first which do not consume a rune, given the next
+// rune r or -1 at the end of the key. It returns the index of the first
+// expression which matched and the instructions waiting for a rune.
+func (set *regexSet) closure(st *dfaState, r rune) (int32, []uint32) {
+	flag := syntax.EmptyOpContext(dfaContextRunes[st.ctx], r)
+	first := st.first
+
+	stack := make([]uint32, 0, len(st.inst)+int(first))
+	stack = append(stack, st.inst...)
+	for i := first - 1; i >= 0; i-- {
+		stack = append(stack, set.starts[i])
+	}
+
+	var runes []uint32
+	visited := make([]bool, len(set.inst))
+	for len(stack) > 0 {
+		pc := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[pc] || set.regex[pc] >= first {
+			continue
+		}
+		visited[pc] = true
+
+		inst := &set.inst[pc]
+		switch inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			stack = append(stack, inst.Arg, inst.Out)
+		case syntax.InstCapture, syntax.InstNop:
+			stack = append(stack, inst.Out)
+		case syntax.InstEmptyWidth:
+			if syntax.EmptyOp(inst.Arg)&^flag == 0 {
+				stack = append(stack, inst.Out)
+			}
+		case syntax.InstMatch:
+			first = set.regex[pc]
+		case syntax.InstRune, syntax.InstRune1, syntax.InstRuneAny, syntax.InstRuneAnyNotNL:
+			runes = append(runes, pc)
+		}
+	}
+
+	return first, runes
+}
+
+// intern returns the cached state or caches a new one. set.mu must be held.
+func (set *regexSet) intern(ctx dfaContext, first int32, inst []uint32) *dfaState {
+	if first == 0 { // nothing can beat the first expression
+		ctx, inst = dfaBegin, nil
+	}
+
+	key := make([]byte, 5+4*len(inst))
+	key[0] = byte(ctx)
+	binary.LittleEndian.PutUint32(key[1:], uint32(first))
+	for i, pc := range inst {
+		binary.LittleEndian.PutUint32(key[5+4*i:], pc)
+	}
+
+	if st, ok := set.states[string(key)]; ok {
+		return st
+	}
+
+	if len(set.states) >= set.maxStates {
+		set.states = make(map[string]*dfaState)
+		set.start.Store(nil)
+	}
+
+	st := &dfaState{
+		inst:  inst,
+		ctx:   ctx,
+		first: first,
+	}
+	st.end.Store(-2)
+	set.states[string(key)] = st
+	return st
+}
+
+// dedupUint32 removes the duplicates of the sorted slice in place.
+func dedupUint32(s []uint32) []uint32 {
+	if len(s) < 2 {
+		return s
+	}
+	n := 1
+	for i := 1; i < len(s); i++ {
+		if s[i] != s[n-1] {
+			s[n] = s[i]
+			n++
+		}
+	}
+	return s[:n]
+}
@@ -0,0 +1,150 @@
+package domaintree
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var regexSetExprs = []string{
+	`^www\.example\.com$`,
+	`[0-9]\.abcd\.com`,
+	`(?i)^MAIL\.`,
+	`\.example\.(net|org)$`,
+	`^(?P<user>[a-z]+)\.users\.example\.com$`,
+	`\bapi\b`,
+	`\Bpi`,
+	`(?m)^b$`,
+	`^$`,
+	`^[^.]+$`,
+	`bücher`,
+	`a{2,3}b`,
+	`x*`,
+}
+
+var regexSetInputs = []string{
+	"",
+	"www.example.com",
+	"www.example.com.cn",
+	"1.abcd.com",
+	"a.abcd.com",
+	"Mail.example.com",
+	"mail",
+	"a.example.net",
+	"a.example.org.cn",
+	"alice.users.example.com",
+	"alice.bob.users.example.com",
+	"api.example.com",
+	"rapid.example.com",
+	"a\nb\nc",
+	"localhost",
+	"www.bücher.example",
+	"aab.example.com",
+	"ab.example.com",
+	"\xff.example.com",
+}
+
+// firstMatch is the reference implementation of regexSet.match.
+func firstMatch(rexes []*regexp.Regexp, key string) int {
+	for i, rex := range rexes {
+		if rex.MatchString(key) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestRegexSet(t *testing.T) {
+	// every suffix of the list of expressions, so that each one gets to win
+	for n := range regexSetExprs {
+		exprs := regexSetExprs[n:]
+		rexes := make([]*regexp.Regexp, len(exprs))
+		progs := make([]*syntax.Prog, len(exprs))
+		for i, expr := range exprs {
+			rexes[i] = regexp.MustCompile(expr)
+			progs[i] = compileProg(rexes[i])
+		}
+
+		set := newRegexSet(progs)
+		for round := 0; round < 2; round++ { // building and cached
+			for _, input := range regexSetInputs {
+				require.Equal(t, firstMatch(rexes, input), set.match(input), "%q in %q", input, exprs)
+			}
+		}
+	}
+}
+
+func TestRegexSetFlush(t *testing.T) {
+	rexes := []*regexp.Regexp{
+		regexp.MustCompile(`a[a-z]{3}z$`),
+		regexp.MustCompile(`^[0-9]+$`),
+	}
+	set := newRegexSet([]*syntax.Prog{compileProg(rexes[0]), compileProg(rexes[1])})
+	set.maxStates = 8
+
+	for _, input := range []string{"abcdz", "xabcdz", "123", "12a", "aaaaaz", "abcz", "zzzz", "abcdz"} {
+		require.Equal(t, firstMatch(rexes, input), set.match(input), input)
+		require.LessOrEqual(t, len(set.states), 8)
+	}
+}
+
+func TestRegexSetConcurrent(t *testing.T) {
+	var rexes []*regexp.Regexp
+	var progs []*syntax.Prog
+	for i := 0; i < 50; i++ {
+		rex := regexp.MustCompile(fmt.Sprintf(`^[a-z]+%d\.example\.com$`, i))
+		rexes = append(rexes, rex)
+		progs = append(progs, compileProg(rex))
+	}
+	set := newRegexSet(progs)
+	set.maxStates = 64
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("www%d.example.com", (g*31+i)%60)
+				if got, want := set.match(key), firstMatch(rexes, key); got != want {
+					t.Errorf("%s: got %d, want %d", key, got, want)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestRegexTreePriority(t *testing.T) {
+	rt := NewRegexTreeOf[int]()
+	require.NoError(t, rt.Add(`\.example\.com$`, 1))
+	require.NoError(t, rt.Add(`^www\.`, 2))
+
+	rv, ok := rt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, 1, rv.value)
+
+	require.True(t, rt.Del(`\.example\.com$`))
+	rv, ok = rt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, 2, rv.value)
+
+	require.NoError(t, rt.Add(`\.example\.com$`, 3))
+	rv, ok = rt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, 2, rv.value)
+
+	var values []int
+	rt.lookupAll("www.example.com", func(rv *regexValue[int]) bool {
+		values = append(values, rv.value)
+		return true
+	})
+	require.Equal(t, []int{2, 3}, values)
+
+	_, ok = rt.Lookup("example.org")
+	require.False(t, ok)
+}
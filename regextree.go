@@ -1,60 +1,189 @@
 package domaintree
 
 import (
-	"errors"
 	"regexp"
+	"regexp/syntax"
+	"sync"
+	"sync/atomic"
 )
 
-type regexValue struct {
-	key   string
-	regex *regexp.Regexp
-	value interface{}
+type regexValue[V any] struct {
+	key     string
+	regex   *regexp.Regexp
+	prog    *syntax.Prog
+	literal regexLiteral
+	value   V
 }
 
-// RegexTree represents a regular expression tree.
-type RegexTree struct {
-	regex []*regexValue
+func newRegexValue[V any](key string, rex *regexp.Regexp, value V) *regexValue[V] {
+	return &regexValue[V]{
+		key:     key,
+		regex:   rex,
+		prog:    compileProg(rex),
+		literal: requiredLiteral(rex),
+		value:   value,
+	}
+}
+
+// RegexTreeOf represents a regular expression tree which holds values of type
+// V.
+//
+// The expressions which require some labels like \.example\.com$ are only
+// run when the key holds the labels, and the other ones are run at once by a
+// regexSet, so a lookup costs about the same however many expressions there
+// are. Add and Del only compile the expression being added, and the
+// regexIndex is updated on the next lookup, building again only the regexSet
+// of the labels of the changed expressions.
+type RegexTreeOf[V any] struct {
+	regex []*regexValue[V]
+
+	mu    sync.Mutex // guards the building of index and prev
+	index atomic.Pointer[regexIndex]
+	// prev is the index before the last changes, whose regexSets are reused
+	// by the next one.
+	prev *regexIndex
 }
 
-// NewRegexTree creates a new regex tree.
-func NewRegexTree() *RegexTree {
-	return &RegexTree{}
+// NewRegexTree creates a new regex tree which holds interface{} values.
+func NewRegexTree() *RegexTreeOf[interface{}] {
+	return NewRegexTreeOf[interface{}]()
+}
+
+// NewRegexTreeOf creates a new regex tree which holds values of type V.
+func NewRegexTreeOf[V any]() *RegexTreeOf[V] {
+	return &RegexTreeOf[V]{}
 }
 
 // Walk walks the regex tree.
-func (rt *RegexTree) Walk(fn func(key string, value interface{})) {
+func (rt *RegexTreeOf[V]) Walk(fn func(key string, value V)) {
 	for i := range rt.regex {
 		fn(rt.regex[i].key, rt.regex[i].value)
 	}
 }
 
-func (rt *RegexTree) Del(key string) bool {
+// clone returns a copy of the tree which shares the regexSets of its index,
+// which are safe for concurrent use.
+func (rt *RegexTreeOf[V]) clone() *RegexTreeOf[V] {
+	rt.mu.Lock()
+	prev := rt.prev
+	rt.mu.Unlock()
+	if idx := rt.index.Load(); idx != nil {
+		prev = idx
+	}
+
+	return &RegexTreeOf[V]{
+		regex: append([]*regexValue[V](nil), rt.regex...),
+		prev:  prev,
+	}
+}
+
+// invalidate drops the index after a change, keeping it for the next one.
+func (rt *RegexTreeOf[V]) invalidate() {
+	if idx := rt.index.Load(); idx != nil {
+		rt.prev = idx
+		rt.index.Store(nil)
+	}
+}
+
+func (rt *RegexTreeOf[V]) Del(key string) bool {
+	_, ok := rt.remove(key)
+	return ok
+}
+
+// remove deletes the expression and returns its value.
+func (rt *RegexTreeOf[V]) remove(key string) (V, bool) {
 	for i := range rt.regex {
 		if rt.regex[i].key == key {
+			value := rt.regex[i].value
 			rt.regex = append(rt.regex[:i], rt.regex[i+1:]...)
+			rt.invalidate()
+			return value, true
+		}
+	}
+	var zero V
+	return zero, false
+}
+
+// Lookup lookups the key in the regex tree. The first expression which
+// matches in insertion order wins.
+func (rt *RegexTreeOf[V]) Lookup(key string) (*regexValue[V], bool) {
+	if len(rt.regex) == 0 {
+		return nil, false
+	}
+
+	i := rt.regexIndex().first(key)
+	if i < 0 {
+		return nil, false
+	}
+	return rt.regex[i], true
+}
+
+// regexIndex returns the regexIndex of the expressions, building it if
+// needed.
+func (rt *RegexTreeOf[V]) regexIndex() *regexIndex {
+	if idx := rt.index.Load(); idx != nil {
+		return idx
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if idx := rt.index.Load(); idx != nil {
+		return idx
+	}
+
+	regex := make([]*regexp.Regexp, len(rt.regex))
+	progs := make([]*syntax.Prog, len(rt.regex))
+	literals := make([]regexLiteral, len(rt.regex))
+	for i, rv := range rt.regex {
+		regex[i], progs[i], literals[i] = rv.regex, rv.prog, rv.literal
+	}
+	idx := newRegexIndex(regex, progs, literals, rt.prev)
+	rt.prev = nil
+	rt.index.Store(idx)
+	return idx
+}
+
+// Has reports whether the regular expression is in the tree.
+func (rt *RegexTreeOf[V]) Has(key string) bool {
+	for i := range rt.regex {
+		if rt.regex[i].key == key {
 			return true
 		}
 	}
 	return false
 }
 
-// Lookup lookups the key in the regex tree.
-func (rt *RegexTree) Lookup(key string) (*regexValue, bool) {
-	for i := range rt.regex {
+// lookupAll calls fn for every regular expression which matches the key in
+// insertion order, until fn returns false.
+func (rt *RegexTreeOf[V]) lookupAll(key string, fn func(rv *regexValue[V]) bool) bool {
+	if len(rt.regex) == 0 {
+		return true
+	}
+
+	first := rt.regexIndex().first(key)
+	if first < 0 {
+		return true
+	}
+
+	if !fn(rt.regex[first]) {
+		return false
+	}
+	for i := first + 1; i < len(rt.regex); i++ {
 		if rt.regex[i].regex.MatchString(key) {
-			return rt.regex[i], true
+			if !fn(rt.regex[i]) {
+				return false
+			}
 		}
 	}
-	return nil, false
+	return true
 }
 
-// Add adds a regular expression.
-func (rt *RegexTree) Add(key string, value interface{}) error {
+// Add adds a regular expression. ErrDuplicateKey is returned if it is already
+// in the tree.
+func (rt *RegexTreeOf[V]) Add(key string, value V) error {
 	// Compile(expr string) (*Regexp, error)
-	for i := range rt.regex {
-		if rt.regex[i].key == key {
-			return errors.New("duplicated key")
-		}
+	if rt.Has(key) {
+		return ErrDuplicateKey
 	}
 
 	rex, err := regexp.Compile(key)
@@ -62,11 +191,54 @@ func (rt *RegexTree) Add(key string, value interface{}) error {
 		return err
 	}
 
-	rt.regex = append(rt.regex, &regexValue{
-		key:   key,
-		regex: rex,
-		value: value,
-	})
+	rt.add(key, rex, value)
 
 	return nil
 }
+
+// Put adds a regular expression like Add, but replaces the value of the
+// expression if it is already in the tree, keeping its priority, and returns
+// the replaced value.
+func (rt *RegexTreeOf[V]) Put(key string, value V) (V, bool, error) {
+	rex, err := regexp.Compile(key)
+	if err != nil {
+		var zero V
+		return zero, false, err
+	}
+
+	prev, ok := rt.put(key, rex, value)
+	return prev, ok, nil
+}
+
+// insert adds a compiled regular expression unless it is already in the tree.
+func (rt *RegexTreeOf[V]) insert(key string, rex *regexp.Regexp, value V) error {
+	if rt.Has(key) {
+		return ErrDuplicateKey
+	}
+	rt.add(key, rex, value)
+	return nil
+}
+
+// put adds a compiled regular expression or replaces the one in the tree and
+// returns the replaced value. The entry is replaced rather than modified, as
+// it may be shared with a clone.
+func (rt *RegexTreeOf[V]) put(key string, rex *regexp.Regexp, value V) (V, bool) {
+	for i := range rt.regex {
+		if rt.regex[i].key == key {
+			prev := rt.regex[i].value
+			rt.regex[i] = newRegexValue(key, rex, value)
+			rt.invalidate()
+			return prev, true
+		}
+	}
+
+	rt.add(key, rex, value)
+	var zero V
+	return zero, false
+}
+
+// add adds a compiled regular expression without checking for duplicates.
+func (rt *RegexTreeOf[V]) add(key string, rex *regexp.Regexp, value V) {
+	rt.regex = append(rt.regex, newRegexValue(key, rex, value))
+	rt.invalidate()
+}
@@ -0,0 +1,852 @@
+package domaintree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The errors reported when a snapshot can not be loaded.
+var (
+	ErrInvalidSnapshot  = errors.New("invalid snapshot")
+	ErrSnapshotVersion  = errors.New("unsupported snapshot version")
+	ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+	ErrSnapshotOptions  = errors.New("snapshot options mismatch")
+)
+
+// The format of a snapshot is
+//
+//	magic    "DTRE"
+//	version  uvarint
+//	options  uvarint, the options which change how the keys are parsed
+//	entries  uvarint, the number of entries
+//	nodes    uvarint, the number of nodes of the tries
+//	length   uvarint, the length of the body
+//	body     the layout of the tree:
+//	  glob     byte, 1 if the glob * of prefix is followed by its entry
+//	  prefix   trie
+//	  suffix   trie
+//	  count    uvarint, followed by the entries of the regular expressions
+//	checksum the CRC-32 (IEEE) of the bytes above, little endian
+//
+// where
+//
+//	trie     uvarint number of labels, uvarint number of patterns, and
+//	         for each label then each pattern its uvarint length and bytes
+//	         followed by its node
+//	node     type byte, the entries of the full, single-label wildcard and
+//	         wildcard values it holds in this order, and the trie of its
+//	         children
+//	entry    priority varint, key and value, each a uvarint length and
+//	         bytes, the value being encoded by the ValueCodec
+//
+// so that a snapshot is loaded without parsing and inserting the keys again,
+// only the regular expressions being compiled again.
+const (
+	snapshotMagic   = "DTRE"
+	snapshotVersion = 1
+)
+
+// ValueCodec encodes the values of the tree in the snapshots.
+type ValueCodec[V any] interface {
+	AppendValue(dst []byte, value V) ([]byte, error)
+	DecodeValue(data []byte) (V, error)
+}
+
+// Codec sets the codec of the values of the snapshots written by
+// MarshalBinary and WriteTo and read by UnmarshalBinary and ReadFrom. The
+// type of the values must be the one of the tree.
+//
+// Without the option the strings, the byte slices, the booleans and the
+// numbers are encoded directly and the other values with encoding/gob, which
+// requires the concrete types of the interface values to be registered with
+// gob.Register.
+func Codec[V any](codec ValueCodec[V]) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// defaultCodec is the default ValueCodec, which encodes the strings, the
+// byte slices, the booleans and the numbers directly and the other values
+// with encoding/gob.
+type defaultCodec[V any] struct{}
+
+func (defaultCodec[V]) AppendValue(dst []byte, value V) ([]byte, error) {
+	// the type of the values, not the dynamic type of an interface value
+	switch v := any(&value).(type) {
+	case *string:
+		return append(dst, *v...), nil
+	case *[]byte:
+		return append(dst, *v...), nil
+	case *bool:
+		if *v {
+			return append(dst, 1), nil
+		}
+		return append(dst, 0), nil
+	case *int:
+		return binary.AppendVarint(dst, int64(*v)), nil
+	case *int8:
+		return binary.AppendVarint(dst, int64(*v)), nil
+	case *int16:
+		return binary.AppendVarint(dst, int64(*v)), nil
+	case *int32:
+		return binary.AppendVarint(dst, int64(*v)), nil
+	case *int64:
+		return binary.AppendVarint(dst, *v), nil
+	case *uint:
+		return binary.AppendUvarint(dst, uint64(*v)), nil
+	case *uint8:
+		return binary.AppendUvarint(dst, uint64(*v)), nil
+	case *uint16:
+		return binary.AppendUvarint(dst, uint64(*v)), nil
+	case *uint32:
+		return binary.AppendUvarint(dst, uint64(*v)), nil
+	case *uint64:
+		return binary.AppendUvarint(dst, *v), nil
+	case *float32:
+		return binary.LittleEndian.AppendUint32(dst, math.Float32bits(*v)), nil
+	case *float64:
+		return binary.LittleEndian.AppendUint64(dst, math.Float64bits(*v)), nil
+	}
+
+	buf := bytes.NewBuffer(dst)
+	err := gob.NewEncoder(buf).Encode(&value)
+	return buf.Bytes(), err
+}
+
+func (defaultCodec[V]) DecodeValue(data []byte) (V, error) {
+	var value V
+	var err error
+	switch v := any(&value).(type) {
+	case *string:
+		*v = string(data)
+	case *[]byte:
+		*v = append([]byte(nil), data...)
+	case *bool:
+		if len(data) != 1 {
+			err = ErrInvalidSnapshot
+		}
+		*v = len(data) == 1 && data[0] == 1
+	case *int:
+		x, e := decodeVarint(data)
+		*v, err = int(x), e
+	case *int8:
+		x, e := decodeVarint(data)
+		*v, err = int8(x), e
+	case *int16:
+		x, e := decodeVarint(data)
+		*v, err = int16(x), e
+	case *int32:
+		x, e := decodeVarint(data)
+		*v, err = int32(x), e
+	case *int64:
+		*v, err = decodeVarint(data)
+	case *uint:
+		x, e := decodeUvarint(data)
+		*v, err = uint(x), e
+	case *uint8:
+		x, e := decodeUvarint(data)
+		*v, err = uint8(x), e
+	case *uint16:
+		x, e := decodeUvarint(data)
+		*v, err = uint16(x), e
+	case *uint32:
+		x, e := decodeUvarint(data)
+		*v, err = uint32(x), e
+	case *uint64:
+		*v, err = decodeUvarint(data)
+	case *float32:
+		if len(data) != 4 {
+			return value, ErrInvalidSnapshot
+		}
+		*v = math.Float32frombits(binary.LittleEndian.Uint32(data))
+	case *float64:
+		if len(data) != 8 {
+			return value, ErrInvalidSnapshot
+		}
+		*v = math.Float64frombits(binary.LittleEndian.Uint64(data))
+	default:
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	}
+	return value, err
+}
+
+func decodeVarint(data []byte) (int64, error) {
+	x, n := binary.Varint(data)
+	if n != len(data) {
+		return 0, ErrInvalidSnapshot
+	}
+	return x, nil
+}
+
+func decodeUvarint(data []byte) (uint64, error) {
+	x, n := binary.Uvarint(data)
+	if n != len(data) {
+		return 0, ErrInvalidSnapshot
+	}
+	return x, nil
+}
+
+func (dt *DomainTreeOf[V]) codec() (ValueCodec[V], error) {
+	if dt.opts.codec == nil {
+		return defaultCodec[V]{}, nil
+	}
+	codec, ok := dt.opts.codec.(ValueCodec[V])
+	if !ok {
+		return nil, fmt.Errorf("domaintree: codec %T does not encode %T values", dt.opts.codec, *new(V))
+	}
+	return codec, nil
+}
+
+// parseOptions returns the bits of the options which change how the keys are
+// parsed, which a snapshot must be loaded with.
+func (o *options) parseOptions() uint64 {
+	var bits uint64
+	for i, set := range []bool{o.nginx, o.singleLabel, o.caseInsensitive, o.trailingDot, o.idna, o.strict} {
+		if set {
+			bits |= 1 << i
+		}
+	}
+	return bits
+}
+
+// MarshalBinary encodes the entries of the tree in a snapshot.
+func (dt *DomainTreeOf[V]) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := dt.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteTo writes a snapshot of the entries of the tree to w. The body of the
+// snapshot is encoded in memory before being written.
+func (dt *DomainTreeOf[V]) WriteTo(w io.Writer) (int64, error) {
+	codec, err := dt.codec()
+	if err != nil {
+		return 0, err
+	}
+
+	se := &snapshotEncoder[V]{codec: codec}
+	se.tree(dt)
+	if se.err != nil {
+		return 0, se.err
+	}
+
+	sw := &snapshotWriter{w: bufio.NewWriter(w), crc: crc32.NewIEEE()}
+	sw.write([]byte(snapshotMagic))
+	sw.writeUvarint(snapshotVersion)
+	sw.writeUvarint(dt.opts.parseOptions())
+	sw.writeUvarint(se.entries)
+	sw.writeUvarint(se.trieNodes)
+	sw.writeUvarint(uint64(len(se.buf)))
+	sw.write(se.buf)
+	sw.write(binary.LittleEndian.AppendUint32(nil, sw.crc.Sum32()))
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	return sw.n, sw.err
+}
+
+// UnmarshalBinary replaces the entries of the tree with the ones of the
+// snapshot. The tree must have been created with the options, which change
+// how the keys are parsed, of the tree the snapshot was taken from, and is
+// left unchanged on error. The tries are restored as they were saved, only
+// the regular expressions being compiled again.
+func (dt *DomainTreeOf[V]) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	ndt, _, err := dt.readSnapshot(r)
+	if err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrInvalidSnapshot, r.Len())
+	}
+	*dt = *ndt
+	return nil
+}
+
+// ReadFrom replaces the entries of the tree with the ones of a snapshot read
+// from r like UnmarshalBinary. It may read past the end of the snapshot.
+func (dt *DomainTreeOf[V]) ReadFrom(r io.Reader) (int64, error) {
+	ndt, n, err := dt.readSnapshot(r)
+	if err != nil {
+		return n, err
+	}
+	*dt = *ndt
+	return n, nil
+}
+
+// readSnapshot returns a new tree with the options of dt holding the entries
+// of the snapshot read from r.
+func (dt *DomainTreeOf[V]) readSnapshot(r io.Reader) (*DomainTreeOf[V], int64, error) {
+	codec, err := dt.codec()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	br, ok := r.(snapshotByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	sr := &snapshotReader{r: br, crc: crc32.NewIEEE()}
+
+	if magic := sr.read(len(snapshotMagic)); sr.err == nil && string(magic) != snapshotMagic {
+		return nil, sr.n, fmt.Errorf("%w: bad magic %q", ErrInvalidSnapshot, magic)
+	}
+	version := sr.readUvarint()
+	if sr.err == nil && version != snapshotVersion {
+		return nil, sr.n, fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+	if opts := sr.readUvarint(); sr.err == nil && opts != dt.opts.parseOptions() {
+		return nil, sr.n, fmt.Errorf("%w: %#x instead of %#x", ErrSnapshotOptions, opts, dt.opts.parseOptions())
+	}
+
+	entries, trieNodes := sr.readUvarint(), sr.readUvarint()
+	body := sr.read(int(sr.readUvarint()))
+
+	sum := sr.crc.Sum32()
+	if crc := sr.read(4); sr.err == nil && binary.LittleEndian.Uint32(crc) != sum {
+		return nil, sr.n, ErrSnapshotChecksum
+	}
+	if sr.err != nil {
+		if errors.Is(sr.err, io.EOF) || errors.Is(sr.err, io.ErrUnexpectedEOF) {
+			sr.err = fmt.Errorf("%w: %v", ErrInvalidSnapshot, io.ErrUnexpectedEOF)
+		}
+		return nil, sr.n, sr.err
+	}
+
+	ndt, err := dt.restore(body, entries, trieNodes, codec)
+	if err != nil {
+		return nil, sr.n, err
+	}
+	return ndt, sr.n, nil
+}
+
+// restore returns a new tree with the options of dt holding the layout of the
+// body of a snapshot, which holds the entries and the nodes of the tries
+// counted in the header.
+func (dt *DomainTreeOf[V]) restore(body []byte, entries, trieNodes uint64, codec ValueCodec[V]) (*DomainTreeOf[V], error) {
+	// an entry or a node takes at least 3 bytes
+	if entries > uint64(len(body)) || trieNodes > uint64(len(body)) {
+		return nil, fmt.Errorf("%w: %d entries and %d nodes in %d bytes", ErrInvalidSnapshot, entries, trieNodes, len(body))
+	}
+
+	sd := &snapshotDecoder[V]{
+		data:      body,
+		str:       string(body),
+		codec:     codec,
+		entries:   make([]DomainNodeOf[V], 0, entries),
+		trieNodes: make([]HashValueOf[*DomainNodeOf[V]], 0, trieNodes),
+		hashes:    make([]WildcardHashOf[*DomainNodeOf[V]], 0, trieNodes+2),
+	}
+	ndt := &DomainTreeOf[V]{
+		prefix: &PrefixWildcardOf[*DomainNodeOf[V]]{},
+		suffix: &SuffixWildcardOf[*DomainNodeOf[V]]{},
+		regex:  NewRegexTreeOf[*DomainNodeOf[V]](),
+		opts:   dt.opts,
+	}
+
+	if sd.byte() != 0 {
+		ndt.prefix.glob, ndt.prefix.hasGlob = sd.node(), true
+	}
+	sd.indexer = PrefixIndexer
+	ndt.prefix.wh = sd.hash(0)
+	sd.indexer = SuffixIndexer
+	ndt.suffix.wh = sd.hash(0)
+
+	count := sd.uvarint()
+	if count > uint64(len(sd.data)-sd.off) {
+		sd.truncated()
+	}
+	for i := uint64(0); i < count && sd.err == nil; i++ {
+		node := sd.node()
+		if sd.err != nil {
+			break
+		}
+
+		var rex *regexp.Regexp
+		var err error
+		if ndt.opts.nginx {
+			_, rex, err = ndt.resolve(node.key)
+			if err == nil && rex == nil {
+				err = fmt.Errorf("%q is not a regular expression", node.key)
+			}
+		} else {
+			rex, err = ndt.compileRegex(node.key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+		ndt.regex.regex = append(ndt.regex.regex, newRegexValue(node.key, rex, node))
+	}
+
+	if sd.err != nil {
+		return nil, sd.err
+	}
+	if sd.off != len(sd.data) {
+		return nil, fmt.Errorf("%w: %d trailing bytes in the body", ErrInvalidSnapshot, len(sd.data)-sd.off)
+	}
+	ndt.prioritized = sd.prioritized
+	return ndt, nil
+}
+
+// snapshotEncoder encodes the body of a snapshot, keeping the first error.
+type snapshotEncoder[V any] struct {
+	buf       []byte
+	value     []byte
+	codec     ValueCodec[V]
+	entries   uint64
+	trieNodes uint64
+	err       error
+}
+
+func (se *snapshotEncoder[V]) tree(dt *DomainTreeOf[V]) {
+	if dt.prefix.hasGlob {
+		se.buf = append(se.buf, 1)
+		se.node(dt.prefix.glob)
+	} else {
+		se.buf = append(se.buf, 0)
+	}
+	se.hash(dt.prefix.wh)
+	se.hash(dt.suffix.wh)
+
+	se.buf = binary.AppendUvarint(se.buf, uint64(len(dt.regex.regex)))
+	for _, rv := range dt.regex.regex {
+		se.node(rv.value)
+	}
+}
+
+// hash encodes the children sorted by label, so that the snapshots of the
+// same entries are the same.
+func (se *snapshotEncoder[V]) hash(wh *WildcardHashOf[*DomainNodeOf[V]]) {
+	labels := make([]string, 0, len(wh.hash))
+	for label := range wh.hash {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	se.buf = binary.AppendUvarint(se.buf, uint64(len(labels)))
+	se.buf = binary.AppendUvarint(se.buf, uint64(len(wh.globs)))
+	for _, label := range labels {
+		se.string(label)
+		se.hashValue(wh.hash[label])
+	}
+	for _, g := range wh.globs {
+		se.string(g.pattern)
+		se.hashValue(g.hv)
+	}
+}
+
+func (se *snapshotEncoder[V]) hashValue(hv *HashValueOf[*DomainNodeOf[V]]) {
+	se.trieNodes++
+	se.buf = append(se.buf, byte(hv.typ))
+	for _, typ := range valueTypes {
+		if hv.typ&typ == typ {
+			se.node(hv.valueOf(typ))
+		}
+	}
+	se.hash(hv.hash)
+}
+
+func (se *snapshotEncoder[V]) node(dn *DomainNodeOf[V]) {
+	if se.err != nil {
+		return
+	}
+	se.value, se.err = se.codec.AppendValue(se.value[:0], dn.value)
+	if se.err != nil {
+		se.err = fmt.Errorf("domaintree: encoding the value of %q: %w", dn.key, se.err)
+		return
+	}
+
+	se.entries++
+	se.buf = binary.AppendVarint(se.buf, int64(dn.priority))
+	se.string(dn.key)
+	se.buf = binary.AppendUvarint(se.buf, uint64(len(se.value)))
+	se.buf = append(se.buf, se.value...)
+}
+
+func (se *snapshotEncoder[V]) string(s string) {
+	se.buf = binary.AppendUvarint(se.buf, uint64(len(s)))
+	se.buf = append(se.buf, s...)
+}
+
+// maxSnapshotDepth bounds the depth of the tries, so that a corrupted
+// snapshot does not exhaust the stack.
+const maxSnapshotDepth = 1 << 12
+
+// snapshotDecoder decodes the body of a snapshot, keeping the first error.
+// The keys and the labels are substrings of str, and the entries and the
+// nodes of the tries are allocated in bulk.
+type snapshotDecoder[V any] struct {
+	data    []byte
+	str     string
+	off     int
+	codec   ValueCodec[V]
+	indexer StringIndexer
+
+	entries   []DomainNodeOf[V]
+	trieNodes []HashValueOf[*DomainNodeOf[V]]
+	hashes    []WildcardHashOf[*DomainNodeOf[V]]
+
+	prioritized int
+	err         error
+}
+
+func (sd *snapshotDecoder[V]) truncated() {
+	if sd.err == nil {
+		sd.err = fmt.Errorf("%w: %v", ErrInvalidSnapshot, io.ErrUnexpectedEOF)
+	}
+}
+
+func (sd *snapshotDecoder[V]) byte() byte {
+	if sd.err != nil || sd.off == len(sd.data) {
+		sd.truncated()
+		return 0
+	}
+	sd.off++
+	return sd.data[sd.off-1]
+}
+
+func (sd *snapshotDecoder[V]) uvarint() uint64 {
+	if sd.err != nil {
+		return 0
+	}
+	x, n := binary.Uvarint(sd.data[sd.off:])
+	if n <= 0 {
+		sd.truncated()
+		return 0
+	}
+	sd.off += n
+	return x
+}
+
+func (sd *snapshotDecoder[V]) varint() int64 {
+	if sd.err != nil {
+		return 0
+	}
+	x, n := binary.Varint(sd.data[sd.off:])
+	if n <= 0 {
+		sd.truncated()
+		return 0
+	}
+	sd.off += n
+	return x
+}
+
+// field returns the offsets of a field prefixed by its length.
+func (sd *snapshotDecoder[V]) field() (start, end int) {
+	n := sd.uvarint()
+	if sd.err != nil || n > uint64(len(sd.data)-sd.off) {
+		sd.truncated()
+		return 0, 0
+	}
+	start = sd.off
+	sd.off += int(n)
+	return start, sd.off
+}
+
+func (sd *snapshotDecoder[V]) string() string {
+	start, end := sd.field()
+	return sd.str[start:end]
+}
+
+func (sd *snapshotDecoder[V]) node() *DomainNodeOf[V] {
+	priority := sd.varint()
+	key := sd.string()
+	start, end := sd.field()
+	if sd.err != nil {
+		return nil
+	}
+
+	value, err := sd.codec.DecodeValue(sd.data[start:end:end])
+	if err != nil {
+		sd.err = fmt.Errorf("domaintree: decoding the value of %q: %w", key, err)
+		return nil
+	}
+
+	var dn *DomainNodeOf[V]
+	if len(sd.entries) < cap(sd.entries) {
+		sd.entries = append(sd.entries, DomainNodeOf[V]{})
+		dn = &sd.entries[len(sd.entries)-1]
+	} else {
+		dn = &DomainNodeOf[V]{}
+	}
+	dn.key, dn.value, dn.priority = key, value, int(priority)
+	if priority != 0 {
+		sd.prioritized++
+	}
+	return dn
+}
+
+func (sd *snapshotDecoder[V]) hash(depth int) *WildcardHashOf[*DomainNodeOf[V]] {
+	if depth > maxSnapshotDepth {
+		if sd.err == nil {
+			sd.err = fmt.Errorf("%w: more than %d labels", ErrInvalidSnapshot, maxSnapshotDepth)
+		}
+		return nil
+	}
+
+	labels, globs := sd.uvarint(), sd.uvarint()
+	// a child takes at least 2 bytes
+	if labels+globs > uint64(len(sd.data)-sd.off) {
+		sd.truncated()
+	}
+	if sd.err != nil {
+		return nil
+	}
+
+	var wh *WildcardHashOf[*DomainNodeOf[V]]
+	if len(sd.hashes) < cap(sd.hashes) {
+		sd.hashes = append(sd.hashes, WildcardHashOf[*DomainNodeOf[V]]{})
+		wh = &sd.hashes[len(sd.hashes)-1]
+	} else {
+		wh = &WildcardHashOf[*DomainNodeOf[V]]{}
+	}
+	wh.indexer = sd.indexer
+	if labels > 0 {
+		// the map of a leaf is made by setChild
+		wh.hash = make(map[string]*HashValueOf[*DomainNodeOf[V]], labels)
+	}
+	for i := uint64(0); i < labels && sd.err == nil; i++ {
+		label := sd.string()
+		wh.hash[label] = sd.hashValue(depth)
+	}
+	for i := uint64(0); i < globs && sd.err == nil; i++ {
+		pattern := sd.string()
+		wh.globs = append(wh.globs, &labelGlob[*DomainNodeOf[V]]{
+			pattern: pattern,
+			literal: len(pattern) - strings.Count(pattern, "*"),
+			hv:      sd.hashValue(depth),
+		})
+	}
+	return wh
+}
+
+func (sd *snapshotDecoder[V]) hashValue(depth int) *HashValueOf[*DomainNodeOf[V]] {
+	typ := HashValueType(sd.byte())
+	valid := FullHashValueType | WildcardHashValueType | ApexHashValueType | SingleWildcardHashValueType
+	if sd.err == nil && (typ&^valid != 0 || typ&ApexHashValueType != 0 && typ&WildcardHashValueType == 0) {
+		sd.err = fmt.Errorf("%w: node type %#x", ErrInvalidSnapshot, uint8(typ))
+	}
+	if sd.err != nil {
+		return nil
+	}
+
+	var hv *HashValueOf[*DomainNodeOf[V]]
+	if len(sd.trieNodes) < cap(sd.trieNodes) {
+		sd.trieNodes = append(sd.trieNodes, HashValueOf[*DomainNodeOf[V]]{})
+		hv = &sd.trieNodes[len(sd.trieNodes)-1]
+	} else {
+		hv = &HashValueOf[*DomainNodeOf[V]]{}
+	}
+	hv.typ = typ
+	if typ&FullHashValueType != 0 {
+		hv.fullvalue = sd.node()
+	}
+	if typ&SingleWildcardHashValueType != 0 {
+		hv.singlevalue = sd.node()
+	}
+	if typ&WildcardHashValueType != 0 {
+		hv.wildcardvalue = sd.node()
+	}
+	hv.hash = sd.hash(depth + 1)
+	return hv
+}
+
+// snapshotWriter writes a snapshot, keeping the first error.
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	n   int64
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	n, err := sw.w.Write(p)
+	sw.crc.Write(p[:n])
+	sw.n += int64(n)
+	sw.err = err
+}
+
+func (sw *snapshotWriter) writeUvarint(x uint64) {
+	n := binary.PutUvarint(sw.buf[:], x)
+	sw.write(sw.buf[:n])
+}
+
+func (sw *snapshotWriter) writeVarint(x int64) {
+	n := binary.PutVarint(sw.buf[:], x)
+	sw.write(sw.buf[:n])
+}
+
+type snapshotByteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// snapshotReader reads a snapshot, keeping the first error.
+type snapshotReader struct {
+	r   snapshotByteReader
+	crc hash.Hash32
+	n   int64
+	err error
+}
+
+// maxSnapshotField bounds the length of a key, a value or the body, so that a
+// corrupted length does not allocate gigabytes.
+const maxSnapshotField = 1 << 30
+
+func (sr *snapshotReader) read(n int) []byte {
+	if sr.err != nil {
+		return nil
+	}
+	if n < 0 || n > maxSnapshotField {
+		sr.err = fmt.Errorf("%w: field of %d bytes", ErrInvalidSnapshot, n)
+		return nil
+	}
+
+	p := make([]byte, n)
+	m, err := io.ReadFull(sr.r, p)
+	sr.crc.Write(p[:m])
+	sr.n += int64(m)
+	sr.err = err
+	return p
+}
+
+func (sr *snapshotReader) readByte() byte {
+	p := sr.read(1)
+	if sr.err != nil {
+		return 0
+	}
+	return p[0]
+}
+
+// byteReader counts and checksums the bytes read by binary.ReadUvarint.
+type byteReader struct {
+	*snapshotReader
+}
+
+func (br byteReader) ReadByte() (byte, error) {
+	c, err := br.r.ReadByte()
+	if err == nil {
+		br.crc.Write([]byte{c})
+		br.n++
+	}
+	return c, err
+}
+
+func (sr *snapshotReader) readUvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(byteReader{sr})
+	sr.err = err
+	return x
+}
+
+func (sr *snapshotReader) readVarint() int64 {
+	if sr.err != nil {
+		return 0
+	}
+	x, err := binary.ReadVarint(byteReader{sr})
+	sr.err = err
+	return x
+}
+
+// MarshalBinary encodes the entries of the tree in a snapshot (thread-safe).
+func (dt *LockedDomainTreeOf[V]) MarshalBinary() ([]byte, error) {
+	dt.RLock()
+	defer dt.RUnlock()
+	return dt.dt.MarshalBinary()
+}
+
+// WriteTo writes a snapshot of the entries of the tree to w (thread-safe).
+func (dt *LockedDomainTreeOf[V]) WriteTo(w io.Writer) (int64, error) {
+	dt.RLock()
+	defer dt.RUnlock()
+	return dt.dt.WriteTo(w)
+}
+
+// UnmarshalBinary replaces the entries of the tree with the ones of the
+// snapshot (thread-safe).
+func (dt *LockedDomainTreeOf[V]) UnmarshalBinary(data []byte) error {
+	ndt := dt.empty()
+	if err := ndt.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	dt.Lock()
+	dt.dt = ndt
+	dt.Unlock()
+	return nil
+}
+
+// ReadFrom replaces the entries of the tree with the ones of a snapshot read
+// from r (thread-safe).
+func (dt *LockedDomainTreeOf[V]) ReadFrom(r io.Reader) (int64, error) {
+	ndt := dt.empty()
+	n, err := ndt.ReadFrom(r)
+	if err != nil {
+		return n, err
+	}
+	dt.Lock()
+	dt.dt = ndt
+	dt.Unlock()
+	return n, nil
+}
+
+// empty returns an empty tree with the options of the tree, which the
+// snapshots are loaded into without holding the lock.
+func (dt *LockedDomainTreeOf[V]) empty() *DomainTreeOf[V] {
+	dt.RLock()
+	opts := dt.dt.opts
+	dt.RUnlock()
+	return &DomainTreeOf[V]{opts: opts}
+}
+
+// MarshalBinary encodes the entries of the current snapshot (lock-free).
+func (adt *AtomicDomainTree[V]) MarshalBinary() ([]byte, error) {
+	return adt.dt.Load().MarshalBinary()
+}
+
+// WriteTo writes the entries of the current snapshot to w (lock-free).
+func (adt *AtomicDomainTree[V]) WriteTo(w io.Writer) (int64, error) {
+	return adt.dt.Load().WriteTo(w)
+}
+
+// UnmarshalBinary replaces the tree with the entries of the snapshot and
+// publishes it.
+func (adt *AtomicDomainTree[V]) UnmarshalBinary(data []byte) error {
+	ndt := &DomainTreeOf[V]{opts: adt.dt.Load().opts}
+	if err := ndt.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	adt.Store(ndt)
+	return nil
+}
+
+// ReadFrom replaces the tree with the entries of a snapshot read from r and
+// publishes it.
+func (adt *AtomicDomainTree[V]) ReadFrom(r io.Reader) (int64, error) {
+	ndt := &DomainTreeOf[V]{opts: adt.dt.Load().opts}
+	n, err := ndt.ReadFrom(r)
+	if err != nil {
+		return n, err
+	}
+	adt.Store(ndt)
+	return n, nil
+}
@@ -0,0 +1,177 @@
+package domaintree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func entriesOf[V any](t *testing.T, dt *DomainTreeOf[V]) []Entry[V] {
+	var entries []Entry[V]
+	require.NoError(t, dt.WalkEntries(HierarchicalOrder, func(e Entry[V]) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	return entries
+}
+
+func TestSnapshot(t *testing.T) {
+	for _, tt := range []struct {
+		opts []Option
+		keys []string
+	}{
+		{nil, []string{"*", "example.com", "*.example.com", "api-*.example.com", "www.example.*", `^www\d+\.`}},
+		{[]Option{SingleLabelWildcard(), CaseInsensitive()}, []string{"*.Example.com", "**.example.com", "example.**", "mail.*"}},
+		{[]Option{NginxMode(), IDNA()}, []string{".bücher.example", "*.example.com", "mail.*", `~^www\d+\.münchen\.example$`, "_"}},
+	} {
+		dt := NewDomainTreeOf[string](tt.opts...)
+		for i, key := range tt.keys {
+			if key[0] == '^' {
+				require.NoError(t, dt.AddRegexWithOptions(key, key, Priority(i)))
+				continue
+			}
+			require.NoError(t, dt.AddWithOptions(key, key, Priority(i)))
+		}
+
+		data, err := dt.MarshalBinary()
+		require.NoError(t, err)
+
+		loaded := NewDomainTreeOf[string](tt.opts...)
+		require.NoError(t, loaded.Add("stale.example.org", "stale"))
+		require.NoError(t, loaded.UnmarshalBinary(data))
+		require.Equal(t, entriesOf(t, dt), entriesOf(t, loaded))
+
+		for _, host := range []string{"example.com", "a.example.com", "api-1.example.com", "www.example.net", "www1.example.org", "stale.example.org", "www.bücher.example", "www2.münchen.example", "mail.example"} {
+			expect, eok := dt.Lookup(host)
+			got, ok := loaded.Lookup(host)
+			require.Equal(t, eok, ok, host)
+			if ok {
+				require.Equal(t, expect.GetValue(), got.GetValue(), host)
+				require.Equal(t, expect.GetPriority(), got.GetPriority(), host)
+			}
+		}
+
+		var buf bytes.Buffer
+		n, err := dt.WriteTo(&buf)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(data)), n)
+		require.Equal(t, data, buf.Bytes())
+
+		loaded = NewDomainTreeOf[string](tt.opts...)
+		n, err = loaded.ReadFrom(&buf)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(data)), n)
+		require.Equal(t, entriesOf(t, dt), entriesOf(t, loaded))
+	}
+}
+
+type intCodec struct{}
+
+func (intCodec) AppendValue(dst []byte, value int) ([]byte, error) {
+	return strconv.AppendInt(dst, int64(value), 10), nil
+}
+
+func (intCodec) DecodeValue(data []byte) (int, error) {
+	return strconv.Atoi(string(data))
+}
+
+func TestSnapshotCodec(t *testing.T) {
+	dt := NewLockedDomainTreeOf[int](Codec[int](intCodec{}))
+	require.NoError(t, dt.Add("example.com", 42))
+	data, err := dt.MarshalBinary()
+	require.NoError(t, err)
+	require.True(t, bytes.Contains(data, []byte("\x0242")))
+
+	loaded := NewLockedDomainTreeOf[int](Codec[int](intCodec{}))
+	require.NoError(t, loaded.UnmarshalBinary(data))
+	dn, ok := loaded.Lookup("example.com")
+	require.True(t, ok)
+	require.Equal(t, 42, dn.GetValue())
+
+	adt := NewAtomicDomainTreeOf[int](Codec[int](intCodec{}))
+	_, err = adt.ReadFrom(bytes.NewReader(data))
+	require.NoError(t, err)
+	dn, ok = adt.Lookup("example.com")
+	require.True(t, ok)
+	require.Equal(t, 42, dn.GetValue())
+
+	// the codec must encode the values of the tree
+	_, err = NewDomainTreeOf[string](Codec[int](intCodec{})).MarshalBinary()
+	require.Error(t, err)
+
+	// the values of an interface{} tree must be registered with gob
+	idt := NewDomainTree()
+	require.NoError(t, idt.Add("example.com", 1))
+	require.NoError(t, idt.Add("example.org", "a"))
+	data, err = idt.MarshalBinary()
+	require.NoError(t, err)
+	loadedi := NewDomainTree()
+	require.NoError(t, loadedi.UnmarshalBinary(data))
+	dni, ok := loadedi.Lookup("example.org")
+	require.True(t, ok)
+	require.Equal(t, "a", dni.GetValue())
+}
+
+func TestSnapshotInvalid(t *testing.T) {
+	dt := NewDomainTreeOf[int]()
+	require.NoError(t, dt.Add("example.com", 1))
+	require.NoError(t, dt.AddRegex(`^www\.`, 2))
+	data, err := dt.MarshalBinary()
+	require.NoError(t, err)
+
+	load := func(data []byte, opts ...Option) error {
+		loaded := NewDomainTreeOf[int](opts...)
+		require.NoError(t, loaded.Add("kept.example.com", 3))
+		err := loaded.UnmarshalBinary(data)
+		if err != nil {
+			// the tree is left unchanged
+			dn, ok := loaded.Lookup("kept.example.com")
+			require.True(t, ok)
+			require.Equal(t, 3, dn.GetValue())
+		}
+		return err
+	}
+
+	require.NoError(t, load(data))
+
+	for i := range data {
+		corrupted := append([]byte(nil), data...)
+		corrupted[i] ^= 0x40
+		require.Error(t, load(corrupted), fmt.Sprint(i))
+	}
+	for i := range data {
+		require.True(t, errors.Is(load(data[:i]), ErrInvalidSnapshot), fmt.Sprint(i))
+	}
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(data)-5] ^= 1
+	require.True(t, errors.Is(load(corrupted), ErrSnapshotChecksum))
+
+	corrupted = append([]byte(nil), data...)
+	corrupted[0] = 'X'
+	require.True(t, errors.Is(load(corrupted), ErrInvalidSnapshot))
+
+	corrupted = append([]byte(nil), data...)
+	corrupted[4] = 9
+	require.True(t, errors.Is(load(corrupted), ErrSnapshotVersion))
+
+	// the layout must hold the entries it counts
+	layout := func(body string) []byte {
+		p := append([]byte(snapshotMagic), snapshotVersion, 0, 0, 0, byte(len(body)))
+		p = append(p, body...)
+		return binary.LittleEndian.AppendUint32(p, crc32.ChecksumIEEE(p))
+	}
+	require.NoError(t, load(layout("\x00\x00\x00\x00\x00\x00")))
+	require.True(t, errors.Is(load(layout("\x00\x00\x00\x00\x00\x00\x00")), ErrInvalidSnapshot))
+	require.True(t, errors.Is(load(layout("\x00\x01\x00\x01a\x10\x00\x00\x00\x00\x00\x00")), ErrInvalidSnapshot))
+	require.True(t, errors.Is(load(layout("\x00\x05\x00\x01a\x00\x00\x00\x00\x00\x00")), ErrInvalidSnapshot))
+
+	require.True(t, errors.Is(load(data, NginxMode()), ErrSnapshotOptions))
+	require.True(t, errors.Is(load(append(data, 0)), ErrInvalidSnapshot))
+}
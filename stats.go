@@ -0,0 +1,114 @@
+package domaintree
+
+import "unsafe"
+
+// Stats describes the size of a tree.
+type Stats struct {
+	// Nodes is the number of nodes of the tries, one per label of the keys
+	// and shared by the keys with the same labels.
+	Nodes int
+	// Leaves is the number of nodes without a child.
+	Leaves int
+	// Entries is the number of keys, the regular expressions included.
+	Entries int
+	// Depth is the number of labels of the deepest node.
+	Depth int
+	// Bytes is the approximate number of bytes held by the tries, the
+	// regular expressions and the DomainNodes, but not by the values.
+	Bytes int
+}
+
+func (s *Stats) add(o Stats) {
+	s.Nodes += o.Nodes
+	s.Leaves += o.Leaves
+	s.Entries += o.Entries
+	s.Bytes += o.Bytes
+	if o.Depth > s.Depth {
+		s.Depth = o.Depth
+	}
+}
+
+// mapBytes approximates the size of a map[string]*T of n entries: the
+// header and the buckets of 8 entries, which are grown at a load factor of
+// 6.5.
+func mapBytes(n int) int {
+	const header, bucket = 48, 8 + 8*16 + 8*8 + 8
+	buckets := 1
+	for n > buckets*13/2 {
+		buckets *= 2
+	}
+	return header + buckets*bucket
+}
+
+// Stats walks the trie and returns its size.
+func (wc *WildcardHashOf[V]) Stats() Stats {
+	var s Stats
+	wc.stats(1, &s)
+	return s
+}
+
+func (wc *WildcardHashOf[V]) stats(depth int, s *Stats) {
+	s.Bytes += int(unsafe.Sizeof(*wc)) + mapBytes(len(wc.hash)) +
+		len(wc.globs)*int(unsafe.Sizeof(labelGlob[V]{})+unsafe.Sizeof(&labelGlob[V]{}))
+
+	wc.each(func(label string, hv *HashValueOf[V]) {
+		s.Nodes++
+		s.Bytes += int(unsafe.Sizeof(*hv)) + len(label)
+		if depth > s.Depth {
+			s.Depth = depth
+		}
+		if hv.hash.Len() == 0 {
+			s.Leaves++
+		}
+		for _, typ := range []HashValueType{FullHashValueType, SingleWildcardHashValueType, WildcardHashValueType} {
+			if hv.typ&typ == typ {
+				s.Entries++
+			}
+		}
+		hv.hash.stats(depth+1, s)
+	})
+}
+
+// Stats walks the tree and returns its size.
+func (dt *DomainTreeOf[V]) Stats() Stats {
+	var s Stats
+	s.Bytes = int(unsafe.Sizeof(*dt))
+
+	count := func(_ string, dn *DomainNodeOf[V]) {
+		if dn != nil {
+			s.Bytes += int(unsafe.Sizeof(*dn)) + len(dn.key)
+		}
+	}
+
+	s.add(dt.prefix.wh.Stats())
+	s.add(dt.suffix.wh.Stats())
+	dt.prefix.wh.Walk(count)
+	dt.suffix.wh.Walk(count)
+	if dt.prefix.hasGlob {
+		s.Entries++
+		count("", dt.prefix.glob)
+	}
+
+	for _, rv := range dt.regex.regex {
+		s.Entries++
+		count("", rv.value)
+		// the instructions of the program, the compiled expression being
+		// about as large
+		s.Bytes += 2 * len(rv.prog.Inst) * int(unsafe.Sizeof(rv.prog.Inst[0]))
+	}
+
+	return s
+}
+
+// Stats returns the size of the tree (thread-safe).
+func (dt *LockedDomainTreeOf[V]) Stats() Stats {
+	dt.RLock()
+	s := dt.dt.Stats()
+	dt.RUnlock()
+	return s
+}
+
+// Stats returns the size of the current snapshot (lock-free).
+func (adt *AtomicDomainTree[V]) Stats() Stats {
+	return adt.dt.Load().Stats()
+}
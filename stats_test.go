@@ -0,0 +1,104 @@
+package domaintree
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	dt := NewDomainTreeOf[int]()
+	require.Equal(t, Stats{Bytes: dt.Stats().Bytes}, dt.Stats())
+
+	require.NoError(t, dt.Add("a.example.com", 1))
+	require.NoError(t, dt.Add("*.example.com", 2))
+	require.NoError(t, dt.Add("api-*.example.com", 3))
+	require.NoError(t, dt.Add("example.*", 4))
+	require.NoError(t, dt.Add("*", 5))
+	require.NoError(t, dt.AddRegex(`^www\.`, 6))
+
+	s := dt.Stats()
+	// com, example, a, api-* and example
+	require.Equal(t, 5, s.Nodes)
+	require.Equal(t, 3, s.Leaves)
+	require.Equal(t, 6, s.Entries)
+	require.Equal(t, 3, s.Depth)
+	require.Greater(t, s.Bytes, 0)
+
+	adt := NewAtomicDomainTreeOf[int]()
+	require.NoError(t, adt.Add("a.example.com", 1))
+	require.Equal(t, 1, adt.Stats().Entries)
+	ldt := NewLockedDomainTreeOf[int]()
+	require.NoError(t, ldt.Add("a.example.com", 1))
+	require.Equal(t, 3, ldt.Stats().Nodes)
+}
+
+func TestDelPrune(t *testing.T) {
+	dt := NewDomainTreeOf[int]()
+	require.NoError(t, dt.Add("a.b.c.example.com", 1))
+	require.NoError(t, dt.Add("example.com", 2))
+	require.Equal(t, 5, dt.Stats().Nodes)
+
+	require.True(t, dt.Del("a.b.c.example.com"))
+	require.Equal(t, 2, dt.Stats().Nodes)
+
+	// example.com keeps its children
+	require.NoError(t, dt.Add("a.example.com", 3))
+	require.True(t, dt.Del("example.com"))
+	dn, ok := dt.Lookup("a.example.com")
+	require.True(t, ok)
+	require.Equal(t, 3, dn.GetValue())
+	require.Equal(t, 3, dt.Stats().Nodes)
+
+	require.True(t, dt.Del("a.example.com"))
+	require.Equal(t, 0, dt.Stats().Nodes)
+
+	for _, tt := range []struct {
+		opts []Option
+		keys []string
+	}{
+		{nil, []string{"*.a.example.com", "api-*.b.example.com"}},
+		{[]Option{SingleLabelWildcard()}, []string{"*.a.example.com", "**.b.example.com", "www.example.*"}},
+		{[]Option{NginxMode()}, []string{".a.example.com", "www.b.example.com", "www.example.*"}},
+	} {
+		dt := NewDomainTreeOf[int](tt.opts...)
+		for i, key := range tt.keys {
+			require.NoError(t, dt.Add(key, i))
+		}
+		for _, key := range tt.keys {
+			require.True(t, dt.Del(key), key)
+		}
+		require.Equal(t, 0, dt.Stats().Nodes)
+	}
+}
+
+func TestStatsChurn(t *testing.T) {
+	dt := NewAtomicDomainTreeOf[int]()
+	require.NoError(t, dt.Add("*.example.com", 0))
+	base := dt.Stats()
+
+	r := rand.New(rand.NewSource(1))
+	var live []string
+	for i := 0; i < 20000; i++ {
+		if len(live) > 100 || (len(live) > 0 && r.Intn(2) == 0) {
+			j := r.Intn(len(live))
+			require.True(t, dt.Del(live[j]), live[j])
+			live = append(live[:j], live[j+1:]...)
+			continue
+		}
+
+		key := fmt.Sprintf("%d.tenant%d.example.com", i, r.Intn(1000))
+		if r.Intn(2) == 0 {
+			key = "*." + key
+		}
+		require.NoError(t, dt.Add(key, i))
+		live = append(live, key)
+	}
+
+	for _, key := range live {
+		require.True(t, dt.Del(key), key)
+	}
+	require.Equal(t, base, dt.Stats())
+}
@@ -2,8 +2,8 @@ package domaintree
 
 import "strings"
 
-type SuffixWildcard struct {
-	wh *WildcardHash
+type SuffixWildcardOf[V any] struct {
+	wh *WildcardHashOf[V]
 }
 
 func SuffixIndexer(s, substr string) (left, right string, ok bool) {
@@ -14,78 +14,140 @@ func SuffixIndexer(s, substr string) (left, right string, ok bool) {
 	return s, s, false
 }
 
-func NewSuffixWildcard() *SuffixWildcard {
-	return &SuffixWildcard{
-		wh: NewWildcardHash(SuffixIndexer),
+// NewSuffixWildcard returns a new SuffixWildcard which holds interface{} values.
+func NewSuffixWildcard() *SuffixWildcardOf[interface{}] {
+	return NewSuffixWildcardOf[interface{}]()
+}
+
+// NewSuffixWildcardOf returns a new SuffixWildcard which holds values of type V.
+func NewSuffixWildcardOf[V any]() *SuffixWildcardOf[V] {
+	return &SuffixWildcardOf[V]{
+		wh: NewWildcardHashOf[V](SuffixIndexer),
 	}
 }
 
-func (wc *SuffixWildcard) Del(key string) bool {
-	first := strings.Index(key, "*")
-	if first == -1 {
+func (wc *SuffixWildcardOf[V]) Del(key string) bool {
+	if !strings.HasSuffix(key, ".*") {
 		return wc.DelFull(key)
 	}
 
 	return wc.DelWildcard(key)
 }
 
-func (wc *SuffixWildcard) DelFull(key string) bool {
+func (wc *SuffixWildcardOf[V]) DelFull(key string) bool {
 	return wc.wh.DelFull(key)
 }
 
 // Walk walks the suffix tree.
-func (wc *SuffixWildcard) Walk(fn func(key string, value interface{})) {
+func (wc *SuffixWildcardOf[V]) Walk(fn func(key string, value V)) {
 	wc.wh.Walk(fn)
 }
 
-func (wc *SuffixWildcard) Lookup(key string) (interface{}, bool) {
+func (wc *SuffixWildcardOf[V]) Lookup(key string) (V, bool) {
 	hv, typ := wc.wh.Lookup(key)
 	if typ > NodeHashValueType {
-		if typ == FullHashValueType {
-			return hv.fullvalue, true
+		return hv.valueOf(typ), true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// lookupAll calls fn for every entry which matches the key in the order of
+// Lookup, until fn returns false. wildcard is the part of the key consumed by
+// the wildcard and literal is the part matched literally, both being
+// substrings of the key.
+func (wc *SuffixWildcardOf[V]) lookupAll(key string, fn func(value V, kind MatchKind, wildcard, literal string) bool) bool {
+	return wc.wh.lookupAll(key, false, func(m hashMatch[V]) bool {
+		value := m.hv.valueOf(m.typ)
+		switch {
+		case m.typ == FullHashValueType && m.glob:
+			return fn(value, LabelWildcardMatchKind, "", key)
+		case m.typ == FullHashValueType:
+			return fn(value, FullMatchKind, "", key)
+		case !m.consumed:
+			return fn(value, TrailingWildcardMatchKind, "", key)
 		}
-		return hv.wildcardvalue, true
+		return fn(value, TrailingWildcardMatchKind, m.rest, key[:len(key)-len(m.rest)-1])
+	})
+}
+
+// LookupCapture lookups the key like Lookup and also returns the part of the
+// key consumed by the wildcard and the part matched literally, e.g. "co.uk"
+// and "example" for example.* matching example.co.uk.
+func (wc *SuffixWildcardOf[V]) LookupCapture(key string) (value V, wildcard, literal string, ok bool) {
+	wc.lookupAll(key, func(v V, _ MatchKind, w, l string) bool {
+		value, wildcard, literal, ok = v, w, l, true
+		return false
+	})
+	return
+}
+
+// Add adds the key to the trie tree. ErrDuplicateKey is returned if the key
+// is already in the tree.
+func (wc *SuffixWildcardOf[V]) Add(key string, value V) error {
+	if !strings.HasSuffix(key, ".*") {
+		return wc.AddFull(key, value)
 	}
 
-	return nil, false
+	return wc.AddWildcard(key, value)
 }
 
-// Add adds the key to the trie tree.
-func (wc *SuffixWildcard) Add(key string, value interface{}) {
-	first := strings.LastIndex(key, "*")
-	if first == 0 {
-		wc.AddFull(key, value)
-		return
+// Put adds the key to the trie tree like Add, but replaces the value of the
+// key if it is already in the tree and returns the replaced value.
+func (wc *SuffixWildcardOf[V]) Put(key string, value V) (V, bool) {
+	if !strings.HasSuffix(key, ".*") {
+		return wc.wh.put(key, value, FullHashValueType)
 	}
 
-	wc.AddWildcard(key, value)
+	return wc.wh.put(key[:len(key)-2], value, WildcardHashValueType|ApexHashValueType)
 }
 
 // AddFull adds the key to the trie tree.
-func (wc *SuffixWildcard) AddFull(key string, value interface{}) {
-	wc.wh.add(key, value, FullHashValueType)
+func (wc *SuffixWildcardOf[V]) AddFull(key string, value V) error {
+	return wc.wh.insert(key, value, FullHashValueType)
 }
 
-// AddWildcard adds the suffix match like "*.abcd.com".
-func (wc *SuffixWildcard) AddWildcard(key string, value interface{}) {
-	n := strings.LastIndex(key, ".*")
-	if n >= 0 {
-		key = key[:n]
-		wc.wh.add(key, value, WildcardHashValueType)
-		return
+// AddWildcard adds the prefix match like "abcd.com.*".
+func (wc *SuffixWildcardOf[V]) AddWildcard(key string, value V) error {
+	if strings.HasSuffix(key, ".*") {
+		key = key[:len(key)-2]
+		return wc.wh.insert(key, value, WildcardHashValueType|ApexHashValueType)
 	}
-	wc.AddFull(key, value)
+	return wc.AddFull(key, value)
+}
+
+// AddSingleWildcard adds the single-label match like "abcd.com.*" which
+// matches "abcd.com.cn" but neither "abcd.com.a.b" nor "abcd.com".
+func (wc *SuffixWildcardOf[V]) AddSingleWildcard(key string, value V) error {
+	key = strings.TrimSuffix(key, ".*")
+	return wc.wh.insert(key, value, SingleWildcardHashValueType)
+}
+
+// DelSingleWildcard deletes the single-label wildcard match.
+func (wc *SuffixWildcardOf[V]) DelSingleWildcard(key string) bool {
+	key = strings.TrimSuffix(key, ".*")
+	return wc.wh.del(key, SingleWildcardHashValueType)
 }
 
 // DelWildcard deletes the wildcard match.
-func (wc *SuffixWildcard) DelWildcard(key string) bool {
-	n := strings.LastIndex(key, ".*")
-	if n >= 0 {
-		key = key[:n]
-	}
+func (wc *SuffixWildcardOf[V]) DelWildcard(key string) bool {
+	key = strings.TrimSuffix(key, ".*")
 	return wc.wh.delWildcard(key)
 }
 
-func (wc *SuffixWildcard) String() string {
+func (wc *SuffixWildcardOf[V]) copyPath(key string, owned cowSet) *SuffixWildcardOf[V] {
+	if owned[wc] {
+		wc.wh = wc.wh.copyPath(key, owned)
+		return wc
+	}
+	nwc := &SuffixWildcardOf[V]{
+		wh: wc.wh.copyPath(key, owned),
+	}
+	owned.add(nwc)
+	return nwc
+}
+
+func (wc *SuffixWildcardOf[V]) String() string {
 	return wc.wh.String()
 }
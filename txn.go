@@ -0,0 +1,179 @@
+package domaintree
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrTxnDone is returned when a transaction is used after Commit or Rollback.
+var ErrTxnDone = errors.New("transaction has already been committed or rolled back")
+
+type txnOpType uint8
+
+const (
+	txnAdd txnOpType = iota
+	txnAddRegex
+	txnPut
+	txnPutRegex
+	txnDel
+	txnDelRegex
+)
+
+type txnOp[V any] struct {
+	typ   txnOpType
+	key   string
+	value V
+}
+
+// Txn is a batch of Add/Del operations on a LockedDomainTree which becomes
+// visible to the readers in one step on Commit.
+//
+// A Txn is not safe for concurrent use.
+type Txn[V any] struct {
+	dt   *LockedDomainTreeOf[V]
+	ops  []txnOp[V]
+	done bool
+}
+
+// Begin starts a new transaction on the tree.
+func (dt *LockedDomainTreeOf[V]) Begin() *Txn[V] {
+	return &Txn[V]{dt: dt}
+}
+
+// Add records a domain to be added. An invalid key in the mode of the tree is
+// reported here and not on Commit.
+func (txn *Txn[V]) Add(key string, value V) error {
+	return txn.record(txnAdd, key, value)
+}
+
+// Put records a domain to be added or replaced. An invalid key in the mode of
+// the tree is reported here and not on Commit.
+func (txn *Txn[V]) Put(key string, value V) error {
+	return txn.record(txnPut, key, value)
+}
+
+// record records the domain, or the regular expression of NginxMode, after
+// resolving it in the mode of the tree.
+func (txn *Txn[V]) record(typ txnOpType, key string, value V) error {
+	if _, _, err := txn.dt.dt.resolve(key); err != nil {
+		return err
+	}
+	txn.ops = append(txn.ops, txnOp[V]{typ: typ, key: key, value: value})
+	return nil
+}
+
+// AddRegex records a regular expression to be added. The expression is
+// compiled right away, so an invalid one is reported here and not on Commit.
+func (txn *Txn[V]) AddRegex(key string, value V) error {
+	return txn.recordRegex(txnAddRegex, key, value)
+}
+
+// PutRegex records a regular expression to be added or replaced. The
+// expression is compiled right away, so an invalid one is reported here and
+// not on Commit.
+func (txn *Txn[V]) PutRegex(key string, value V) error {
+	return txn.recordRegex(txnPutRegex, key, value)
+}
+
+func (txn *Txn[V]) recordRegex(typ txnOpType, key string, value V) error {
+	if _, err := txn.dt.dt.compileRegex(key); err != nil {
+		return err
+	}
+	txn.ops = append(txn.ops, txnOp[V]{typ: typ, key: key, value: value})
+	return nil
+}
+
+// Del records a domain to be deleted.
+func (txn *Txn[V]) Del(key string) {
+	if txn.dt.dt.opts.nginx && strings.HasPrefix(key, "~") {
+		txn.DelRegex(key)
+		return
+	}
+	txn.ops = append(txn.ops, txnOp[V]{typ: txnDel, key: key})
+}
+
+// DelRegex records a regular expression to be deleted.
+func (txn *Txn[V]) DelRegex(key string) {
+	txn.ops = append(txn.ops, txnOp[V]{typ: txnDelRegex, key: key})
+}
+
+// Len returns the number of recorded operations.
+func (txn *Txn[V]) Len() int {
+	return len(txn.ops)
+}
+
+// Commit applies the recorded operations in order under the write lock. They
+// are applied to a copy of the tree, which replaces it only if all of them
+// succeed, so on error none of the operations is applied.
+func (txn *Txn[V]) Commit() error {
+	if txn.done {
+		return ErrTxnDone
+	}
+
+	err := txn.dt.Update(func(dt *DomainTreeOf[V]) error {
+		for i := range txn.ops {
+			if err := dt.apply(&txn.ops[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	txn.done = true
+	txn.ops = nil
+	return nil
+}
+
+// Update calls fn with a copy of the tree and replaces the tree with the copy
+// if fn returns nil, discarding it otherwise, so that the writes of fn are
+// applied all or nothing. Like in the Update of an AtomicDomainTree, every
+// node on the paths of the writes is copied once. fn must not use dt after
+// returning.
+func (dt *DomainTreeOf[V]) Update(fn func(dt *DomainTreeOf[V]) error) error {
+	ndt := *dt
+	ndt.batch = make(cowSet)
+	if err := fn(&ndt); err != nil {
+		return err
+	}
+	// the nodes copied by an Update running on dt are still its own
+	ndt.batch = dt.batch
+	*dt = ndt
+	return nil
+}
+
+// Update calls fn with a copy of the tree under the write lock and replaces
+// the tree with the copy if fn returns nil, see DomainTree.Update.
+func (dt *LockedDomainTreeOf[V]) Update(fn func(dt *DomainTreeOf[V]) error) error {
+	dt.Lock()
+	defer dt.Unlock()
+	return dt.dt.Update(fn)
+}
+
+// apply applies the operation of a transaction.
+func (dt *DomainTreeOf[V]) apply(op *txnOp[V]) error {
+	var err error
+	switch op.typ {
+	case txnAdd:
+		err = dt.Add(op.key, op.value)
+	case txnAddRegex:
+		err = dt.AddRegex(op.key, op.value)
+	case txnPut:
+		_, _, err = dt.Put(op.key, op.value)
+	case txnPutRegex:
+		_, _, err = dt.PutRegex(op.key, op.value)
+	case txnDel:
+		dt.Del(op.key)
+	case txnDelRegex:
+		dt.DelRegex(op.key)
+	}
+	return err
+}
+
+// Rollback discards the recorded operations.
+func (txn *Txn[V]) Rollback() {
+	txn.done = true
+	txn.ops = nil
+}
@@ -0,0 +1,121 @@
+package domaintree
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxnCommit(t *testing.T) {
+	dt := NewLockedDomainTreeOf[int]()
+	dt.Add("www.example.com", 1)
+	require.NoError(t, dt.AddRegex(`[0-9]\.abcd\.com`, 2))
+
+	txn := dt.Begin()
+	txn.Add("*.example.com", 3)
+	txn.Del("www.example.com")
+	txn.DelRegex(`[0-9]\.abcd\.com`)
+	require.NoError(t, txn.AddRegex(`[0-9]\.abcd\.com`, 4))
+	require.Equal(t, 4, txn.Len())
+
+	// nothing is visible before commit
+	dn, ok := dt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, 1, dn.GetValue())
+	_, ok = dt.Lookup("abcd.example.com")
+	require.False(t, ok)
+
+	require.NoError(t, txn.Commit())
+	require.Equal(t, ErrTxnDone, txn.Commit())
+
+	dn, ok = dt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, 3, dn.GetValue())
+
+	dn, ok = dt.Lookup("1.abcd.com")
+	require.True(t, ok)
+	require.Equal(t, 4, dn.GetValue())
+}
+
+func TestTxnInvalid(t *testing.T) {
+	dt := NewLockedDomainTreeOf[int]()
+	require.NoError(t, dt.AddRegex(`[0-9]\.abcd\.com`, 1))
+
+	txn := dt.Begin()
+	require.Error(t, txn.AddRegex(`[0-9`, 2))
+
+	txn.Add("www.example.com", 3)
+	require.NoError(t, txn.AddRegex(`[0-9]\.abcd\.com`, 4))
+	require.Error(t, txn.Commit())
+
+	// the failed commit applies nothing
+	_, ok := dt.Lookup("www.example.com")
+	require.False(t, ok)
+
+	txn.Rollback()
+	require.Equal(t, 0, txn.Len())
+	require.Equal(t, ErrTxnDone, txn.Commit())
+}
+
+func TestTxnNginxMode(t *testing.T) {
+	dt := NewLockedDomainTreeOf[int](NginxMode())
+	require.NoError(t, dt.Add(`~^www\.`, 1))
+
+	txn := dt.Begin()
+	require.Error(t, txn.Add("www.*.example.com", 2))
+	require.Error(t, txn.Add("~[0-9", 2))
+	txn.Del(`~^www\.`)
+	require.NoError(t, txn.Add(`~^www\.`, 3))
+	require.NoError(t, txn.Add(".example.com", 4))
+	require.NoError(t, txn.Commit())
+
+	dn, ok := dt.Lookup("www.example.org")
+	require.True(t, ok)
+	require.Equal(t, 3, dn.GetValue())
+
+	dn, ok = dt.Lookup("example.com")
+	require.True(t, ok)
+	require.Equal(t, 4, dn.GetValue())
+}
+
+func TestTxnPut(t *testing.T) {
+	dt := NewLockedDomainTreeOf[int]()
+	require.NoError(t, dt.Add("example.com", 1))
+	require.NoError(t, dt.AddRegex(`^www\.`, 1))
+
+	txn := dt.Begin()
+	require.NoError(t, txn.Add("example.com", 2))
+	require.True(t, errors.Is(txn.Commit(), ErrDuplicateKey))
+
+	txn = dt.Begin()
+	require.NoError(t, txn.AddRegex(`^www\.`, 2))
+	require.True(t, errors.Is(txn.Commit(), ErrDuplicateKey))
+
+	txn = dt.Begin()
+	require.NoError(t, txn.Put("example.com", 2))
+	require.NoError(t, txn.PutRegex(`^www\.`, 2))
+	require.NoError(t, txn.Put("*.example.org", 3))
+	require.NoError(t, txn.Commit())
+
+	dn, ok := dt.Lookup("example.com")
+	require.True(t, ok)
+	require.Equal(t, 2, dn.GetValue())
+	dn, ok = dt.Lookup("www.example.net")
+	require.True(t, ok)
+	require.Equal(t, 2, dn.GetValue())
+	dn, ok = dt.Lookup("a.example.org")
+	require.True(t, ok)
+	require.Equal(t, 3, dn.GetValue())
+
+	// the replaced regular expression keeps its priority
+	require.NoError(t, dt.AddRegexWithOptions(`^api\.`, 4, Priority(1)))
+	require.NoError(t, dt.Add("api.example.net", 5))
+	txn = dt.Begin()
+	require.NoError(t, txn.PutRegex(`^api\.`, 6))
+	require.NoError(t, txn.Commit())
+	dn, ok = dt.Lookup("api.example.net")
+	require.True(t, ok)
+	require.Equal(t, 6, dn.GetValue())
+	require.Equal(t, 1, dn.GetPriority())
+}
@@ -0,0 +1,161 @@
+package domaintree
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The errors reported by Add for the invalid or duplicated keys, wrapped in a
+// KeyError.
+var (
+	ErrEmptyLabel       = errors.New("empty label")
+	ErrLabelTooLong     = errors.New("label longer than 63 bytes")
+	ErrNameTooLong      = errors.New("name longer than 253 bytes")
+	ErrInvalidCharacter = errors.New("invalid character")
+	ErrInvalidHyphen    = errors.New("label starting or ending with a hyphen")
+	ErrInvalidWildcard  = errors.New("invalid wildcard")
+	ErrDuplicateKey     = errors.New("duplicated key")
+)
+
+const (
+	maxLabelLen = 63
+	maxNameLen  = 253
+)
+
+// KeyError records the key which failed to be added and the reason.
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s: %q", e.Err, e.Key)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// Strict makes Add and Put check the keys with Validate and reject the
+// invalid ones with a KeyError instead of storing them.
+func Strict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// Validate checks the key against the label rules of RFC 1035 and RFC 1123
+// and the wildcard grammar of the mode of the tree, like Add does in Strict
+// mode, without adding it.
+//
+// A label holds letters, digits and hyphens, which neither start nor end it,
+// and has at most 63 bytes. The name has at most 253 bytes. The wildcards
+// are * alone, a leading *. or **., a trailing .* or .**, and the patterns
+// within the labels like api-*.example.com, which are only allowed in a
+// full match, so *.*.com and exa**mple.com are rejected.
+func (dt *DomainTreeOf[V]) Validate(key string) error {
+	if dt.opts.nginx {
+		kind, name, err := dt.parseNginxName(key)
+		if err == nil {
+			err = dt.validateNginxName(kind, name)
+		}
+		if errors.Is(err, errInvalidServerName) {
+			err = ErrInvalidWildcard
+		}
+		if err != nil {
+			return &KeyError{Key: key, Err: err}
+		}
+		return nil
+	}
+
+	ckey, err := dt.canonicalKey(key)
+	if err == nil {
+		err = validateKey(ckey)
+	}
+	if err != nil {
+		return &KeyError{Key: key, Err: err}
+	}
+	return nil
+}
+
+// validateNginxName checks the parsed nginx server_name.
+func (dt *DomainTreeOf[V]) validateNginxName(kind nginxNameKind, name string) error {
+	switch {
+	case kind == nginxRegexName:
+		_, err := dt.compileNginxRegex(name)
+		return err
+	case kind == nginxExactName && (name == "" || name == "_"):
+		return nil
+	}
+	return validateLabels(name, false)
+}
+
+// validateKey checks the canonical key outside of NginxMode.
+func validateKey(key string) error {
+	if key == "*" {
+		return nil
+	}
+
+	labels := strings.Split(key, ".")
+	first, last := 0, len(labels)
+	leading := labels[first] == "*" || labels[first] == "**"
+	trailing := labels[last-1] == "*" || labels[last-1] == "**"
+	switch {
+	case leading && trailing:
+		return ErrInvalidWildcard
+	case leading:
+		first++
+	case trailing:
+		last--
+	}
+
+	name := strings.Join(labels[first:last], ".")
+	return validateLabels(name, !leading && !trailing)
+}
+
+// validateLabels checks the labels of the name, which may hold patterns if
+// glob is true.
+func validateLabels(name string, glob bool) error {
+	if len(name) > maxNameLen {
+		return ErrNameTooLong
+	}
+
+	for {
+		label, rest, more := strings.Cut(name, ".")
+		if err := validateLabel(label, glob); err != nil {
+			return err
+		}
+		if !more {
+			return nil
+		}
+		name = rest
+	}
+}
+
+func validateLabel(label string, glob bool) error {
+	if label == "" {
+		return ErrEmptyLabel
+	}
+	if len(label) > maxLabelLen {
+		return ErrLabelTooLong
+	}
+
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-':
+		case c == '*':
+			if !glob || (i > 0 && label[i-1] == '*') {
+				return ErrInvalidWildcard
+			}
+		default:
+			return ErrInvalidCharacter
+		}
+	}
+
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return ErrInvalidHyphen
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package domaintree
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	dt := NewDomainTreeOf[int]()
+	for _, tt := range []struct {
+		key string
+		err error
+	}{
+		{"example.com", nil},
+		{"WWW.Example.com", nil},
+		{"1.2.3.4", nil},
+		{"*", nil},
+		{"*.example.com", nil},
+		{"**.example.com", nil},
+		{"example.*", nil},
+		{"example.**", nil},
+		{"api-*.example.com", nil},
+		{"api.*.example.com", nil},
+		{"exa*mple.com", nil},
+		{strings.Repeat("a", 63) + ".com", nil},
+		{"", ErrEmptyLabel},
+		{"a..b", ErrEmptyLabel},
+		{".example.com", ErrEmptyLabel},
+		{"example.com.", ErrEmptyLabel},
+		{"*.", ErrEmptyLabel},
+		{strings.Repeat("a", 64) + ".com", ErrLabelTooLong},
+		{strings.Repeat("a.", 127) + "com", ErrNameTooLong},
+		{"exa_mple.com", ErrInvalidCharacter},
+		{"bücher.example", ErrInvalidCharacter},
+		{"-example.com", ErrInvalidHyphen},
+		{"example-.com", ErrInvalidHyphen},
+		{"*.*.com", ErrInvalidWildcard},
+		{"*.example.*", ErrInvalidWildcard},
+		{"**", ErrInvalidWildcard},
+		{"*.api-*.com", ErrInvalidWildcard},
+		{"exa**mple.com", ErrInvalidWildcard},
+		{"api.**.example.com", ErrInvalidWildcard},
+	} {
+		err := dt.Validate(tt.key)
+		if tt.err == nil {
+			require.NoError(t, err, tt.key)
+			continue
+		}
+		require.True(t, errors.Is(err, tt.err), tt.key)
+
+		var kerr *KeyError
+		require.True(t, errors.As(err, &kerr), tt.key)
+		require.Equal(t, tt.key, kerr.Key)
+	}
+
+	// the key is checked after the normalization
+	dt = NewDomainTreeOf[int](IgnoreTrailingDot(), IDNA())
+	require.NoError(t, dt.Validate("example.com."))
+	require.NoError(t, dt.Validate("*.bücher.example"))
+	require.True(t, errors.Is(dt.Validate("bü*.example"), errIDNAWildcard))
+}
+
+func TestValidateNginx(t *testing.T) {
+	dt := NewDomainTreeOf[int](NginxMode())
+	for _, key := range []string{"", `""`, "_", "example.com", ".example.com", "*.example.com", "mail.*", `~^www\d+\.example\.net$`} {
+		require.NoError(t, dt.Validate(key), key)
+	}
+	require.True(t, errors.Is(dt.Validate("www.*.example.com"), ErrInvalidWildcard))
+	require.True(t, errors.Is(dt.Validate("*.example.*"), ErrInvalidWildcard))
+	require.True(t, errors.Is(dt.Validate("a..example.com"), ErrEmptyLabel))
+	require.Error(t, dt.Validate("~^(www"))
+}
+
+func TestStrict(t *testing.T) {
+	dt := NewDomainTreeOf[int](Strict())
+	require.NoError(t, dt.Add("example.com", 1))
+	require.NoError(t, dt.Add("*.example.com", 2))
+	require.NoError(t, dt.Add("*", 3))
+	require.NoError(t, dt.Add("api-*.example.com", 4))
+
+	require.True(t, errors.Is(dt.Add("a..b", 5), ErrEmptyLabel))
+	require.True(t, errors.Is(dt.Add("*.*.com", 5), ErrInvalidWildcard))
+	require.True(t, errors.Is(dt.Add("example.com", 5), ErrDuplicateKey))
+	require.True(t, errors.Is(dt.Add("*.example.com", 5), ErrDuplicateKey))
+	require.True(t, errors.Is(dt.Add("**.example.com", 5), ErrDuplicateKey))
+	require.True(t, errors.Is(dt.Add("*", 5), ErrDuplicateKey))
+	require.True(t, errors.Is(dt.Add("api-*.example.com", 5), ErrDuplicateKey))
+
+	dn, ok := dt.Lookup("example.com")
+	require.True(t, ok)
+	require.Equal(t, 1, dn.GetValue())
+	dn, ok = dt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, 2, dn.GetValue())
+	dn, ok = dt.Lookup("a..b")
+	require.True(t, ok)
+	require.Equal(t, 3, dn.GetValue())
+
+	// without the option the invalid keys are stored
+	dt = NewDomainTreeOf[int]()
+	require.NoError(t, dt.Add("a..b", 1))
+	dn, ok = dt.Lookup("a..b")
+	require.True(t, ok)
+	require.Equal(t, 1, dn.GetValue())
+}
+
+func TestStrictNginx(t *testing.T) {
+	dt := NewDomainTreeOf[int](NginxMode(), Strict())
+	require.NoError(t, dt.Add(".example.com", 1))
+	require.True(t, errors.Is(dt.Add("*.example.com", 2), ErrDuplicateKey))
+	require.True(t, errors.Is(dt.Add("a..example.com", 2), ErrEmptyLabel))
+	require.NoError(t, dt.Add("mail.*", 3))
+	require.True(t, errors.Is(dt.Add("MAIL.*", 3), ErrDuplicateKey))
+}
+
+func TestStrictTxn(t *testing.T) {
+	dt := NewLockedDomainTreeOf[int](Strict())
+	require.NoError(t, dt.Add("example.com", 1))
+
+	txn := dt.Begin()
+	require.True(t, errors.Is(txn.Add("a..b", 2), ErrEmptyLabel))
+	require.NoError(t, txn.Add("example.com", 2))
+	require.True(t, errors.Is(txn.Commit(), ErrDuplicateKey))
+
+	txn = dt.Begin()
+	txn.Del("example.com")
+	require.NoError(t, txn.Add("example.com", 2))
+	require.NoError(t, txn.Add("*.example.com", 3))
+	require.NoError(t, txn.Commit())
+
+	txn = dt.Begin()
+	require.NoError(t, txn.Add("www.example.com", 4))
+	require.NoError(t, txn.Add("www.example.com", 5))
+	require.True(t, errors.Is(txn.Commit(), ErrDuplicateKey))
+
+	dn, ok := dt.Lookup("example.com")
+	require.True(t, ok)
+	require.Equal(t, 2, dn.GetValue())
+	dn, ok = dt.Lookup("www.example.com")
+	require.True(t, ok)
+	require.Equal(t, 3, dn.GetValue())
+}
@@ -0,0 +1,150 @@
+package domaintree
+
+import (
+	"errors"
+	"sort"
+)
+
+// Entry is a key of the tree reported by WalkEntries.
+type Entry[V any] struct {
+	// Key is the key as it was added, in the ASCII form in IDNA mode.
+	Key      string
+	Kind     MatchKind
+	Value    V
+	Priority int
+}
+
+// WalkOrder is the order of the entries reported by WalkEntries.
+type WalkOrder uint8
+
+const (
+	// HierarchicalOrder reports the glob * first, then the keys from the top
+	// level domain down with the parents before their children, e.g.
+	// example.com, *.example.com and www.example.com, then the trailing
+	// wildcards from the first label and the regular expressions in
+	// insertion order. The labels of the same parent are sorted, the
+	// patterns like api-* coming last.
+	HierarchicalOrder WalkOrder = iota
+	// LexicalOrder reports the keys sorted as strings.
+	LexicalOrder
+)
+
+// SkipAll is returned by the function of WalkEntries to stop the walk
+// without an error.
+var SkipAll = errors.New("skip all the entries")
+
+// WalkEntries calls fn for every entry of the tree in the order. The walk
+// stops at the first error returned by fn, which WalkEntries returns unless
+// it is SkipAll.
+func (dt *DomainTreeOf[V]) WalkEntries(order WalkOrder, fn func(e Entry[V]) error) error {
+	var err error
+	yield := func(e Entry[V]) bool {
+		err = fn(e)
+		return err == nil
+	}
+
+	if order == LexicalOrder {
+		var entries []Entry[V]
+		dt.entries(func(e Entry[V]) bool {
+			entries = append(entries, e)
+			return true
+		})
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Key < entries[j].Key
+		})
+		for _, e := range entries {
+			if !yield(e) {
+				break
+			}
+		}
+	} else {
+		dt.entries(yield)
+	}
+
+	if err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+// entries calls fn for every entry in HierarchicalOrder until fn returns
+// false.
+func (dt *DomainTreeOf[V]) entries(fn func(e Entry[V]) bool) bool {
+	return dt.nodes(func(dn *DomainNodeOf[V], kind MatchKind) bool {
+		return fn(Entry[V]{Key: dt.entryKey(dn, kind), Kind: kind, Value: dn.value, Priority: dn.priority})
+	})
+}
+
+// walkNodes calls fn for every node in HierarchicalOrder with the key of its
+// entry.
+func (dt *DomainTreeOf[V]) walkNodes(fn func(key string, dn *DomainNodeOf[V])) {
+	dt.nodes(func(dn *DomainNodeOf[V], kind MatchKind) bool {
+		fn(dt.entryKey(dn, kind), dn)
+		return true
+	})
+}
+
+// entryKey returns the key of the entry of the node, in the ASCII form in
+// IDNA mode.
+func (dt *DomainTreeOf[V]) entryKey(dn *DomainNodeOf[V], kind MatchKind) string {
+	if dt.opts.idna && kind != RegexMatchKind {
+		if k, err := dt.canonicalKey(dn.key); err == nil {
+			return k
+		}
+	}
+	return dn.key
+}
+
+// nodes calls fn for the node of every entry and its kind in
+// HierarchicalOrder until fn returns false.
+func (dt *DomainTreeOf[V]) nodes(fn func(dn *DomainNodeOf[V], kind MatchKind) bool) bool {
+	if dt.prefix.hasGlob && !fn(dt.prefix.glob, GlobMatchKind) {
+		return false
+	}
+
+	ok := dt.prefix.wh.walkSorted(nil, false, func(_ []string, hv *HashValueOf[*DomainNodeOf[V]], typ HashValueType, glob bool) bool {
+		kind := LeadingWildcardMatchKind
+		switch {
+		case typ == FullHashValueType && glob:
+			kind = LabelWildcardMatchKind
+		case typ == FullHashValueType:
+			kind = FullMatchKind
+		}
+		return fn(hv.valueOf(typ), kind)
+	})
+	if !ok {
+		return false
+	}
+
+	ok = dt.suffix.wh.walkSorted(nil, false, func(_ []string, hv *HashValueOf[*DomainNodeOf[V]], typ HashValueType, _ bool) bool {
+		kind := TrailingWildcardMatchKind
+		if typ == FullHashValueType {
+			kind = FullMatchKind
+		}
+		return fn(hv.valueOf(typ), kind)
+	})
+	if !ok {
+		return false
+	}
+
+	for _, rv := range dt.regex.regex {
+		if !fn(rv.value, RegexMatchKind) {
+			return false
+		}
+	}
+	return true
+}
+
+// WalkEntries walks the entries of the tree in the order (thread-safe). The
+// tree is read locked during the walk, so fn must not modify it.
+func (dt *LockedDomainTreeOf[V]) WalkEntries(order WalkOrder, fn func(e Entry[V]) error) error {
+	dt.RLock()
+	defer dt.RUnlock()
+	return dt.dt.WalkEntries(order, fn)
+}
+
+// WalkEntries walks the entries of the current snapshot in the order
+// (lock-free).
+func (adt *AtomicDomainTree[V]) WalkEntries(order WalkOrder, fn func(e Entry[V]) error) error {
+	return adt.dt.Load().WalkEntries(order, fn)
+}
@@ -0,0 +1,131 @@
+package domaintree
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalkEntries(t *testing.T) {
+	dt := NewDomainTreeOf[int]()
+	for i, key := range []string{
+		`^www\.`,
+		"www.example.com",
+		"*.example.com",
+		"api-*.example.com",
+		"a.example.com",
+		"example.org",
+		"www.example.*",
+		"*",
+		"com",
+	} {
+		if key[0] == '^' {
+			require.NoError(t, dt.AddRegex(key, i))
+			continue
+		}
+		require.NoError(t, dt.AddWithOptions(key, i, Priority(i)))
+	}
+
+	var entries []Entry[int]
+	require.NoError(t, dt.WalkEntries(HierarchicalOrder, func(e Entry[int]) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	require.Equal(t, []Entry[int]{
+		{"*", GlobMatchKind, 7, 7},
+		{"com", FullMatchKind, 8, 8},
+		{"*.example.com", LeadingWildcardMatchKind, 2, 2},
+		{"a.example.com", FullMatchKind, 4, 4},
+		{"www.example.com", FullMatchKind, 1, 1},
+		{"api-*.example.com", LabelWildcardMatchKind, 3, 3},
+		{"example.org", FullMatchKind, 5, 5},
+		{"www.example.*", TrailingWildcardMatchKind, 6, 6},
+		{`^www\.`, RegexMatchKind, 0, 0},
+	}, entries)
+
+	var keys []string
+	require.NoError(t, dt.WalkEntries(LexicalOrder, func(e Entry[int]) error {
+		keys = append(keys, e.Key)
+		return nil
+	}))
+	require.Equal(t, []string{"*", "*.example.com", "^www\\.", "a.example.com", "api-*.example.com", "com", "example.org", "www.example.*", "www.example.com"}, keys)
+
+	// early termination
+	for _, order := range []WalkOrder{HierarchicalOrder, LexicalOrder} {
+		n := 0
+		require.NoError(t, dt.WalkEntries(order, func(e Entry[int]) error {
+			n++
+			if n == 3 {
+				return SkipAll
+			}
+			return nil
+		}))
+		require.Equal(t, 3, n)
+
+		errStop := errors.New("stop")
+		n = 0
+		require.Equal(t, errStop, dt.WalkEntries(order, func(e Entry[int]) error {
+			n++
+			return errStop
+		}))
+		require.Equal(t, 1, n)
+	}
+
+	// Walk reports the keys as they were added in the same order
+	var walked []string
+	dt.Walk(func(key string, _ int) {
+		walked = append(walked, key)
+	})
+	require.Equal(t, []string{"*", "com", "*.example.com", "a.example.com", "www.example.com", "api-*.example.com", "example.org", "www.example.*", `^www\.`}, walked)
+}
+
+func TestWalkEntriesNginx(t *testing.T) {
+	dt := NewAtomicDomainTreeOf[int](NginxMode(), IDNA())
+	require.NoError(t, dt.Add(".bücher.example", 1))
+	require.NoError(t, dt.Add("*.example.com", 2))
+	require.NoError(t, dt.Add("mail.*", 3))
+	require.NoError(t, dt.Add(`~^www\d+\.example\.net$`, 4))
+
+	var entries []Entry[int]
+	require.NoError(t, dt.WalkEntries(HierarchicalOrder, func(e Entry[int]) error {
+		entries = append(entries, e)
+		return nil
+	}))
+	require.Equal(t, []Entry[int]{
+		{Key: "*.example.com", Kind: LeadingWildcardMatchKind, Value: 2},
+		{Key: ".xn--bcher-kva.example", Kind: LeadingWildcardMatchKind, Value: 1},
+		{Key: "mail.*", Kind: TrailingWildcardMatchKind, Value: 3},
+		{Key: `~^www\d+\.example\.net$`, Kind: RegexMatchKind, Value: 4},
+	}, entries)
+
+	ldt := NewLockedDomainTreeOf[int]()
+	require.NoError(t, ldt.Add("example.com", 1))
+	require.NoError(t, ldt.WalkEntries(LexicalOrder, func(e Entry[int]) error {
+		require.Equal(t, "example.com", e.Key)
+		return nil
+	}))
+}
+
+func TestWildcardWalk(t *testing.T) {
+	pwc := NewPrefixWildcardOf[int]()
+	require.NoError(t, pwc.AddFull("www.example.com", 1))
+	require.NoError(t, pwc.AddWildcard("*.example.com", 2))
+	require.NoError(t, pwc.AddSingleWildcard("*.example.org", 3))
+	require.NoError(t, pwc.wh.insert("example.net", 4, WildcardHashValueType))
+
+	var keys []string
+	pwc.Walk(func(key string, _ int) {
+		keys = append(keys, key)
+	})
+	require.Equal(t, []string{"*.example.com", "www.example.com", "**.example.net", "*.example.org"}, keys)
+
+	swc := NewSuffixWildcardOf[int]()
+	require.NoError(t, swc.AddWildcard("www.example.*", 1))
+	require.NoError(t, swc.AddSingleWildcard("mail.*", 2))
+	keys = nil
+	swc.Walk(func(key string, _ int) {
+		keys = append(keys, key)
+	})
+	require.Equal(t, []string{"mail.*", "www.example.*"}, keys)
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 )
 
 type StringIndexer func(s, substr string) (left, right string, ok bool)
@@ -15,292 +17,653 @@ var (
 	NodeHashValueType     HashValueType = 0x00
 	FullHashValueType     HashValueType = 0x01
 	WildcardHashValueType HashValueType = 0x02
+	// ApexHashValueType flags a wildcard which also matches the domain itself,
+	// e.g. *.example.com matching example.com.
+	ApexHashValueType HashValueType = 0x04
+	// SingleWildcardHashValueType is a wildcard which matches exactly one
+	// label, e.g. *.example.com matching a.example.com but not a.b.example.com.
+	SingleWildcardHashValueType HashValueType = 0x08
 )
 
 func (hvt HashValueType) String() string {
-	switch hvt {
-	case NodeHashValueType:
+	if hvt == NodeHashValueType {
 		return "."
-	case FullHashValueType:
-		return "="
-	case WildcardHashValueType:
-		return "*"
-	case FullHashValueType | WildcardHashValueType:
-		return "=*"
 	}
-	return "unknown"
+
+	var buf bytes.Buffer
+	if hvt&FullHashValueType == FullHashValueType {
+		buf.WriteByte('=')
+	}
+	if hvt&SingleWildcardHashValueType == SingleWildcardHashValueType {
+		buf.WriteByte('?')
+	}
+	if hvt&WildcardHashValueType == WildcardHashValueType {
+		buf.WriteByte('*')
+	}
+	if buf.Len() == 0 {
+		return "unknown"
+	}
+	return buf.String()
 }
 
-// HashValue returns the hashvalue.
-type HashValue struct {
+// HashValueOf returns the hashvalue.
+type HashValueOf[V any] struct {
 	typ           HashValueType
-	fullvalue     interface{}
-	wildcardvalue interface{}
-	hash          *WildcardHash
+	fullvalue     V
+	singlevalue   V
+	wildcardvalue V
+	hash          *WildcardHashOf[V]
 }
 
 // GetValue returns the value.
-func (hv *HashValue) GetValue() interface{} {
+func (hv *HashValueOf[V]) GetValue() V {
 	if hv.typ&FullHashValueType == FullHashValueType {
 		return hv.fullvalue
 	}
 
+	if hv.typ&SingleWildcardHashValueType == SingleWildcardHashValueType {
+		return hv.singlevalue
+	}
+
 	if hv.typ&WildcardHashValueType == WildcardHashValueType {
 		return hv.wildcardvalue
 	}
 
-	return nil
+	var zero V
+	return zero
 }
 
 // GetFullValue gets the full value.
-func (hv *HashValue) GetFullValue() interface{} { return hv.fullvalue }
+func (hv *HashValueOf[V]) GetFullValue() V { return hv.fullvalue }
+
+// GetSingleWildcardValue gets the single-label wildcard value.
+func (hv *HashValueOf[V]) GetSingleWildcardValue() V { return hv.singlevalue }
 
 // GetWildcardValue gets the wildcard value.
-func (hv *HashValue) GetWildcardValue() interface{} { return hv.wildcardvalue }
+func (hv *HashValueOf[V]) GetWildcardValue() V { return hv.wildcardvalue }
 
 // GetType returns the type.
-func (hv *HashValue) GetType() HashValueType { return hv.typ }
+func (hv *HashValueOf[V]) GetType() HashValueType { return hv.typ }
 
 // String returns the string representation.
-func (hv *HashValue) String() string {
-	switch hv.typ {
-	case NodeHashValueType:
+func (hv *HashValueOf[V]) String() string {
+	if hv.typ == NodeHashValueType {
 		return hv.typ.String()
+	}
+
+	var values []string
+	if hv.typ&FullHashValueType == FullHashValueType {
+		values = append(values, fmt.Sprintf("%+v", hv.fullvalue))
+	}
+	if hv.typ&SingleWildcardHashValueType == SingleWildcardHashValueType {
+		values = append(values, fmt.Sprintf("%+v", hv.singlevalue))
+	}
+	if hv.typ&WildcardHashValueType == WildcardHashValueType {
+		values = append(values, fmt.Sprintf("%+v", hv.wildcardvalue))
+	}
+	return fmt.Sprintf("%s[%s]", hv.typ, strings.Join(values, "-"))
+}
+
+// valueOf returns the value of the type returned by WildcardHash.Lookup.
+func (hv *HashValueOf[V]) valueOf(typ HashValueType) V {
+	switch typ {
 	case FullHashValueType:
-		return fmt.Sprintf("%s[%+v]", hv.typ, hv.fullvalue)
-	case WildcardHashValueType:
-		return fmt.Sprintf("%s[%+v]", hv.typ, hv.wildcardvalue)
-	case FullHashValueType | WildcardHashValueType:
-		return fmt.Sprintf("%s[%+v-%v]", hv.typ, hv.fullvalue, hv.wildcardvalue)
+		return hv.fullvalue
+	case SingleWildcardHashValueType:
+		return hv.singlevalue
 	}
-	return "unknown"
+	return hv.wildcardvalue
 }
 
-// WildcardHash represents the trie tree which support prefix wildcard
+// set sets the full, single-label wildcard or wildcard value according to typ.
+func (hv *HashValueOf[V]) set(value V, typ HashValueType) {
+	if typ&WildcardHashValueType == WildcardHashValueType {
+		hv.typ = hv.typ&^ApexHashValueType | typ
+		hv.wildcardvalue = value
+	} else if typ == SingleWildcardHashValueType {
+		hv.typ |= typ
+		hv.singlevalue = value
+	} else if typ == FullHashValueType {
+		hv.typ |= typ
+		hv.fullvalue = value
+	}
+}
+
+// WildcardHashOf represents the trie tree which support prefix wildcard
 //
 // *.example.com
 // example.com
 // abcd.example.com
-type WildcardHash struct {
-	glob    interface{}
+// api-*.example.com
+type WildcardHashOf[V any] struct {
 	indexer StringIndexer
-	hash    map[string]*HashValue
+	hash    map[string]*HashValueOf[V]
+	globs   []*labelGlob[V]
+}
+
+// labelGlob is a child whose label is a pattern like api-* or *-canary, where
+// the * matches any part of exactly one label.
+type labelGlob[V any] struct {
+	pattern string
+	literal int // the number of literal bytes of the pattern
+	hv      *HashValueOf[V]
+}
+
+// NewWildcardHash returns a new WildcardHash which holds interface{} values.
+func NewWildcardHash(indexer StringIndexer) *WildcardHashOf[interface{}] {
+	return NewWildcardHashOf[interface{}](indexer)
 }
 
-// NewWildcardHash returns a new WildcardHash.
-func NewWildcardHash(indexer StringIndexer) *WildcardHash {
-	return &WildcardHash{
+// NewWildcardHashOf returns a new WildcardHash which holds values of type V.
+func NewWildcardHashOf[V any](indexer StringIndexer) *WildcardHashOf[V] {
+	return &WildcardHashOf[V]{
 		indexer: indexer,
-		hash:    make(map[string]*HashValue, 4),
+		hash:    make(map[string]*HashValueOf[V], 4),
 	}
 }
 
-func (wc *WildcardHash) delWildcard(key string) bool {
-	sub, remaining, success := wc.indexer(key, ".")
+// isLabelGlob reports whether the label is a pattern.
+func isLabelGlob(label string) bool {
+	return strings.IndexByte(label, '*') >= 0
+}
 
-	hv, ok := wc.hash[sub]
-	if ok {
-		if success {
-			hv.hash.delWildcard(remaining)
-			if hv.hash.Len() == 0 {
-				// TODO(detailyang): cleanup self node
-			}
-			return true
+// matchLabel reports whether the label matches the pattern, where * matches
+// any sequence of bytes.
+func matchLabel(pattern, label string) bool {
+	star, next := -1, 0
+	p, l := 0, 0
+	for l < len(label) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, l
+			p++
+		case p < len(pattern) && pattern[p] == label[l]:
+			p++
+			l++
+		case star >= 0:
+			next++
+			p, l = star+1, next
+		default:
+			return false
 		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
 
-		if hv.typ&WildcardHashValueType == WildcardHashValueType {
-			hv.wildcardvalue = nil
-			hv.typ ^= WildcardHashValueType
+// child returns the child of the label, which may be a pattern.
+func (wc *WildcardHashOf[V]) child(label string) *HashValueOf[V] {
+	if !isLabelGlob(label) {
+		return wc.hash[label]
+	}
+	for _, g := range wc.globs {
+		if g.pattern == label {
+			return g.hv
 		}
+	}
+	return nil
+}
 
-		if hv.typ&FullHashValueType == FullHashValueType {
-			hv.fullvalue = nil
+// setChild sets the child of the label. The patterns are kept from the most
+// specific one, i.e. the one with the most literal bytes, to the least
+// specific one, in insertion order for the same specificity.
+func (wc *WildcardHashOf[V]) setChild(label string, hv *HashValueOf[V]) {
+	if !isLabelGlob(label) {
+		if wc.hash == nil {
+			// a leaf restored from a snapshot
+			wc.hash = make(map[string]*HashValueOf[V], 4)
+		}
+		wc.hash[label] = hv
+		return
+	}
+
+	for _, g := range wc.globs {
+		if g.pattern == label {
+			g.hv = hv
+			return
 		}
+	}
+
+	ng := &labelGlob[V]{
+		pattern: label,
+		literal: len(label) - strings.Count(label, "*"),
+		hv:      hv,
+	}
+	i := len(wc.globs)
+	for i > 0 && wc.globs[i-1].literal < ng.literal {
+		i--
+	}
+	wc.globs = append(wc.globs, nil)
+	copy(wc.globs[i+1:], wc.globs[i:])
+	wc.globs[i] = ng
+}
 
-		if hv.typ == NodeHashValueType {
-			delete(wc.hash, sub)
+// delChild deletes the child of the label.
+func (wc *WildcardHashOf[V]) delChild(label string) {
+	if !isLabelGlob(label) {
+		delete(wc.hash, label)
+		return
+	}
+	for i, g := range wc.globs {
+		if g.pattern == label {
+			wc.globs = append(wc.globs[:i:i], wc.globs[i+1:]...)
+			return
 		}
-		return true
 	}
+}
 
-	return false
+// delWildcard deletes the wildcard match.
+func (wc *WildcardHashOf[V]) delWildcard(key string) bool {
+	return wc.del(key, WildcardHashValueType)
 }
 
 // DelFull deletes the full match.
-func (wc *WildcardHash) DelFull(key string) bool {
+func (wc *WildcardHashOf[V]) DelFull(key string) bool {
+	return wc.del(key, FullHashValueType)
+}
+
+// del deletes the value of the type and the nodes left empty.
+func (wc *WildcardHashOf[V]) del(key string, typ HashValueType) bool {
+	_, ok := wc.remove(key, typ)
+	return ok
+}
+
+// remove deletes the value of the type stored for exactly the key, leaving the
+// values of the other types alone, and the nodes left empty. It returns the
+// deleted value.
+func (wc *WildcardHashOf[V]) remove(key string, typ HashValueType) (V, bool) {
 	sub, remaining, success := wc.indexer(key, ".")
 
-	hv, ok := wc.hash[sub]
-	if ok {
-		if success {
-			hv.hash.DelFull(remaining)
-			if hv.hash.Len() == 0 {
-				// TODO(detailyang): cleanup self node
-			}
-			return true
-		}
+	hv := wc.child(sub)
+	if hv == nil {
+		var zero V
+		return zero, false
+	}
 
-		if hv.typ&FullHashValueType == FullHashValueType {
-			hv.fullvalue = nil
-			hv.typ ^= FullHashValueType
-		}
+	if success {
+		value, ok := hv.hash.remove(remaining, typ)
+		wc.prune(sub, hv)
+		return value, ok
+	}
 
-		if hv.typ&WildcardHashValueType == WildcardHashValueType {
-			hv.wildcardvalue = nil
-		}
+	typ &^= ApexHashValueType
+	if hv.typ&typ != typ {
+		var zero V
+		return zero, false
+	}
 
-		if hv.typ == NodeHashValueType {
-			delete(wc.hash, sub)
-		}
-		return true
+	value := hv.valueOf(typ)
+	var zero V
+	switch typ {
+	case FullHashValueType:
+		hv.fullvalue = zero
+	case SingleWildcardHashValueType:
+		hv.singlevalue = zero
+	case WildcardHashValueType:
+		hv.wildcardvalue = zero
+		typ |= ApexHashValueType
 	}
+	hv.typ &^= typ
 
-	return false
+	wc.prune(sub, hv)
+	return value, true
 }
 
-func (wc *WildcardHash) add(key string, value interface{}, typ HashValueType) {
+// prune deletes the child of the label once it holds neither a value nor a
+// child, so that the deletes called on the way back up from a leaf remove
+// every ancestor left empty.
+func (wc *WildcardHashOf[V]) prune(label string, hv *HashValueOf[V]) {
+	if hv.typ == NodeHashValueType && hv.hash.Len() == 0 {
+		wc.delChild(label)
+	}
+}
+
+// get returns the value of the type stored for exactly the key, the patterns
+// of the key being compared literally rather than matched.
+func (wc *WildcardHashOf[V]) get(key string, typ HashValueType) (V, bool) {
 	sub, remaining, success := wc.indexer(key, ".")
 
-	hv, ok := wc.hash[sub]
-	if ok {
+	hv := wc.child(sub)
+	if hv == nil {
+		var zero V
+		return zero, false
+	}
+
+	if success {
+		return hv.hash.get(remaining, typ)
+	}
+
+	typ &^= ApexHashValueType
+	if hv.typ&typ != typ {
+		var zero V
+		return zero, false
+	}
+	return hv.valueOf(typ), true
+}
+
+// insert adds the value of the type unless the key already holds one.
+func (wc *WildcardHashOf[V]) insert(key string, value V, typ HashValueType) error {
+	if _, ok := wc.get(key, typ); ok {
+		return ErrDuplicateKey
+	}
+	wc.add(key, value, typ)
+	return nil
+}
+
+// put adds the value of the type and returns the value it replaces, if any.
+func (wc *WildcardHashOf[V]) put(key string, value V, typ HashValueType) (V, bool) {
+	prev, ok := wc.get(key, typ)
+	wc.add(key, value, typ)
+	return prev, ok
+}
+
+func (wc *WildcardHashOf[V]) add(key string, value V, typ HashValueType) {
+	sub, remaining, success := wc.indexer(key, ".")
+
+	hv := wc.child(sub)
+	if hv != nil {
 		if success {
 			hv.hash.add(remaining, value, typ)
 			return
 		}
 
-		hv.typ |= typ
-		if typ == WildcardHashValueType {
-			hv.wildcardvalue = value
-		} else if typ == FullHashValueType {
-			hv.fullvalue = value
-		}
-
+		hv.set(value, typ)
 		return
 	}
 
-	wch := NewWildcardHash(wc.indexer)
-	nhv := &HashValue{ // intermediate node
+	wch := NewWildcardHashOf[V](wc.indexer)
+	nhv := &HashValueOf[V]{ // intermediate node
 		hash: wch,
 	}
 
-	wc.hash[sub] = nhv
+	wc.setChild(sub, nhv)
 	if !success {
-		nhv.typ |= typ
-		if typ == WildcardHashValueType {
-			nhv.wildcardvalue = value
-		} else if typ == FullHashValueType {
-			nhv.fullvalue = value
-		}
+		nhv.set(value, typ)
 		return
 	}
 
 	wch.add(remaining, value, typ)
 }
 
+// copyPath returns a copy of wc which shares every node with wc except the ones
+// on the path of key, so the copy can be modified along that path without
+// affecting the readers of wc. The nodes in owned, copied earlier in the same
+// batch, are not copied again and the copies are added to owned.
+func (wc *WildcardHashOf[V]) copyPath(key string, owned cowSet) *WildcardHashOf[V] {
+	nwc := wc
+	if !owned[wc] {
+		nwc = &WildcardHashOf[V]{
+			indexer: wc.indexer,
+			hash:    make(map[string]*HashValueOf[V], len(wc.hash)+1),
+		}
+		for k, v := range wc.hash {
+			nwc.hash[k] = v
+		}
+		for _, g := range wc.globs {
+			ng := *g
+			nwc.globs = append(nwc.globs, &ng)
+		}
+		owned.add(nwc)
+	}
+
+	sub, remaining, success := wc.indexer(key, ".")
+	hv := nwc.child(sub)
+	if hv == nil {
+		return nwc
+	}
+
+	nhv := hv
+	if !owned[hv] {
+		c := *hv
+		nhv = &c
+		owned.add(nhv)
+	}
+	if success {
+		nhv.hash = hv.hash.copyPath(remaining, owned)
+	}
+	nwc.setChild(sub, nhv)
+
+	return nwc
+}
+
 // Len returns the length of the underlying hash.
-func (wc *WildcardHash) Len() int {
-	return len(wc.hash)
+func (wc *WildcardHashOf[V]) Len() int {
+	return len(wc.hash) + len(wc.globs)
 }
 
-func (wc *WildcardHash) String() string {
+func (wc *WildcardHashOf[V]) String() string {
 	var buf bytes.Buffer
 	wc.pretty(&buf, "")
 	return buf.String()
 }
 
-func (wc *WildcardHash) pretty(w io.Writer, prefix string) {
+// each calls fn for every child, the patterns coming last.
+func (wc *WildcardHashOf[V]) each(fn func(label string, hv *HashValueOf[V])) {
+	for k := range wc.hash {
+		fn(k, wc.hash[k])
+	}
+	for _, g := range wc.globs {
+		fn(g.pattern, g.hv)
+	}
+}
+
+func (wc *WildcardHashOf[V]) pretty(w io.Writer, prefix string) {
 	if prefix != "" {
 		prefix = prefix + "."
 	}
-	for k := range wc.hash {
-		v := wc.hash[k]
+	wc.each(func(k string, v *HashValueOf[V]) {
 		if v.typ > NodeHashValueType {
-			if v.hash.Len() == 0 {
-				fmt.Fprintf(w, "%s[%s]\n", prefix+k, v)
-				continue
-			}
-			if v.typ > NodeHashValueType {
-				fmt.Fprintf(w, "%s[%s]\n", prefix+k, v)
-			}
+			fmt.Fprintf(w, "%s[%s]\n", prefix+k, v)
 		}
 		v.hash.pretty(w, prefix+k)
-	}
+	})
 }
 
-// Walk walks the tree recursively.
-func (wc *WildcardHash) Walk(fn func(key string, value interface{})) {
-	wc.walk("", fn)
+// Walk walks the tree recursively in the order of walkSorted. The keys are
+// rebuilt from the labels: *.example.com or example.* for a wildcard which
+// matches the apex, **.example.com or example.** for one which does not, and
+// *.example.com or example.* for a single-label wildcard.
+func (wc *WildcardHashOf[V]) Walk(fn func(key string, value V)) {
+	reversed := wc.reversed()
+	wc.walkSorted(nil, false, func(labels []string, hv *HashValueOf[V], typ HashValueType, _ bool) bool {
+		fn(keyOf(labels, reversed, hv.typ, typ), hv.valueOf(typ))
+		return true
+	})
 }
 
-func (wc *WildcardHash) walk(prefix string, fn func(key string, value interface{})) {
-	if prefix != "" {
-		prefix = prefix + "."
+// reversed reports whether the labels are indexed from the last one, as
+// PrefixIndexer does.
+func (wc *WildcardHashOf[V]) reversed() bool {
+	sub, _, _ := wc.indexer("a.b", ".")
+	return sub == "b"
+}
+
+// keyOf rebuilds the key of the value of the type typ of a node whose types
+// are nodeTyp.
+func keyOf(labels []string, reversed bool, nodeTyp, typ HashValueType) string {
+	name := make([]string, len(labels))
+	for i, label := range labels {
+		if reversed {
+			i = len(labels) - 1 - i
+		}
+		name[i] = label
 	}
+
+	star := ""
+	switch {
+	case typ == SingleWildcardHashValueType:
+		star = "*"
+	case typ == WildcardHashValueType && nodeTyp&ApexHashValueType == ApexHashValueType:
+		star = "*"
+	case typ == WildcardHashValueType:
+		star = "**"
+	}
+	if star != "" {
+		if reversed {
+			name = append([]string{star}, name...)
+		} else {
+			name = append(name, star)
+		}
+	}
+	return strings.Join(name, ".")
+}
+
+// valueTypes are the types of the values of a node in the order of walkSorted.
+var valueTypes = [...]HashValueType{FullHashValueType, SingleWildcardHashValueType, WildcardHashValueType}
+
+// walkSorted calls fn for every value of the tree until fn returns false: the
+// values of a node come before its children, the full, the single-label
+// wildcard and the wildcard in this order, and the children are sorted by
+// label, the patterns coming last. labels holds the labels of the path to
+// the node in the order of the indexer, and glob is true if one of them is a
+// pattern. labels is only valid during the call.
+func (wc *WildcardHashOf[V]) walkSorted(labels []string, glob bool, fn func(labels []string, hv *HashValueOf[V], typ HashValueType, glob bool) bool) bool {
+	keys := make([]string, 0, len(wc.hash))
 	for k := range wc.hash {
-		v := wc.hash[k]
-		if v.typ > NodeHashValueType {
-			if v.hash.Len() == 0 {
-				if v.fullvalue != nil {
-					fn(prefix+k, v.fullvalue)
-				}
-				if v.wildcardvalue != nil {
-					fn(prefix+k, v.wildcardvalue)
-				}
-				continue
-			}
-			if v.typ > NodeHashValueType {
-				if v.fullvalue != nil {
-					fn(prefix+k, v.fullvalue)
-				}
-				if v.wildcardvalue != nil {
-					fn(prefix+k, v.wildcardvalue)
-				}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	visit := func(label string, hv *HashValueOf[V], glob bool) bool {
+		labels := append(labels, label)
+		for _, typ := range valueTypes {
+			if hv.typ&typ == typ && !fn(labels, hv, typ, glob) {
+				return false
 			}
 		}
-		v.hash.walk(prefix+k, fn)
+		return hv.hash.walkSorted(labels, glob, fn)
 	}
+
+	for _, k := range keys {
+		if !visit(k, wc.hash[k], glob) {
+			return false
+		}
+	}
+	for _, g := range wc.globs {
+		if !visit(g.pattern, g.hv, true) {
+			return false
+		}
+	}
+	return true
 }
 
-// Lookup lookups the key in trie tree.
-func (wc *WildcardHash) Lookup(key string) (*HashValue, HashValueType) {
+// hashMatch is a node which matched the key in lookupAll.
+type hashMatch[V any] struct {
+	hv  *HashValueOf[V]
+	typ HashValueType
+	// rest holds the part of the key consumed by a wildcard match, consumed
+	// being false when the wildcard matched the apex.
+	rest     string
+	consumed bool
+	// glob is true when a label of the key matched a pattern like api-*.
+	glob bool
+}
+
+// lookupAll calls fn for every node which matches the key, starting with the
+// one Lookup returns and without short-circuiting, until fn returns false.
+func (wc *WildcardHashOf[V]) lookupAll(key string, glob bool, fn func(m hashMatch[V]) bool) bool {
 	sub, remaining, success := wc.indexer(key, ".")
 
-	hash, ok := wc.hash[sub]
-	if !ok {
-		return nil, NodeHashValueType
+	if hash, ok := wc.hash[sub]; ok {
+		if !hash.lookupAll(remaining, success, glob, fn) {
+			return false
+		}
 	}
 
-	if hash.typ == NodeHashValueType { // intermediate layer
-		if !success {
-			return nil, NodeHashValueType
+	for _, g := range wc.globs {
+		if matchLabel(g.pattern, sub) {
+			if !g.hv.lookupAll(remaining, success, true, fn) {
+				return false
+			}
 		}
-		return hash.hash.Lookup(remaining)
 	}
 
-	if hash.typ&FullHashValueType == FullHashValueType {
-		if !success {
-			return hash, FullHashValueType
+	return true
+}
+
+func (hv *HashValueOf[V]) lookupAll(remaining string, success, glob bool, fn func(m hashMatch[V]) bool) bool {
+	if !success {
+		if hv.typ&FullHashValueType == FullHashValueType {
+			if !fn(hashMatch[V]{hv: hv, typ: FullHashValueType, glob: glob}) {
+				return false
+			}
 		}
+		if hv.typ&(WildcardHashValueType|ApexHashValueType) == WildcardHashValueType|ApexHashValueType {
+			return fn(hashMatch[V]{hv: hv, typ: WildcardHashValueType, glob: glob})
+		}
+		return true
+	}
+
+	if !hv.hash.lookupAll(remaining, glob, fn) {
+		return false
+	}
+
+	if hv.typ&SingleWildcardHashValueType == SingleWildcardHashValueType && isSingleLabel(remaining) {
+		if !fn(hashMatch[V]{hv: hv, typ: SingleWildcardHashValueType, rest: remaining, consumed: true, glob: glob}) {
+			return false
+		}
+	}
+
+	if hv.typ&WildcardHashValueType == WildcardHashValueType {
+		return fn(hashMatch[V]{hv: hv, typ: WildcardHashValueType, rest: remaining, consumed: true, glob: glob})
+	}
 
-		subh, typ := hash.hash.Lookup(remaining)
-		if typ > NodeHashValueType {
-			return subh, typ
+	return true
+}
+
+// Lookup lookups the key in trie tree.
+//
+// The deepest match wins: a full match, then a single-label wildcard, then a
+// wildcard. A label matches its own child before the patterns.
+func (wc *WildcardHashOf[V]) Lookup(key string) (*HashValueOf[V], HashValueType) {
+	sub, remaining, success := wc.indexer(key, ".")
+
+	if hash, ok := wc.hash[sub]; ok {
+		if hv, typ := hash.lookup(remaining, success); typ > NodeHashValueType {
+			return hv, typ
 		}
+	}
 
-		// continue see if it's wildcard
+	for _, g := range wc.globs {
+		if matchLabel(g.pattern, sub) {
+			if hv, typ := g.hv.lookup(remaining, success); typ > NodeHashValueType {
+				return hv, typ
+			}
+		}
 	}
 
-	if hash.typ&WildcardHashValueType == WildcardHashValueType {
-		if !success {
-			return hash, WildcardHashValueType
+	return nil, NodeHashValueType
+}
+
+func (hv *HashValueOf[V]) lookup(remaining string, success bool) (*HashValueOf[V], HashValueType) {
+	if !success {
+		if hv.typ&FullHashValueType == FullHashValueType {
+			return hv, FullHashValueType
 		}
-		subh, typ := hash.hash.Lookup(remaining)
-		if typ > NodeHashValueType {
-			return subh, typ
+		if hv.typ&(WildcardHashValueType|ApexHashValueType) == WildcardHashValueType|ApexHashValueType {
+			return hv, WildcardHashValueType
 		}
+		return nil, NodeHashValueType
+	}
+
+	subh, typ := hv.hash.Lookup(remaining)
+	if typ > NodeHashValueType {
+		return subh, typ
+	}
 
-		return hash, WildcardHashValueType
+	if hv.typ&SingleWildcardHashValueType == SingleWildcardHashValueType && isSingleLabel(remaining) {
+		return hv, SingleWildcardHashValueType
+	}
+
+	if hv.typ&WildcardHashValueType == WildcardHashValueType {
+		return hv, WildcardHashValueType
 	}
 
 	return nil, NodeHashValueType
 }
+
+func isSingleLabel(s string) bool {
+	return strings.IndexByte(s, '.') < 0
+}
@@ -92,3 +92,152 @@ func TestSimplePrefixWildcard(t *testing.T) {
 	require.True(t, ok)
 	require.Equal(t, "a.b.c.com", hv)
 }
+
+func TestWildcardLookupCapture(t *testing.T) {
+	pwc := NewPrefixWildcardOf[int]()
+	pwc.AddFull("www.example.com", 1)
+	pwc.AddWildcard("*.example.com", 2)
+	pwc.AddGlob(3)
+
+	swc := NewSuffixWildcardOf[int]()
+	swc.AddWildcard("example.*", 4)
+	swc.AddWildcard("www.example.*", 5)
+
+	for _, tt := range []struct {
+		lookup   func(key string) (int, string, string, bool)
+		input    string
+		expect   int
+		wildcard string
+		literal  string
+	}{
+		{pwc.LookupCapture, "www.example.com", 1, "", "www.example.com"},
+		{pwc.LookupCapture, "a.b.example.com", 2, "a.b", "example.com"},
+		{pwc.LookupCapture, "example.com", 2, "", "example.com"},
+		{pwc.LookupCapture, "example.org", 3, "example.org", ""},
+		{swc.LookupCapture, "example.co.uk", 4, "co.uk", "example"},
+		{swc.LookupCapture, "www.example.com", 5, "com", "www.example"},
+		{swc.LookupCapture, "example", 4, "", "example"},
+	} {
+		value, wildcard, literal, ok := tt.lookup(tt.input)
+		require.True(t, ok, tt.input)
+		require.Equal(t, tt.expect, value, tt.input)
+		require.Equal(t, tt.wildcard, wildcard, tt.input)
+		require.Equal(t, tt.literal, literal, tt.input)
+	}
+
+	_, _, _, ok := swc.LookupCapture("abcd.com")
+	require.False(t, ok)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		pwc.LookupCapture("a.b.example.com")
+		swc.LookupCapture("example.co.uk")
+	})
+	require.Zero(t, allocs)
+}
+
+func TestSingleWildcard(t *testing.T) {
+	wc := NewPrefixWildcardOf[string]()
+	wc.AddSingleWildcard("*.example.com", "*.example.com")
+	wc.AddWildcard("*.com", "*.com")
+
+	hv, ok := wc.Lookup("a.example.com")
+	require.True(t, ok)
+	require.Equal(t, "*.example.com", hv)
+
+	hv, ok = wc.Lookup("a.b.example.com")
+	require.True(t, ok)
+	require.Equal(t, "*.com", hv)
+
+	hv, ok = wc.Lookup("example.com")
+	require.True(t, ok)
+	require.Equal(t, "*.com", hv)
+
+	_, wildcard, literal, ok := wc.LookupCapture("a.example.com")
+	require.True(t, ok)
+	require.Equal(t, "a", wildcard)
+	require.Equal(t, "example.com", literal)
+
+	require.True(t, wc.DelSingleWildcard("*.example.com"))
+	require.False(t, wc.DelSingleWildcard("*.example.com"))
+	hv, ok = wc.Lookup("a.example.com")
+	require.True(t, ok)
+	require.Equal(t, "*.com", hv)
+}
+
+func TestMatchLabel(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		label   string
+		expect  bool
+	}{
+		{"*", "a", true},
+		{"*", "", true},
+		{"api-*", "api-1", true},
+		{"api-*", "api-", true},
+		{"api-*", "api", false},
+		{"*-canary", "web-canary", true},
+		{"*-canary", "web-canary2", false},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "abbbc", true},
+		{"a*b*c", "acb", false},
+		{"**", "abc", true},
+	} {
+		require.Equal(t, tt.expect, matchLabel(tt.pattern, tt.label), tt.pattern+" "+tt.label)
+	}
+}
+
+func TestLabelGlobOrder(t *testing.T) {
+	wc := NewPrefixWildcardOf[string]()
+	wc.AddFull("*.example.com", "*")
+	wc.AddFull("a*.example.com", "a*")
+	wc.AddFull("ab*.example.com", "ab*")
+	wc.AddFull("*b.example.com", "*b")
+
+	for _, tt := range []struct {
+		input  string
+		expect string
+	}{
+		{"abc.example.com", "ab*"},
+		{"ab.example.com", "ab*"},
+		{"acb.example.com", "a*"},
+		{"cb.example.com", "*b"},
+		{"c.example.com", "*"},
+	} {
+		hv, ok := wc.Lookup(tt.input)
+		require.True(t, ok, tt.input)
+		require.Equal(t, tt.expect, hv, tt.input)
+	}
+}
+
+func TestWildcardDel(t *testing.T) {
+	wc := NewPrefixWildcardOf[string]()
+	require.NoError(t, wc.AddFull("example.com", "full"))
+	require.NoError(t, wc.AddWildcard("*.example.com", "wildcard"))
+
+	// the full match leaves the wildcard of the same node alone
+	require.True(t, wc.DelFull("example.com"))
+	require.False(t, wc.DelFull("example.com"))
+	hv, ok := wc.Lookup("example.com")
+	require.True(t, ok)
+	require.Equal(t, "wildcard", hv)
+	hv, ok = wc.Lookup("a.example.com")
+	require.True(t, ok)
+	require.Equal(t, "wildcard", hv)
+
+	// a nested key which is not in the tree
+	require.False(t, wc.DelFull("a.example.com"))
+	require.False(t, wc.DelWildcard("*.a.example.com"))
+	require.False(t, wc.Del("*"))
+
+	require.True(t, wc.DelWildcard("*.example.com"))
+	require.False(t, wc.DelWildcard("*.example.com"))
+	_, ok = wc.Lookup("example.com")
+	require.False(t, ok)
+	require.Equal(t, 0, wc.wh.Len())
+
+	swc := NewSuffixWildcardOf[string]()
+	require.NoError(t, swc.Add("example.*", "wildcard"))
+	require.False(t, swc.Del("example"))
+	require.True(t, swc.Del("example.*"))
+	require.Equal(t, 0, swc.wh.Len())
+}